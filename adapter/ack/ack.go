@@ -2,6 +2,7 @@ package ack
 
 import (
 	"context"
+	"sync/atomic"
 	"time"
 
 	"github.com/forest33/tapir/business/entity"
@@ -14,7 +15,7 @@ type Ack struct {
 	log       *logger.Logger
 	cfg       *Config
 	sender    entity.AckSender
-	conn      *entity.Connection
+	conn      atomic.Pointer[entity.Connection]
 	sessionID uint32
 	senderCh  chan message
 	partOfRTO float64
@@ -37,13 +38,13 @@ func New(ctx context.Context, log *logger.Logger, cfg *Config, sender entity.Ack
 		log:       log.Duplicate(log.With().Str("layer", "ack").Logger()),
 		cfg:       cfg,
 		sender:    sender,
-		conn:      conn,
 		sessionID: sessionID,
 		senderCh:  make(chan message, cfg.MaxSize),
 		partOfRTO: cfg.WaitingTimePercentOfRTO / 100.0,
 	}
 
 	a.ctx, a.cancel = context.WithCancel(ctx)
+	a.conn.Store(conn)
 
 	go a.accumulator()
 
@@ -57,6 +58,11 @@ func (a *Ack) Push(msgID uint32, endpoint entity.PacketEndpoint) {
 	}
 }
 
+// SetConnection changes the connection used to send acknowledgements after the client address is changed
+func (a *Ack) SetConnection(conn *entity.Connection) {
+	a.conn.Store(conn)
+}
+
 func (a *Ack) Stop() {
 	a.cancel()
 }
@@ -81,13 +87,13 @@ func (a *Ack) accumulator() {
 				firstAckTime = time.Now().UnixNano()
 			}
 			added = ackIDs.Push(msg.endpoint, msg.id)
-			if time.Now().UnixNano()-firstAckTime >= int64(float64(a.conn.Retry.GetRTO().Nanoseconds())*a.partOfRTO) || !added {
+			if time.Now().UnixNano()-firstAckTime >= int64(float64(a.conn.Load().Retry.GetRTO().Nanoseconds())*a.partOfRTO) || !added {
 				send()
 				if !added {
 					ackIDs.Push(msg.endpoint, msg.id)
 				}
 			}
-		case <-time.After(time.Duration(float64(a.conn.Retry.GetRTO().Nanoseconds()) * a.partOfRTO)):
+		case <-time.After(time.Duration(float64(a.conn.Load().Retry.GetRTO().Nanoseconds()) * a.partOfRTO)):
 			send()
 		case <-a.ctx.Done():
 			return
@@ -106,7 +112,7 @@ func (a *Ack) sendACK(ackIDs *entity.MessageAcknowledgement) {
 		IsACK:     true,
 		Payload:   ackIDs,
 	}
-	if err := a.sender(ack, nil, a.conn, nil); err != nil {
+	if err := a.sender(ack, nil, a.conn.Load(), nil); err != nil {
 		a.log.Error().Err(err).
 			Str("type", ack.Type.String()).
 			Uint32("id", ack.ID).
@@ -117,7 +123,7 @@ func (a *Ack) sendACK(ackIDs *entity.MessageAcknowledgement) {
 		a.log.Debug().
 			Int("ack_size", ackIDs.GetMessagesCount()).
 			Interface("ack_id", ackIDs.Get()).
-			Float64("rto", a.conn.Retry.GetRTO().Seconds()).
+			Float64("rto", a.conn.Load().Retry.GetRTO().Seconds()).
 			Msg("sending acknowledgement")
 	}
 }
@@ -0,0 +1,18 @@
+//go:build darwin
+
+package client
+
+import (
+	"net"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface binds the socket to the network interface with IP_BOUND_IF or IPV6_BOUND_IF
+func bindToInterface(fd uintptr, network string, ifi *net.Interface) error {
+	if strings.HasSuffix(network, "6") {
+		return unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_BOUND_IF, ifi.Index)
+	}
+	return unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_BOUND_IF, ifi.Index)
+}
@@ -0,0 +1,14 @@
+//go:build linux
+
+package client
+
+import (
+	"net"
+
+	"golang.org/x/sys/unix"
+)
+
+// bindToInterface binds the socket to the network device with SO_BINDTODEVICE
+func bindToInterface(fd uintptr, _ string, ifi *net.Interface) error {
+	return unix.BindToDevice(int(fd), ifi.Name)
+}
@@ -0,0 +1,29 @@
+//go:build windows
+
+package client
+
+import (
+	"encoding/binary"
+	"net"
+	"strings"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	ipUnicastIf   = 31
+	ipv6UnicastIf = 31
+)
+
+// bindToInterface binds the socket to the network interface with IP_UNICAST_IF or IPV6_UNICAST_IF,
+// the IPv4 interface index is passed in network byte order
+func bindToInterface(fd uintptr, network string, ifi *net.Interface) error {
+	if strings.HasSuffix(network, "6") {
+		return windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IPV6, ipv6UnicastIf, ifi.Index)
+	}
+
+	var index [4]byte
+	binary.BigEndian.PutUint32(index[:], uint32(ifi.Index))
+
+	return windows.SetsockoptInt(windows.Handle(fd), windows.IPPROTO_IP, ipUnicastIf, int(binary.NativeEndian.Uint32(index[:])))
+}
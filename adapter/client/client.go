@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"net"
+	"syscall"
 	"time"
 
 	"github.com/forest33/tapir/business/entity"
@@ -23,16 +24,22 @@ type Client struct {
 	ackFactory          entity.NetworkAckFactory
 	packetDecoder       entity.PacketDecoder
 	addSessionStatistic entity.StatisticHandler
+	replayFilter        *entity.ReplayFilter
 }
 
 type Config struct {
 	Codec             codec.Codec
 	PrimaryEncryptor  entity.Encryptor
+	HeaderProtection  *codec.HeaderProtection
 	MTU               int
 	ReadBufferSize    int
 	WriteBufferSize   int
 	MultipathTCP      bool
 	KeepaliveInterval time.Duration
+	AddressFamily     entity.AddressFamily
+	ServerIPs         []net.IP
+	ReplayWindowSize  int
+	ReplayWindowTTL   int64
 	SocketTracing     bool
 }
 
@@ -54,20 +61,27 @@ func New(ctx context.Context, log *logger.Logger, cfg *Config, retryFactory enti
 		retryFactory:  retryFactory,
 		ackFactory:    ackFactory,
 		packetDecoder: packetDecoder,
+		replayFilter:  entity.NewReplayFilter(cfg.ReplayWindowSize, cfg.ReplayWindowTTL),
 	}, nil
 }
 
-func (c *Client) Run(host string, port uint16, proto entity.Protocol) (*entity.Connection, error) {
+// Run connects to the server port, the connection is bound to the uplink if it is not nil
+func (c *Client) Run(host string, port uint16, proto entity.Protocol, uplink *entity.Uplink) (*entity.Connection, error) {
+	ip, err := c.resolve(host, port)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := c.dialer(proto, uplink)
+	if err != nil {
+		return nil, err
+	}
+
 	switch proto {
 	case entity.ProtoTCP:
-		addr, err := net.ResolveTCPAddr(proto.String(), fmt.Sprintf("%s:%d", host, port))
-		if err != nil {
-			return nil, err
-		}
+		addr := &net.TCPAddr{IP: ip, Port: int(port)}
 
-		d := &net.Dialer{}
-		d.SetMultipathTCP(c.cfg.MultipathTCP)
-		cn, err := net.Dial(proto.String(), addr.String())
+		cn, err := d.Dial(proto.Network(ip), addr.String())
 		if err != nil {
 			return nil, err
 		}
@@ -95,25 +109,26 @@ func (c *Client) Run(host string, port uint16, proto entity.Protocol) (*entity.C
 		isMultipathTCP, _ := conn.MultipathTCP()
 		c.log.Info().
 			Str("addr", conn.RemoteAddr().String()).
+			Str("uplink", uplink.GetName()).
 			Bool("mptcp", isMultipathTCP).
 			Msgf("connection established")
 
 		return &entity.Connection{
-			TCPConn: conn,
-			Port:    port,
-			Addr:    conn.RemoteAddr(),
-			Proto:   entity.ProtoTCP,
+			TCPConn:      conn,
+			Port:         port,
+			Addr:         conn.RemoteAddr(),
+			Proto:        entity.ProtoTCP,
+			LegacyHeader: c.isLegacyHeader(),
+			Uplink:       uplink,
 		}, nil
 	case entity.ProtoUDP:
-		addr, err := net.ResolveUDPAddr(proto.String(), fmt.Sprintf("%s:%d", host, port))
-		if err != nil {
-			return nil, err
-		}
+		addr := &net.UDPAddr{IP: ip, Port: int(port)}
 
-		conn, err := net.DialUDP(proto.String(), nil, addr)
+		cn, err := d.Dial(proto.Network(ip), addr.String())
 		if err != nil {
 			return nil, err
 		}
+		conn := cn.(*net.UDPConn)
 
 		if c.cfg.ReadBufferSize > 0 {
 			if err := conn.SetReadBuffer(c.cfg.ReadBufferSize); err != nil {
@@ -127,16 +142,103 @@ func (c *Client) Run(host string, port uint16, proto entity.Protocol) (*entity.C
 		}
 
 		return &entity.Connection{
-			UDPConn: conn,
-			Addr:    addr,
-			Port:    port,
-			Proto:   entity.ProtoUDP,
+			UDPConn:      conn,
+			Addr:         addr,
+			Port:         port,
+			Proto:        entity.ProtoUDP,
+			LegacyHeader: c.isLegacyHeader(),
+			Uplink:       uplink,
 		}, nil
 	}
 
 	return nil, fmt.Errorf("unknown protocol %s", proto)
 }
 
+// dialer returns the dialer bound to the uplink, the uplink is a local IP address or a network interface name
+func (c *Client) dialer(proto entity.Protocol, uplink *entity.Uplink) (*net.Dialer, error) {
+	d := &net.Dialer{}
+	if proto == entity.ProtoTCP {
+		d.SetMultipathTCP(c.cfg.MultipathTCP)
+	}
+	if uplink == nil {
+		return d, nil
+	}
+
+	if ip := net.ParseIP(uplink.Name); ip != nil {
+		if proto == entity.ProtoTCP {
+			d.LocalAddr = &net.TCPAddr{IP: ip}
+		} else {
+			d.LocalAddr = &net.UDPAddr{IP: ip}
+		}
+		return d, nil
+	}
+
+	ifi, err := net.InterfaceByName(uplink.Name)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find uplink %s: %w", uplink.Name, err)
+	}
+
+	d.Control = func(network, _ string, rc syscall.RawConn) error {
+		var bindErr error
+		if err := rc.Control(func(fd uintptr) {
+			bindErr = bindToInterface(fd, network, ifi)
+		}); err != nil {
+			return err
+		}
+		if bindErr != nil {
+			return fmt.Errorf("failed to bind to uplink %s: %w", uplink.Name, bindErr)
+		}
+		return nil
+	}
+
+	return d, nil
+}
+
+// isLegacyHeader reports whether headers are encrypted with the primary encryptor,
+// in compatibility mode the client sends legacy headers to be able to connect to older servers
+func (c *Client) isLegacyHeader() bool {
+	return c.cfg.HeaderProtection.Mode() != entity.HeaderProtectionAEAD
+}
+
+// headerEncryptorLookup returns the lookup of the header keys of the session the connection belongs to
+func (c *Client) headerEncryptorLookup(conn *entity.Connection) codec.HeaderEncryptorLookup {
+	return func(uint32) entity.Encryptor {
+		return c.connectionEncryptor(conn)
+	}
+}
+
+// connectionEncryptor returns the encryptor of the connection or nil if the session isn't established yet
+func (c *Client) connectionEncryptor(conn *entity.Connection) entity.Encryptor {
+	if c.getUserEncryptor == nil {
+		return nil
+	}
+	enc, _ := c.getUserEncryptor(conn)
+	return enc
+}
+
+// resolve returns the server address for the port according to the address family preference.
+// The address is selected from the server addresses of the configuration if they are set,
+// they are resolved once and allowed by the routes and the kill switch of the interface.
+func (c *Client) resolve(host string, port uint16) (net.IP, error) {
+	if ip := net.ParseIP(host); ip != nil {
+		return ip, nil
+	}
+
+	ips := c.cfg.ServerIPs
+	if len(ips) == 0 {
+		var err error
+		if ips, err = net.DefaultResolver.LookupIP(c.ctx, "ip", host); err != nil {
+			return nil, err
+		}
+	}
+
+	if ip := c.cfg.AddressFamily.SelectIP(ips, port); ip != nil {
+		return ip, nil
+	}
+
+	return nil, fmt.Errorf("failed to resolve server address %s", host)
+}
+
 func (c *Client) SendAsync(msg *entity.Message, conn *entity.Connection) error {
 	var (
 		userEncryptor entity.Encryptor
@@ -149,10 +251,11 @@ func (c *Client) SendAsync(msg *entity.Message, conn *entity.Connection) error {
 			c.log.Error().Err(err).Uint32("session_id", conn.SessionID).Msg("failed to get connection encryptor")
 			return err
 		}
+		msg.KeyPhase = entity.GetKeyPhase(userEncryptor)
 	}
 
 	if conn.TCPConn != nil {
-		return c.sendAsyncTCP(msg, userEncryptor, conn.TCPConn)
+		return c.sendAsyncTCP(msg, userEncryptor, conn)
 	}
 
 	return c.sendAsyncUDP(msg, userEncryptor, conn, conn.Retry)
@@ -3,7 +3,6 @@ package client
 import (
 	"errors"
 	"io"
-	"net"
 	"time"
 
 	"github.com/forest33/tapir/business/entity"
@@ -31,7 +30,7 @@ func (c *Client) ReceiverTCP(conn *entity.Connection, sessionID uint32) {
 		}()
 
 		for {
-			msg, received, err = c.readTCP(conn.TCPConn)
+			msg, received, err = c.readTCP(conn)
 			if err != nil {
 				if entity.IsErrorInterruptingNetwork(err) {
 					return
@@ -44,7 +43,7 @@ func (c *Client) ReceiverTCP(conn *entity.Connection, sessionID uint32) {
 				if err != nil {
 					c.log.Error().Err(err).Uint32("session_id", conn.SessionID).Msg("failed to get connection encryptor")
 					return
-				} else if enc == nil {
+				} else if enc = entity.GetPhaseEncryptor(enc, msg.KeyPhase); enc == nil {
 					continue
 				}
 			} else {
@@ -76,6 +75,7 @@ func (c *Client) ReceiverTCP(conn *entity.Connection, sessionID uint32) {
 			c.addSessionStatistic(msg.SessionID, &entity.Statistic{
 				IncomingBytes:  uint64(received),
 				IncomingFrames: 1,
+				Uplink:         conn.Uplink.GetName(),
 			})
 
 			c.receiveMessageLog(msg.Payload, received, msg)
@@ -87,9 +87,9 @@ func (c *Client) ReceiverTCP(conn *entity.Connection, sessionID uint32) {
 	}()
 }
 
-func (c *Client) readTCP(conn *net.TCPConn) (*entity.Message, int, error) {
+func (c *Client) readTCP(conn *entity.Connection) (*entity.Message, int, error) {
 	var (
-		headerLength    = c.cfg.PrimaryEncryptor.GetLength(entity.HeaderSize)
+		headerLength    = c.cfg.HeaderProtection.Length(conn.LegacyHeader)
 		header          = make([]byte, headerLength)
 		receivedHeader  int
 		receivedPayload int
@@ -98,7 +98,7 @@ func (c *Client) readTCP(conn *net.TCPConn) (*entity.Message, int, error) {
 	)
 
 	for {
-		n, err := conn.Read(header[receivedHeader:])
+		n, err := conn.TCPConn.Read(header[receivedHeader:])
 		if err != nil {
 			if entity.IsErrorInterruptingNetwork(err) {
 				return nil, 0, err
@@ -113,7 +113,7 @@ func (c *Client) readTCP(conn *net.TCPConn) (*entity.Message, int, error) {
 		break
 	}
 
-	decryptedHeader, err = c.cfg.PrimaryEncryptor.Decrypt(header)
+	decryptedHeader, _, err = c.cfg.HeaderProtection.Unprotect(header, c.headerEncryptorLookup(conn))
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to decrypt header")
 		return nil, 0, nil
@@ -128,7 +128,7 @@ func (c *Client) readTCP(conn *net.TCPConn) (*entity.Message, int, error) {
 	if msg.PayloadLength > 0 {
 		msg.Payload = make([]byte, msg.PayloadLength)
 		for receivedPayload < int(msg.PayloadLength) {
-			n, err := conn.Read(msg.Payload.([]byte)[receivedPayload:])
+			n, err := conn.TCPConn.Read(msg.Payload.([]byte)[receivedPayload:])
 			if err != nil {
 				if errors.Is(err, io.EOF) {
 					return nil, 0, err
@@ -143,14 +143,14 @@ func (c *Client) readTCP(conn *net.TCPConn) (*entity.Message, int, error) {
 	return msg, headerLength + receivedPayload, nil
 }
 
-func (c *Client) sendAsyncTCP(msg *entity.Message, userEncryptor entity.Encryptor, conn *net.TCPConn) error {
+func (c *Client) sendAsyncTCP(msg *entity.Message, userEncryptor entity.Encryptor, conn *entity.Connection) error {
 	encodedHeader, encodedPayload, err := c.cfg.Codec.Marshal(msg)
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encode message")
 		return err
 	}
 
-	cypherHeader, err := c.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := c.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -173,7 +173,7 @@ func (c *Client) sendAsyncTCP(msg *entity.Message, userEncryptor entity.Encrypto
 	)
 
 	for sent < len(data) {
-		n, err = conn.Write(data[sent:])
+		n, err = conn.TCPConn.Write(data[sent:])
 		if err != nil {
 			return err
 		}
@@ -192,7 +192,7 @@ func (c *Client) sendSyncTCP(msg *entity.Message, conn *entity.Connection, timeo
 		return nil, nil, err
 	}
 
-	cypherHeader, err := c.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := c.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, nil)
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encrypt header")
 		return nil, nil, err
@@ -223,7 +223,7 @@ func (c *Client) sendSyncTCP(msg *entity.Message, conn *entity.Connection, timeo
 		return nil, nil, err
 	}
 
-	reply, n, err := c.readTCP(conn.TCPConn)
+	reply, n, err := c.readTCP(conn)
 	if err != nil {
 		return nil, nil, err
 	}
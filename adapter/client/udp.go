@@ -39,7 +39,7 @@ func (c *Client) ReceiverUDP(conn *entity.Connection, sessionID uint32) {
 		}()
 
 		for {
-			msg, addr, n, err = c.readUDP(conn.UDPConn)
+			msg, addr, n, err = c.readUDP(conn)
 			if err != nil {
 				if entity.IsErrorInterruptingNetwork(err) {
 					return
@@ -52,6 +52,7 @@ func (c *Client) ReceiverUDP(conn *entity.Connection, sessionID uint32) {
 			c.addSessionStatistic(msg.SessionID, &entity.Statistic{
 				IncomingBytes:  uint64(n),
 				IncomingFrames: 1,
+				Uplink:         conn.Uplink.GetName(),
 			})
 
 			if msg.Type == entity.MessageTypeKeepalive {
@@ -68,7 +69,7 @@ func (c *Client) ReceiverUDP(conn *entity.Connection, sessionID uint32) {
 				if err != nil {
 					c.log.Error().Err(err).Uint32("session_id", conn.SessionID).Msg("failed to get connection encryptor")
 					return
-				} else if enc == nil {
+				} else if enc = entity.GetPhaseEncryptor(enc, msg.KeyPhase); enc == nil {
 					continue
 				}
 			} else {
@@ -102,6 +103,14 @@ func (c *Client) ReceiverUDP(conn *entity.Connection, sessionID uint32) {
 				continue
 			} else if msg.IsSendACK() {
 				conn.Ack.Push(msg.ID, msg.GetEndpoint())
+				if !c.replayFilter.Check(msg.SessionID, msg.GetEndpoint(), msg.ID, msg.MonotonicID) {
+					c.addSessionStatistic(msg.SessionID, &entity.Statistic{ReplayedFrames: 1, Uplink: conn.Uplink.GetName()})
+					c.log.Debug().
+						Uint32("session_id", msg.SessionID).
+						Uint32("id", msg.ID).
+						Msg("replayed message dropped")
+					continue
+				}
 			}
 
 			if err = c.receiver(msg, conn); err != nil {
@@ -113,27 +122,29 @@ func (c *Client) ReceiverUDP(conn *entity.Connection, sessionID uint32) {
 	}()
 }
 
-func (c *Client) readUDP(conn *net.UDPConn) (*entity.Message, net.Addr, int, error) {
+func (c *Client) readUDP(conn *entity.Connection) (*entity.Message, net.Addr, int, error) {
 	var (
-		headerLength    = c.cfg.PrimaryEncryptor.GetLength(entity.HeaderSize)
-		buf             = make([]byte, c.cfg.PrimaryEncryptor.GetLength(c.cfg.MTU)+headerLength)
+		buf             = make([]byte, c.cfg.PrimaryEncryptor.GetLength(c.cfg.MTU)+c.cfg.HeaderProtection.MaxLength())
 		decryptedHeader []byte
+		legacy          bool
 	)
 
-	n, addr, err := conn.ReadFrom(buf)
+	n, addr, err := conn.UDPConn.ReadFrom(buf)
 	if err != nil {
 		return nil, nil, 0, err
 	}
-	if n < headerLength {
+	if n < c.cfg.HeaderProtection.MinLength() {
 		return nil, nil, 0, nil
 	}
 
-	decryptedHeader, err = c.cfg.PrimaryEncryptor.Decrypt(buf[:headerLength])
+	decryptedHeader, legacy, err = c.cfg.HeaderProtection.Unprotect(buf[:n], c.headerEncryptorLookup(conn))
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to decrypt header")
 		return nil, nil, 0, nil
 	}
 
+	headerLength := c.cfg.HeaderProtection.Length(legacy)
+
 	msg := &entity.Message{}
 	if err = c.cfg.Codec.UnmarshalHeader(decryptedHeader, msg); err != nil {
 		c.log.Error().Err(err).Msg("failed to unmarshal header")
@@ -182,7 +193,7 @@ func (c *Client) sendAsyncUDP(msg *entity.Message, userEncryptor entity.Encrypto
 		}
 	}
 
-	cypherHeader, err := c.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := c.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -219,7 +230,7 @@ func (c *Client) sendSyncUDP(msg *entity.Message, conn *entity.Connection, timeo
 		}
 	}
 
-	cypherHeader, err := c.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := c.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, nil)
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encrypt header")
 		return nil, nil, err
@@ -243,7 +254,7 @@ func (c *Client) sendSyncUDP(msg *entity.Message, conn *entity.Connection, timeo
 		return nil, nil, err
 	}
 
-	reply, addr, n, err := c.readUDP(conn.UDPConn)
+	reply, addr, n, err := c.readUDP(conn)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -291,7 +302,7 @@ func (c *Client) sendKeepalive(conn *entity.Connection, ack bool) {
 		return
 	}
 
-	cypherHeader, err := c.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := c.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, c.connectionEncryptor(conn))
 	if err != nil {
 		c.log.Error().Err(err).Msg("failed to encrypt keepalive header")
 		return
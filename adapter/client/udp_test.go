@@ -24,6 +24,7 @@ func TestSend(t *testing.T) {
 	cfg := &Config{
 		Codec:             usecase.GetCodec(log, mtu, entity.EncryptionAES256ECB, structs.Ref(false)),
 		PrimaryEncryptor:  usecase.GetEncryptor(strings.Repeat("1", 32), entity.EncryptionAES256ECB),
+		HeaderProtection:  usecase.GetHeaderProtection(strings.Repeat("1", 32), entity.EncryptionAES256ECB, entity.HeaderProtectionNameAEAD),
 		MTU:               mtu,
 		ReadBufferSize:    131071,
 		WriteBufferSize:   131071,
@@ -37,7 +38,7 @@ func TestSend(t *testing.T) {
 		t.Fatalf("failed to create client: %v", err)
 	}
 
-	conn, err := cli.Run("192.168.33.2", 33333, entity.ProtoUDP)
+	conn, err := cli.Run("192.168.33.2", 33333, entity.ProtoUDP, nil)
 	if err != nil {
 		t.Fatalf("failed to create connection: %v", err)
 	}
@@ -158,8 +158,11 @@ func (c *Client) getContext(ctx context.Context) (context.Context, context.Cance
 
 func connectionToEntity(c *apiV1.Connection) *entity.ConnectionInfo {
 	return &entity.ConnectionInfo{
-		ID:          int(c.Id),
-		IsConnected: c.IsConnected,
-		ConnectTs:   c.ConnectTs,
+		ID:               int(c.Id),
+		IsConnected:      c.IsConnected,
+		ConnectTs:        c.ConnectTs,
+		IsReconnecting:   c.IsReconnecting,
+		ReconnectAttempt: int(c.ReconnectAttempt),
+		NextReconnectTs:  c.NextReconnectTs,
 	}
 }
@@ -127,8 +127,11 @@ func (s *Server) Shutdown(_ context.Context, _ *empty.Empty) (*empty.Empty, erro
 
 func entityToConnection(c *entity.ConnectionInfo) *apiV1.Connection {
 	return &apiV1.Connection{
-		Id:          int32(c.ID),
-		IsConnected: c.IsConnected,
-		ConnectTs:   c.ConnectTs,
+		Id:               int32(c.ID),
+		IsConnected:      c.IsConnected,
+		ConnectTs:        c.ConnectTs,
+		IsReconnecting:   c.IsReconnecting,
+		ReconnectAttempt: int32(c.ReconnectAttempt),
+		NextReconnectTs:  c.NextReconnectTs,
 	}
 }
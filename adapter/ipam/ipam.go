@@ -0,0 +1,480 @@
+// Package ipam assigns the tunnel addresses of the sessions from the address pool of the server.
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/netip"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/logger"
+	"github.com/forest33/tapir/pkg/structs"
+)
+
+const (
+	// blockSize is the number of addresses of a session: server local, server remote, client local and client remote
+	blockSize = 4
+	// clientOffset is the position of the client local address in the block
+	clientOffset = 2
+)
+
+// IPAM assigns a block of addresses to every client. A client gets the same addresses when it reconnects
+// while the addresses are not assigned to another client, the released addresses are assigned again
+// when the pool is exhausted, starting with the least recently released ones. The block of the server
+// is reserved apart from the leases of the clients.
+type IPAM struct {
+	log      *logger.Logger
+	cfg      *Config
+	pool     *pool
+	pool6    *pool
+	leases   map[string]*lease
+	static   map[string]*lease
+	reserved *lease
+	sync.Mutex
+}
+
+type Config struct {
+	AddrMin   string
+	AddrMax   string
+	AddrMin6  string
+	AddrMax6  string
+	LeaseFile string
+}
+
+type lease struct {
+	ClientID   string     `json:"client_id"`
+	User       string     `json:"user,omitempty"`
+	Addr       netip.Addr `json:"addr"`
+	Addr6      netip.Addr `json:"addr6,omitzero"`
+	ReleasedAt int64      `json:"released_at,omitempty"`
+	active     bool
+}
+
+type pool struct {
+	min netip.Addr
+	max netip.Addr
+}
+
+// New creates the address manager and loads the leases saved to the lease file
+func New(log *logger.Logger, cfg *Config) (*IPAM, error) {
+	m := &IPAM{
+		log:    log.Duplicate(log.With().Str("layer", "ipam").Logger()),
+		cfg:    cfg,
+		leases: make(map[string]*lease),
+		static: make(map[string]*lease),
+	}
+
+	var err error
+	if m.pool, err = newPool(cfg.AddrMin, cfg.AddrMax); err != nil {
+		return nil, err
+	} else if m.pool == nil {
+		return nil, errors.New("address pool is not defined")
+	}
+	if m.pool6, err = newPool(cfg.AddrMin6, cfg.AddrMax6); err != nil {
+		return nil, err
+	}
+
+	if err := m.load(); err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// SetStatic reserves the static addresses of the users, the reserved addresses are not assigned to other users
+func (m *IPAM) SetStatic(users []*entity.User) error {
+	static := make(map[string]*lease, len(users))
+	owners := make(map[netip.Addr]string, len(users))
+
+	for _, u := range users {
+		if u.Address == "" && u.Address6 == "" {
+			continue
+		}
+
+		l := &lease{User: u.Name}
+		var err error
+		if l.Addr, err = m.pool.static(u.Address); err != nil {
+			return errors.Wrapf(err, "wrong address of user %s", u.Name)
+		}
+		if l.Addr6, err = m.pool6.static(u.Address6); err != nil {
+			return errors.Wrapf(err, "wrong IPv6 address of user %s", u.Name)
+		}
+
+		for _, addr := range []netip.Addr{l.Addr, l.Addr6} {
+			if !addr.IsValid() {
+				continue
+			}
+			if owner, ok := owners[addr]; ok {
+				return fmt.Errorf("address of user %s is already assigned to user %s", u.Name, owner)
+			}
+			owners[addr] = u.Name
+		}
+
+		static[u.Name] = l
+	}
+
+	m.Lock()
+	m.static = static
+	m.Unlock()
+
+	return nil
+}
+
+// Acquire returns the addresses of the client, the static addresses of the user are used if they are not
+// used by another client of the user. ErrAddressPoolExhausted is returned if all addresses are in use,
+// ErrAddressLeaseConflict is returned if the lease of the client is in use or belongs to another user.
+func (m *IPAM) Acquire(userName, clientID string) (entity.IfIP, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	static := m.static[userName]
+	if l, ok := m.leases[clientID]; ok {
+		if l.active || l.User != userName {
+			m.log.Warn().
+				Str("client_id", clientID).
+				Str("user", userName).
+				Str("owner", l.User).
+				Bool("active", l.active).
+				Msg("lease of the client is in use or belongs to another user")
+			return entity.IfIP{}, entity.ErrAddressLeaseConflict
+		}
+		if m.isAvailable(l, static) {
+			l.active = true
+			l.ReleasedAt = 0
+			m.save()
+			return l.ip(), nil
+		}
+		// the released lease of the user does not match the static addresses of the user anymore
+		delete(m.leases, clientID)
+	}
+
+	var (
+		used, reserved = m.usage()
+		l              = &lease{ClientID: clientID, User: userName, active: true}
+		err            error
+	)
+
+	var static4, static6 netip.Addr
+	if static != nil {
+		static4, static6 = static.Addr, static.Addr6
+	}
+
+	if l.Addr, err = m.assign(m.pool, static4, used, reserved); err != nil {
+		return entity.IfIP{}, err
+	}
+	if m.pool6 != nil {
+		if l.Addr6, err = m.assign(m.pool6, static6, used, reserved); err != nil {
+			return entity.IfIP{}, err
+		}
+	}
+
+	m.leases[clientID] = l
+	m.save()
+
+	return l.ip(), nil
+}
+
+// Reserve returns the addresses of the server, the first block of the pool not used by an active lease
+// and not reserved for a user is reserved once, the released leases of the block are removed.
+// The reserved block is not a lease, so it can not be taken over by a client ID.
+func (m *IPAM) Reserve() (entity.IfIP, error) {
+	m.Lock()
+	defer m.Unlock()
+
+	if m.reserved != nil {
+		return m.reserved.ip(), nil
+	}
+
+	var (
+		used, reserved = m.usage()
+		l              = &lease{active: true}
+		err            error
+	)
+
+	if l.Addr, err = m.reserve(m.pool, used, reserved); err != nil {
+		return entity.IfIP{}, err
+	}
+	if m.pool6 != nil {
+		if l.Addr6, err = m.reserve(m.pool6, used, reserved); err != nil {
+			return entity.IfIP{}, err
+		}
+	}
+
+	m.reserved = l
+	m.save()
+
+	return l.ip(), nil
+}
+
+// Release marks the addresses of the client as released, they are kept for the client until the pool is exhausted
+func (m *IPAM) Release(clientID string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if l, ok := m.leases[clientID]; ok && l.active {
+		l.active = false
+		l.ReleasedAt = time.Now().Unix()
+		m.save()
+	}
+}
+
+// isAvailable checks that the lease can be used by the client again, the lease of a user with static addresses
+// must have these addresses and the addresses must not be reserved for another user
+func (m *IPAM) isAvailable(l *lease, static *lease) bool {
+	if static != nil && (static.Addr.IsValid() && l.Addr != static.Addr || static.Addr6.IsValid() && l.Addr6 != static.Addr6) {
+		return false
+	}
+	for _, v := range m.static {
+		if v.User != l.User && (v.Addr == l.Addr || v.Addr6.IsValid() && v.Addr6 == l.Addr6) {
+			return false
+		}
+	}
+	return true
+}
+
+// usage returns the addresses used by the leases and the reserved block, and the static addresses of the users
+func (m *IPAM) usage() (map[netip.Addr]*lease, map[netip.Addr]bool) {
+	used := make(map[netip.Addr]*lease, len(m.leases)*2+2)
+	reserved := make(map[netip.Addr]bool, len(m.static)*2)
+
+	for _, v := range m.leases {
+		used[v.Addr] = v
+		if v.Addr6.IsValid() {
+			used[v.Addr6] = v
+		}
+	}
+	if m.reserved != nil {
+		used[m.reserved.Addr] = m.reserved
+		if m.reserved.Addr6.IsValid() {
+			used[m.reserved.Addr6] = m.reserved
+		}
+	}
+	for _, v := range m.static {
+		reserved[v.Addr] = true
+		reserved[v.Addr6] = true
+	}
+
+	return used, reserved
+}
+
+// reserve returns the first block of the pool not used by an active lease and not reserved for a user,
+// the released lease of the block is removed
+func (m *IPAM) reserve(p *pool, used map[netip.Addr]*lease, reserved map[netip.Addr]bool) (netip.Addr, error) {
+	for base := p.min; p.contains(base); base = next(base, blockSize) {
+		if l, ok := used[base]; (!ok || !l.active) && !reserved[base] {
+			m.removeLease(l, used)
+			return base, nil
+		}
+	}
+	return netip.Addr{}, entity.ErrAddressPoolExhausted
+}
+
+// assign returns the static address if it is not used by an active lease, otherwise the first free block
+// or the block of the least recently released lease. The leases of the assigned block are removed.
+func (m *IPAM) assign(p *pool, static netip.Addr, used map[netip.Addr]*lease, reserved map[netip.Addr]bool) (netip.Addr, error) {
+	if static.IsValid() {
+		if l, ok := used[static]; !ok || !l.active {
+			m.removeLease(l, used)
+			return static, nil
+		}
+		m.log.Warn().Str("addr", next(static, clientOffset).String()).Msg("static address is in use, assigning a dynamic address")
+	}
+
+	for base := p.min; p.contains(base); base = next(base, blockSize) {
+		if _, ok := used[base]; !ok && !reserved[base] {
+			return base, nil
+		}
+	}
+
+	var oldest *lease
+	for _, l := range m.leases {
+		if l.active || (p == m.pool && reserved[l.Addr]) || (p == m.pool6 && reserved[l.Addr6]) {
+			continue
+		}
+		if oldest == nil || l.ReleasedAt < oldest.ReleasedAt {
+			oldest = l
+		}
+	}
+	if oldest == nil {
+		return netip.Addr{}, entity.ErrAddressPoolExhausted
+	}
+
+	m.removeLease(oldest, used)
+
+	return structs.If(p == m.pool, oldest.Addr, oldest.Addr6), nil
+}
+
+func (m *IPAM) removeLease(l *lease, used map[netip.Addr]*lease) {
+	if l == nil {
+		return
+	}
+	delete(m.leases, l.ClientID)
+	delete(used, l.Addr)
+	delete(used, l.Addr6)
+}
+
+func (m *IPAM) load() error {
+	if m.cfg.LeaseFile == "" {
+		return nil
+	}
+
+	data, err := os.ReadFile(m.cfg.LeaseFile)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return errors.Wrapf(err, "failed to read lease file %s", m.cfg.LeaseFile)
+	}
+
+	var leases []*lease
+	if err := json.Unmarshal(data, &leases); err != nil {
+		return errors.Wrapf(err, "failed to parse lease file %s", m.cfg.LeaseFile)
+	}
+
+	used := make(map[netip.Addr]bool, len(leases)*2)
+	now := time.Now().Unix()
+
+	for _, l := range leases {
+		if !m.pool.isBlock(l.Addr) || used[l.Addr] {
+			continue
+		}
+		if m.pool6 == nil {
+			l.Addr6 = netip.Addr{}
+		} else if !m.pool6.isBlock(l.Addr6) || used[l.Addr6] {
+			continue
+		}
+		used[l.Addr], used[l.Addr6] = true, true
+
+		// the sessions are not restored after the restart
+		if l.ReleasedAt == 0 {
+			l.ReleasedAt = now
+		}
+		m.leases[l.ClientID] = l
+	}
+
+	m.log.Info().Int("count", len(m.leases)).Str("file", m.cfg.LeaseFile).Msg("leases loaded")
+
+	return nil
+}
+
+func (m *IPAM) save() {
+	if m.cfg.LeaseFile == "" {
+		return
+	}
+
+	leases := make([]*lease, 0, len(m.leases))
+	for _, l := range m.leases {
+		leases = append(leases, l)
+	}
+	slices.SortFunc(leases, func(a, b *lease) int { return a.Addr.Compare(b.Addr) })
+
+	data, err := json.MarshalIndent(leases, "", "  ")
+	if err != nil {
+		m.log.Error().Err(err).Msg("failed to marshal leases")
+		return
+	}
+
+	// the file is replaced at once, so the leases are not lost if the server is stopped while writing
+	tmp := m.cfg.LeaseFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		m.log.Error().Err(err).Str("file", tmp).Msg("failed to write lease file")
+		return
+	}
+	if err := os.Rename(tmp, m.cfg.LeaseFile); err != nil {
+		m.log.Error().Err(err).Str("file", m.cfg.LeaseFile).Msg("failed to write lease file")
+	}
+}
+
+func (l *lease) ip() entity.IfIP {
+	ip := entity.IfIP{
+		ServerLocal:  next(l.Addr, 0).AsSlice(),
+		ServerRemote: next(l.Addr, 1).AsSlice(),
+		ClientLocal:  next(l.Addr, 2).AsSlice(),
+		ClientRemote: next(l.Addr, 3).AsSlice(),
+	}
+	if l.Addr6.IsValid() {
+		ip.ServerLocal6 = next(l.Addr6, 0).AsSlice()
+		ip.ServerRemote6 = next(l.Addr6, 1).AsSlice()
+		ip.ClientLocal6 = next(l.Addr6, 2).AsSlice()
+		ip.ClientRemote6 = next(l.Addr6, 3).AsSlice()
+	}
+	return ip
+}
+
+func newPool(minAddr, maxAddr string) (*pool, error) {
+	if minAddr == "" && maxAddr == "" {
+		return nil, nil
+	}
+
+	p := &pool{}
+	var err error
+	if p.min, err = netip.ParseAddr(minAddr); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse address %s", minAddr)
+	}
+	if p.max, err = netip.ParseAddr(maxAddr); err != nil {
+		return nil, errors.Wrapf(err, "failed to parse address %s", maxAddr)
+	}
+	if p.min.Is4() != p.max.Is4() || !p.contains(p.min) {
+		return nil, fmt.Errorf("wrong address pool %s - %s", minAddr, maxAddr)
+	}
+
+	return p, nil
+}
+
+// static returns the first address of the block of the client address
+func (p *pool) static(s string) (netip.Addr, error) {
+	if s == "" {
+		return netip.Addr{}, nil
+	}
+	if p == nil {
+		return netip.Addr{}, fmt.Errorf("address pool of %s is not defined", s)
+	}
+
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Addr{}, errors.Wrapf(err, "failed to parse address %s", s)
+	}
+
+	base := prev(addr.Unmap(), clientOffset)
+	if !p.isBlock(base) {
+		return netip.Addr{}, fmt.Errorf("%s is not a client address of the pool %s - %s, the client addresses are %s, %s, ...",
+			s, p.min, p.max, next(p.min, clientOffset), next(p.min, clientOffset+blockSize))
+	}
+
+	return base, nil
+}
+
+// isBlock checks that the address is the first address of a block of the pool
+func (p *pool) isBlock(base netip.Addr) bool {
+	if !base.IsValid() || base.Is4() != p.min.Is4() || !p.contains(base) {
+		return false
+	}
+	a, b := base.As16(), p.min.As16()
+	return (a[15]-b[15])%blockSize == 0
+}
+
+// contains checks that all addresses of the block are in the pool
+func (p *pool) contains(base netip.Addr) bool {
+	last := next(base, blockSize-1)
+	return last.IsValid() && p.min.Compare(base) <= 0 && last.Compare(p.max) <= 0
+}
+
+func next(addr netip.Addr, n int) netip.Addr {
+	for i := 0; i < n && addr.IsValid(); i++ {
+		addr = addr.Next()
+	}
+	return addr
+}
+
+func prev(addr netip.Addr, n int) netip.Addr {
+	for i := 0; i < n && addr.IsValid(); i++ {
+		addr = addr.Prev()
+	}
+	return addr
+}
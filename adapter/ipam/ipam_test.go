@@ -0,0 +1,200 @@
+package ipam
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/logger"
+)
+
+func TestAcquire(t *testing.T) {
+	m, err := New(logger.NewDefault(), &Config{
+		AddrMin:  "10.0.0.0",
+		AddrMax:  "10.0.0.11",
+		AddrMin6: "fd00::",
+		AddrMax6: "fd00::ff",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := m.Acquire("user1", "client1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ip.ServerLocal.String() != "10.0.0.0" || ip.ClientLocal.String() != "10.0.0.2" || ip.ClientRemote.String() != "10.0.0.3" {
+		t.Fatalf("wrong addresses %v", ip)
+	}
+	if ip.ClientLocal6.String() != "fd00::2" {
+		t.Fatalf("wrong IPv6 address %s", ip.ClientLocal6)
+	}
+
+	if ip, err = m.Acquire("user2", "client2"); err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	if ip, err = m.Acquire("user3", "client3"); err != nil || ip.ClientLocal.String() != "10.0.0.10" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	if _, err = m.Acquire("user4", "client4"); !errors.Is(err, entity.ErrAddressPoolExhausted) {
+		t.Fatalf("pool should be exhausted: %v", err)
+	}
+
+	// the released addresses are kept for the client
+	m.Release("client2")
+	if ip, err = m.Acquire("user2", "client2"); err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+
+	// and reused when the pool is exhausted
+	m.Release("client2")
+	if ip, err = m.Acquire("user4", "client4"); err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	if ip, err = m.Acquire("user2", "client2"); !errors.Is(err, entity.ErrAddressPoolExhausted) {
+		t.Fatalf("pool should be exhausted: %v", err)
+	}
+}
+
+func TestStatic(t *testing.T) {
+	m, err := New(logger.NewDefault(), &Config{AddrMin: "10.0.0.0", AddrMax: "10.0.0.255"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := m.SetStatic([]*entity.User{{Name: "user1", Address: "10.0.0.5"}}); err == nil {
+		t.Fatal("address is not a client address")
+	}
+	if err := m.SetStatic([]*entity.User{{Name: "user1", Address: "10.1.0.2"}}); err == nil {
+		t.Fatal("address is not in the pool")
+	}
+	if err := m.SetStatic([]*entity.User{{Name: "user1", Address: "10.0.0.6"}, {Name: "user2", Address: "10.0.0.6"}}); err == nil {
+		t.Fatal("address is assigned to two users")
+	}
+	if err := m.SetStatic([]*entity.User{{Name: "user1", Address: "10.0.0.2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the static address is not assigned to other users
+	ip, err := m.Acquire("user2", "client2")
+	if err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	if ip, err = m.Acquire("user1", "client1"); err != nil || ip.ClientLocal.String() != "10.0.0.2" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+
+	// the second client of the user gets a dynamic address
+	if ip, err = m.Acquire("user1", "client3"); err != nil || ip.ClientLocal.String() != "10.0.0.10" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+
+	// the static address assigned to a user with a lease
+	if err := m.SetStatic([]*entity.User{{Name: "user2", Address: "10.0.0.2"}}); err != nil {
+		t.Fatal(err)
+	}
+	m.Release("client1")
+	m.Release("client2")
+	if ip, err = m.Acquire("user2", "client2"); err != nil || ip.ClientLocal.String() != "10.0.0.2" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+}
+
+func TestLeaseConflict(t *testing.T) {
+	m, err := New(logger.NewDefault(), &Config{AddrMin: "10.0.0.0", AddrMax: "10.0.0.255"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := m.Acquire("user1", "client1")
+	if err != nil || ip.ClientLocal.String() != "10.0.0.2" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+
+	// the active lease is not taken over by the same client ID
+	if _, err = m.Acquire("user1", "client1"); !errors.Is(err, entity.ErrAddressLeaseConflict) {
+		t.Fatalf("active lease should not be acquired again: %v", err)
+	}
+	if _, err = m.Acquire("user2", "client1"); !errors.Is(err, entity.ErrAddressLeaseConflict) {
+		t.Fatalf("active lease of another user should not be acquired: %v", err)
+	}
+
+	// the released lease is kept for its user
+	m.Release("client1")
+	if _, err = m.Acquire("user2", "client1"); !errors.Is(err, entity.ErrAddressLeaseConflict) {
+		t.Fatalf("released lease of another user should not be acquired: %v", err)
+	}
+	if ip, err = m.Acquire("user1", "client1"); err != nil || ip.ClientLocal.String() != "10.0.0.2" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+}
+
+func TestReserve(t *testing.T) {
+	m, err := New(logger.NewDefault(), &Config{
+		AddrMin:  "10.0.0.0",
+		AddrMax:  "10.0.0.15",
+		AddrMin6: "fd00::",
+		AddrMax6: "fd00::ff",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.SetStatic([]*entity.User{{Name: "user1", Address: "10.0.0.2"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ip, err := m.Acquire("user2", "client2")
+	if err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	m.Release("client2")
+
+	// the static and the active addresses are skipped, the released lease of the block is removed
+	ip, err = m.Reserve()
+	if err != nil || ip.ServerLocal.String() != "10.0.0.4" || ip.ServerLocal6.String() != "fd00::" {
+		t.Fatalf("wrong reserved addresses %s %s: %v", ip.ServerLocal, ip.ServerLocal6, err)
+	}
+	if ip, err = m.Reserve(); err != nil || ip.ServerLocal.String() != "10.0.0.4" {
+		t.Fatalf("reserved addresses are changed %s: %v", ip.ServerLocal, err)
+	}
+
+	// the reserved block is not assigned to clients, whatever the client ID is
+	for _, id := range []string{"", "client2"} {
+		ip, err = m.Acquire("user2", id)
+		if err != nil || ip.ClientLocal.String() == "10.0.0.6" {
+			t.Fatalf("reserved address is assigned to client %q: %s %v", id, ip.ClientLocal, err)
+		}
+	}
+}
+
+func TestLeaseFile(t *testing.T) {
+	cfg := &Config{
+		AddrMin:   "10.0.0.0",
+		AddrMax:   "10.0.0.255",
+		LeaseFile: filepath.Join(t.TempDir(), "leases.json"),
+	}
+
+	m, err := New(logger.NewDefault(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"client1", "client2", "client3"} {
+		if _, err := m.Acquire("user", id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Release("client1")
+
+	m, err = New(logger.NewDefault(), cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip, err := m.Acquire("user", "client2")
+	if err != nil || ip.ClientLocal.String() != "10.0.0.6" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+	if ip, err = m.Acquire("user", "client4"); err != nil || ip.ClientLocal.String() != "10.0.0.14" {
+		t.Fatalf("wrong address %s: %v", ip.ClientLocal, err)
+	}
+}
@@ -102,6 +102,28 @@ var (
 				{msg: &entity.Message{ID: 22, SessionID: 1, Type: entity.MessageTypeData}},
 			},
 		},
+		"monotonic_v1": {
+			input: []*testMessage{
+				{msg: &entity.Message{ID: 101, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				{msg: &entity.Message{ID: 103, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				{msg: &entity.Message{ID: 102, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				{msg: &entity.Message{ID: 102, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}}, // DUP
+				{msg: &entity.Message{ID: 104, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				nil, // <- idle
+				{msg: &entity.Message{ID: 201, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				{msg: &entity.Message{ID: 203, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+				{msg: &entity.Message{ID: 202, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}, MonotonicID: true}},
+			},
+			output: []*testMessage{
+				{msg: &entity.Message{ID: 101, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 102, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 103, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 104, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 201, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 202, SessionID: 1, Type: entity.MessageTypeData}},
+				{msg: &entity.Message{ID: 203, SessionID: 1, Type: entity.MessageTypeData}},
+			},
+		},
 		"with_dup_and_wrong_order": {
 			input: []*testMessage{
 				{msg: &entity.Message{ID: 1, SessionID: 1, Type: entity.MessageTypeData, Payload: []byte{}}},
@@ -270,6 +292,61 @@ func TestWrongOrderV1(t *testing.T) {
 	<-done
 }
 
+func TestMonotonicV1(t *testing.T) {
+	testCase := testCases["monotonic_v1"]
+
+	m, err := NewV1(context.Background(), logger.NewDefault(), &Config{
+		WaitingListMaxSize: 100,
+		WaitingListMaxTTL:  10,
+		StreamTTL:          1,
+		StreamCount:        1,
+	})
+	if err != nil {
+		t.Errorf("failed to create stream merger: %v", err)
+		return
+	}
+
+	var (
+		done       = make(chan struct{})
+		receiveIdx int
+	)
+
+	m.SetReceiverHandler(func(msg *entity.Message, conn *entity.Connection) error {
+		if testCase.output[receiveIdx].msg.ID != msg.ID {
+			t.Errorf("wrong message id should be: %d received: %d", testCase.output[receiveIdx].msg.ID, msg.ID)
+			done <- struct{}{}
+			return nil
+		}
+		receiveIdx++
+		if receiveIdx >= len(testCase.output) {
+			done <- struct{}{}
+		}
+		return nil
+	})
+
+	m.SetDisconnectHandler(func(_ *entity.Connection, _ error) {})
+
+	err = m.CreateStream(1)
+	if err != nil {
+		t.Errorf("failed to create stream: %v", err)
+		return
+	}
+
+	for _, req := range testCase.input {
+		if req == nil {
+			time.Sleep(2 * time.Second)
+			continue
+		}
+		err = m.Push(req.msg, nil)
+		if err != nil {
+			t.Errorf("failed add message: %v", err)
+			continue
+		}
+	}
+
+	<-done
+}
+
 func TestWrongOrderV2(t *testing.T) {
 	testCase := testCases["wrong_order_v2"]
 
@@ -42,6 +42,11 @@ func (m *V1) stream(sessionID uint32, ch chan *message) {
 
 		wl := getWaitingList(req.msg.GetEndpoint())
 
+		// a monotonic sender restarts an idle endpoint above its previous IDs instead of from 1
+		if req.msg.MonotonicID && !wl.isThisFirstMessage() && wl.isIdle(m.cfg.StreamTTL) {
+			wl.restart()
+		}
+
 		if m.cfg.Tracing {
 			m.log.Debug().
 				Uint32("id", req.msg.ID).
@@ -67,7 +72,7 @@ func (m *V1) stream(sessionID uint32, ch chan *message) {
 			continue
 		}
 
-		if (!wl.isThisFirstMessage() && (wl.getLastID()+1 == req.msg.ID || req.msg.ID == 0)) || (wl.isThisFirstMessage() && req.msg.ID == 1) {
+		if (!wl.isThisFirstMessage() && (wl.getLastID()+1 == req.msg.ID || req.msg.ID == 0)) || (wl.isThisFirstMessage() && (req.msg.ID == 1 || req.msg.MonotonicID)) {
 			wl.send(req)
 			wl.pop(nil)
 			continue
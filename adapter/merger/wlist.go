@@ -15,6 +15,7 @@ type waitingList struct {
 	data           []*message
 	dataSize       int
 	firstMessageTs int64
+	lastSentTs     int64
 	idsMap         map[uint32]struct{}
 	sender         waitingListSender
 	popLogger      waitingListLogger
@@ -67,6 +68,17 @@ func (wl *waitingList) isThisFirstMessage() bool {
 	return !wl.firstSent
 }
 
+// isIdle reports whether the waiting list is empty and no message has been sent for ttl seconds
+func (wl *waitingList) isIdle(ttl float64) bool {
+	return ttl > 0 && len(wl.data) == 0 && float64(time.Now().Unix()-wl.lastSentTs) >= ttl
+}
+
+// restart makes the next message the first message of the sequence
+func (wl *waitingList) restart() {
+	wl.lastID = 0
+	wl.firstSent = false
+}
+
 func (wl *waitingList) exists(id uint32) bool {
 	_, exists := wl.idsMap[id]
 	return exists
@@ -77,6 +89,7 @@ func (wl *waitingList) send(req *message) {
 	wl.sender(req)
 	wl.lastID = req.msg.ID
 	wl.firstSent = true
+	wl.lastSentTs = time.Now().Unix()
 }
 
 func (wl *waitingList) push(req *message) error {
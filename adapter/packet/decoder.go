@@ -1,7 +1,9 @@
 package packet
 
 import (
+	"bytes"
 	"encoding/binary"
+	"net/netip"
 
 	"github.com/forest33/tapir/business/entity"
 )
@@ -18,6 +20,13 @@ const (
 const (
 	endpointTypeIPv4 = iota + 1
 	endpointTypeIPv6
+	endpointTypeEthernet
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86DD
+	etherTypeVLAN = 0x8100
 )
 
 const (
@@ -34,6 +43,7 @@ type Decoder struct {
 
 type Config struct {
 	EndpointHashType EndpointHashType
+	Ethernet         bool // frames of a TAP device
 	Tracing          bool
 }
 
@@ -42,6 +52,9 @@ func New(cfg *Config) *Decoder {
 }
 
 func (d *Decoder) Decode(data []byte) (*entity.NetworkPacketInfo, error) {
+	if d.cfg.Ethernet {
+		return d.decodeEthernet(data)
+	}
 
 	if len(data) < IpPacketMinLength {
 		return nil, entity.ErrWrongPacketLength
@@ -78,21 +91,10 @@ func (d *Decoder) decodeIPv4(data []byte) (*entity.NetworkPacketInfo, error) {
 	}
 
 	pi := &entity.NetworkPacketInfo{}
-
-	var endpoint []byte
-	switch d.cfg.EndpointHashType {
-	case EndpointHashSourceAddress:
-		endpoint = data[12:16]
-	case EndpointHashDestinationAddress:
-		endpoint = data[16:20]
-	case EndpointHashFullAddress:
-		endpoint = append(data[12:16], data[16:20]...)
-	default:
-		panic("unknown endpoint hash type")
-	}
-
-	pi.Endpoint = entity.PacketEndpoint(fastHash(endpoint, endpointTypeIPv4))
+	pi.Endpoint, pi.PeerEndpoint = d.endpoints(data[12:16], data[16:20], endpointTypeIPv4)
 	pi.Protocol = entity.IPProtocol(data[9])
+	pi.Source = netip.AddrFrom4([4]byte(data[12:16]))
+	pi.Destination = netip.AddrFrom4([4]byte(data[16:20]))
 
 	return pi, nil
 }
@@ -102,24 +104,98 @@ func (d *Decoder) decodeIPv6(data []byte) (*entity.NetworkPacketInfo, error) {
 		return nil, entity.ErrWrongPacketLength
 	}
 
-	var endpoint []byte
+	//pi := entity.PacketInfoPool.Get()
+	pi := &entity.NetworkPacketInfo{}
+	pi.Endpoint, pi.PeerEndpoint = d.endpoints(data[8:24], data[24:40], endpointTypeIPv6)
+	pi.Protocol = entity.IPProtocol(data[6])
+	pi.Source = netip.AddrFrom16([16]byte(data[8:24]))
+	pi.Destination = netip.AddrFrom16([16]byte(data[24:40]))
+
+	return pi, nil
+}
+
+// endpoints returns the endpoint of the packet by the hash type and the endpoint of the other address,
+// which is the endpoint of the packet decoded with the opposite hash type on the other side of the tunnel
+func (d *Decoder) endpoints(src, dst []byte, typ uint64) (entity.PacketEndpoint, entity.PacketEndpoint) {
 	switch d.cfg.EndpointHashType {
 	case EndpointHashSourceAddress:
-		endpoint = data[8:24]
+		return entity.PacketEndpoint(fastHash(src, typ)), entity.PacketEndpoint(fastHash(dst, typ))
 	case EndpointHashDestinationAddress:
-		endpoint = data[24:40]
+		return entity.PacketEndpoint(fastHash(dst, typ)), entity.PacketEndpoint(fastHash(src, typ))
 	case EndpointHashFullAddress:
-		endpoint = append(data[8:24], data[24:40]...)
+		endpoint := entity.PacketEndpoint(fastHash(append(src, dst...), typ))
+		return endpoint, endpoint
 	default:
 		panic("unknown endpoint hash type")
 	}
+}
 
-	//pi := entity.PacketInfoPool.Get()
-	pi := &entity.NetworkPacketInfo{}
-	pi.Endpoint = entity.PacketEndpoint(fastHash(endpoint, endpointTypeIPv6))
-	pi.Protocol = entity.IPProtocol(data[6])
+// decodeEthernet decodes a frame of a TAP device. The endpoint does not depend on the hash type, it is the hash
+// of both IP addresses of the packet or of both MAC addresses of other frames, so the frame has the same endpoint
+// on both sides of the tunnel: the server numbers the frames it switches to a session by the endpoint
+// it decodes, and the client orders them by the endpoint it decodes.
+func (d *Decoder) decodeEthernet(data []byte) (*entity.NetworkPacketInfo, error) {
+	if len(data) < entity.EthernetHeaderLength {
+		return nil, entity.ErrWrongPacketLength
+	}
 
-	return pi, nil
+	offset := entity.EthernetHeaderLength
+	etherType := binary.BigEndian.Uint16(data[12:14])
+	if etherType == etherTypeVLAN {
+		if len(data) < entity.EthernetHeaderMaxLength {
+			return nil, entity.ErrWrongPacketLength
+		}
+		offset = entity.EthernetHeaderMaxLength
+		etherType = binary.BigEndian.Uint16(data[16:18])
+	}
+
+	var (
+		payload = data[offset:]
+		pi      *entity.NetworkPacketInfo
+		err     error
+	)
+
+	switch {
+	case etherType == etherTypeIPv4 && len(payload) >= IpPacketMinLength:
+		if pi, err = d.decodeIPv4(payload); err != nil {
+			return nil, err
+		}
+		pi.Endpoint = pairHash(payload[12:16], payload[16:20], endpointTypeIPv4)
+		pi.PeerEndpoint = pi.Endpoint
+	case etherType == etherTypeIPv6 && len(payload) >= 40:
+		if pi, err = d.decodeIPv6(payload); err != nil {
+			return nil, err
+		}
+		pi.Endpoint = pairHash(payload[8:24], payload[24:40], endpointTypeIPv6)
+		pi.PeerEndpoint = pi.Endpoint
+	default:
+		pi = &entity.NetworkPacketInfo{}
+		pi.Endpoint = pairHash(data[0:6], data[6:12], endpointTypeEthernet)
+		pi.PeerEndpoint = pi.Endpoint
+		payload = nil
+	}
+
+	pi.DestinationMAC = entity.HardwareAddr(data[0:6])
+	pi.SourceMAC = entity.HardwareAddr(data[6:12])
+
+	if !d.cfg.Tracing || payload == nil {
+		return pi, nil
+	}
+
+	return decodeLayers(payload, pi)
+}
+
+// pairHash returns the hash of both addresses in order, it is the same for both directions
+func pairHash(a, b []byte, typ uint64) entity.PacketEndpoint {
+	if bytes.Compare(a, b) > 0 {
+		a, b = b, a
+	}
+
+	var buf [32]byte
+	n := copy(buf[:], a)
+	n += copy(buf[n:], b)
+
+	return entity.PacketEndpoint(fastHash(buf[:n], typ))
 }
 
 // fnvHash is used by our fastHash functions, and implements the FNV hash
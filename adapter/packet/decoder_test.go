@@ -0,0 +1,123 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"testing"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+var (
+	macA = entity.HardwareAddr{0x02, 0, 0, 0, 0, 0x0a}
+	macB = entity.HardwareAddr{0x02, 0, 0, 0, 0, 0x0b}
+)
+
+func ethernetFrame(dst, src entity.HardwareAddr, etherType uint16, payload []byte) []byte {
+	frame := make([]byte, entity.EthernetHeaderLength, entity.EthernetHeaderLength+len(payload))
+	copy(frame[0:6], dst[:])
+	copy(frame[6:12], src[:])
+	binary.BigEndian.PutUint16(frame[12:14], etherType)
+	return append(frame, payload...)
+}
+
+func ipv4Packet(src, dst string) []byte {
+	data := make([]byte, IpPacketMinLength)
+	data[0] = 0x45
+	binary.BigEndian.PutUint16(data[2:4], IpPacketMinLength)
+	data[9] = byte(entity.IPProtocolUDP)
+	copy(data[12:16], netip.MustParseAddr(src).AsSlice())
+	copy(data[16:20], netip.MustParseAddr(dst).AsSlice())
+	return data
+}
+
+func TestDecodeEthernet(t *testing.T) {
+	for _, hashType := range []EndpointHashType{EndpointHashSourceAddress, EndpointHashDestinationAddress} {
+		d := New(&Config{EndpointHashType: hashType, Ethernet: true})
+
+		req, err := d.Decode(ethernetFrame(macB, macA, etherTypeIPv4, ipv4Packet("10.0.0.1", "10.0.0.2")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.SourceMAC != macA || req.DestinationMAC != macB {
+			t.Fatalf("wrong MAC addresses %s -> %s", req.SourceMAC, req.DestinationMAC)
+		}
+		if req.Protocol != entity.IPProtocolUDP || req.Destination.String() != "10.0.0.2" {
+			t.Fatalf("wrong IP packet info %d %s", req.Protocol, req.Destination)
+		}
+
+		resp, err := d.Decode(ethernetFrame(macA, macB, etherTypeIPv4, ipv4Packet("10.0.0.2", "10.0.0.1")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Endpoint != resp.Endpoint || req.PeerEndpoint != req.Endpoint {
+			t.Fatal("both directions should have the same endpoint")
+		}
+
+		other, err := d.Decode(ethernetFrame(macB, macA, etherTypeIPv4, ipv4Packet("10.0.0.1", "10.0.0.3")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if req.Endpoint == other.Endpoint {
+			t.Fatal("different addresses should have different endpoints")
+		}
+	}
+}
+
+func TestDecodeEthernetNonIP(t *testing.T) {
+	d := New(&Config{EndpointHashType: EndpointHashSourceAddress, Ethernet: true})
+
+	broadcast := entity.HardwareAddr{0xff, 0xff, 0xff, 0xff, 0xff, 0xff}
+	arp, err := d.Decode(ethernetFrame(broadcast, macA, 0x0806, make([]byte, 28)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !arp.DestinationMAC.IsMulticast() || arp.SourceMAC.IsMulticast() {
+		t.Fatalf("wrong multicast flags %s -> %s", arp.SourceMAC, arp.DestinationMAC)
+	}
+	if arp.Destination.IsValid() {
+		t.Fatalf("frame without IP packet has destination %s", arp.Destination)
+	}
+
+	// VLAN tagged IPv4 packet
+	tagged := append([]byte{0x00, 0x0a, 0x08, 0x00}, ipv4Packet("10.0.0.1", "10.0.0.2")...)
+	pi, err := d.Decode(ethernetFrame(macB, macA, etherTypeVLAN, tagged))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pi.Destination.String() != "10.0.0.2" {
+		t.Fatalf("wrong destination %s", pi.Destination)
+	}
+
+	if _, err := d.Decode(make([]byte, entity.EthernetHeaderLength-1)); err == nil {
+		t.Fatal("short frame should not be decoded")
+	}
+}
+
+func TestDecodePeerEndpoint(t *testing.T) {
+	src := New(&Config{EndpointHashType: EndpointHashSourceAddress})
+	dst := New(&Config{EndpointHashType: EndpointHashDestinationAddress})
+
+	bySrc, err := src.Decode(ipv4Packet("10.0.0.1", "10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	byDst, err := dst.Decode(ipv4Packet("10.0.0.1", "10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bySrc.Endpoint != byDst.PeerEndpoint || byDst.Endpoint != bySrc.PeerEndpoint {
+		t.Fatal("peer endpoint should be the endpoint of the opposite hash type")
+	}
+	if bySrc.Endpoint == bySrc.PeerEndpoint {
+		t.Fatal("endpoints of different addresses should differ")
+	}
+
+	full, err := New(&Config{EndpointHashType: EndpointHashFullAddress}).Decode(ipv4Packet("10.0.0.1", "10.0.0.2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if full.Endpoint != full.PeerEndpoint {
+		t.Fatal("peer endpoint of the full address hash should be the endpoint")
+	}
+}
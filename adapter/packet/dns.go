@@ -0,0 +1,89 @@
+package packet
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const (
+	dnsPort          = 53
+	udpHeaderLength  = 8
+	ipv6HeaderLength = 40
+)
+
+// DecodeDNS decodes the A and AAAA records of a DNS response,
+// it returns nil if the packet is not a DNS response
+func (d *Decoder) DecodeDNS(data []byte) (*entity.DNSResponse, error) {
+	first, ok := dnsResponseLayer(data)
+	if !ok {
+		return nil, nil
+	}
+
+	var (
+		ip4 layers.IPv4
+		ip6 layers.IPv6
+		udp layers.UDP
+		dns layers.DNS
+	)
+
+	parser := gopacket.NewDecodingLayerParser(first, &ip4, &ip6, &udp, &dns)
+	parser.IgnoreUnsupported = true
+
+	decodedLayers := make([]gopacket.LayerType, 0, 3)
+	if err := parser.DecodeLayers(data, &decodedLayers); err != nil {
+		return nil, err
+	}
+
+	if len(decodedLayers) == 0 || decodedLayers[len(decodedLayers)-1] != layers.LayerTypeDNS {
+		return nil, entity.ErrWrongPacketData
+	}
+	if !dns.QR || dns.ResponseCode != layers.DNSResponseCodeNoErr || len(dns.Questions) == 0 {
+		return nil, nil
+	}
+
+	resp := &entity.DNSResponse{
+		Question: strings.ToLower(string(dns.Questions[0].Name)),
+		Answers:  make([]entity.DNSAnswer, 0, len(dns.Answers)),
+	}
+
+	for _, a := range dns.Answers {
+		if a.Type != layers.DNSTypeA && a.Type != layers.DNSTypeAAAA {
+			continue
+		}
+		if addr, ok := netip.AddrFromSlice(a.IP); ok {
+			resp.Answers = append(resp.Answers, entity.DNSAnswer{Addr: addr.Unmap(), TTL: a.TTL})
+		}
+	}
+
+	return resp, nil
+}
+
+// dnsResponseLayer checks that the packet is a UDP datagram from the DNS port
+// without decoding it, most of the packets are not DNS responses
+func dnsResponseLayer(data []byte) (gopacket.LayerType, bool) {
+	if len(data) < IpPacketMinLength {
+		return 0, false
+	}
+
+	switch data[0] >> 4 {
+	case 4:
+		ihl := int(data[0]&0x0F) * 4
+		if entity.IPProtocol(data[9]) != entity.IPProtocolUDP || len(data) < ihl+udpHeaderLength {
+			return 0, false
+		}
+		return layers.LayerTypeIPv4, binary.BigEndian.Uint16(data[ihl:]) == dnsPort
+	case 6:
+		if len(data) < ipv6HeaderLength+udpHeaderLength || entity.IPProtocol(data[6]) != entity.IPProtocolUDP {
+			return 0, false
+		}
+		return layers.LayerTypeIPv6, binary.BigEndian.Uint16(data[ipv6HeaderLength:]) == dnsPort
+	}
+
+	return 0, false
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"math"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/forest33/tapir/business/entity"
@@ -31,7 +32,7 @@ type Retry struct {
 	sendData      entity.RetrySender
 	sendKeepalive entity.KeepaliveSender
 	disconnect    entity.DisconnectHandler
-	conn          *entity.Connection
+	conn          atomic.Pointer[entity.Connection]
 	messages      *sync.Map
 	srtt          float64
 	rttvar        float64
@@ -66,13 +67,13 @@ func New(ctx context.Context, log *logger.Logger, cfg *Config, dataSender entity
 		sendData:      dataSender,
 		sendKeepalive: keepaliveSender,
 		disconnect:    disconnect,
-		conn:          conn,
 		keepaliveCh:   make(chan *struct{}, cfg.KeepaliveProbes),
 		messages:      &sync.Map{},
 		rto:           initRTO,
 	}
 
 	r.ctx, r.cancel = context.WithCancel(ctx)
+	r.conn.Store(conn)
 
 	r.keepalive()
 
@@ -134,10 +135,20 @@ func (r *Retry) GetRTO() time.Duration {
 	return r.rto
 }
 
+// SetConnection changes the connection used to resend messages and send keepalives after the client address is changed
+func (r *Retry) SetConnection(conn *entity.Connection) {
+	r.conn.Store(conn)
+}
+
 func (r *Retry) Stop() {
 	r.cancel()
 }
 
+// IsStopped returns true if the retry is stopped, e.g. after the keepalive timeout of the connection
+func (r *Retry) IsStopped() bool {
+	return r.ctx.Err() != nil
+}
+
 func (r *Retry) timer(m *message, duration time.Duration) {
 	defer func() {
 		entity.MessagePool.Put(m.msg)
@@ -163,7 +174,7 @@ func (r *Retry) timer(m *message, duration time.Duration) {
 				return
 			}
 
-			if err := r.sendData(m.msg.Payload.([]byte), m.msg, r.conn); err != nil {
+			if err := r.sendData(m.msg.Payload.([]byte), m.msg, r.conn.Load()); err != nil {
 				r.log.Error().Err(err).
 					Uint32("id", m.msg.ID).
 					Uint64("endpoint", m.msg.GetEndpoint().Uint64()).
@@ -228,7 +239,7 @@ func (r *Retry) keepalive() {
 				lastAck = time.Now()
 				probes = 0
 				if m == nil {
-					r.sendKeepalive(r.conn, true)
+					r.sendKeepalive(r.conn.Load(), true)
 				}
 			case <-r.ctx.Done():
 				return
@@ -238,10 +249,10 @@ func (r *Retry) keepalive() {
 				}
 				if probes >= r.cfg.KeepaliveProbes {
 					r.Stop()
-					r.disconnect(r.conn, entity.ErrKeepaliveTimeoutExceeded)
+					r.disconnect(r.conn.Load(), entity.ErrKeepaliveTimeoutExceeded)
 					return
 				}
-				r.sendKeepalive(r.conn, false)
+				r.sendKeepalive(r.conn.Load(), false)
 				probes++
 			}
 		}
@@ -1,6 +1,9 @@
 package server
 
 import (
+	"fmt"
+	"net"
+	"net/netip"
 	"sync"
 
 	"github.com/forest33/tapir/business/entity"
@@ -15,11 +18,15 @@ var (
 type Config struct {
 	Codec            codec.Codec
 	PrimaryEncryptor entity.Encryptor
+	HeaderProtection *codec.HeaderProtection
 	ReadBufferSize   int
 	WriteBufferSize  int
 	MultipathTCP     bool
 	MTU              int
 	MaxSessionsCount int
+	AddressFamily    entity.AddressFamily
+	ReplayWindowSize int
+	ReplayWindowTTL  int64
 	Tracing          bool
 }
 
@@ -28,7 +35,82 @@ func (c Config) validate() error {
 	return nil
 }
 
+// listenAddress returns the network name and the address to listen on for the given host
+func (c Config) listenAddress(host string, port uint16, proto entity.Protocol) (string, *net.UDPAddr, error) {
+	var ip net.IP
+	if host == "" {
+		ip = c.AddressFamily.ListenIP()
+	} else if ip = net.ParseIP(host); ip == nil {
+		ips, err := net.LookupIP(host)
+		if err != nil {
+			return "", nil, err
+		}
+		if ip = c.AddressFamily.SelectIP(ips, port); ip == nil {
+			return "", nil, fmt.Errorf("failed to resolve address %s", host)
+		}
+	}
+
+	return proto.Network(ip), &net.UDPAddr{IP: ip, Port: int(port)}, nil
+}
+
+// headerEncryptorLookup returns the lookup of the header keys of the session the connection belongs to
+func headerEncryptorLookup(getter entity.EncryptorGetter, conn *entity.Connection) codec.HeaderEncryptorLookup {
+	return func(sessionID uint32) entity.Encryptor {
+		// the session of a message from an unknown address is found by the session ID of the header,
+		// the path is re-bound to the address when the client is roaming
+		if conn.SessionID == 0 {
+			conn.SessionID = sessionID
+		}
+		return connectionEncryptor(getter, conn)
+	}
+}
+
+// connectionEncryptor returns the encryptor of the connection or nil if the session isn't established yet
+func connectionEncryptor(getter entity.EncryptorGetter, conn *entity.Connection) entity.Encryptor {
+	if getter == nil {
+		return nil
+	}
+	enc, _ := getter(conn)
+	return enc
+}
+
 type connControl struct {
 	retry entity.NetworkRetry
 	ack   entity.NetworkAck
 }
+
+// connControls keeps the retries and acknowledgements of the UDP sessions by the client address,
+// so every uplink of the client using the same port keeps its own keepalive and acknowledgements
+type connControls map[uint32]map[netip.AddrPort]connControl
+
+// bind sets the retry and the acknowledgement of the client address to the connection, they are created by newControl
+// for a new address or if the retry of the address is stopped after the path is removed. The stopped controls
+// of the replaced paths are deleted when a control is created. It returns true for a new session.
+func (c connControls) bind(conn *entity.Connection, newControl func() connControl) bool {
+	paths, exists := c[conn.SessionID]
+	if !exists {
+		paths = make(map[netip.AddrPort]connControl, 1)
+		c[conn.SessionID] = paths
+	}
+
+	var addr netip.AddrPort
+	if udpAddr, ok := conn.Addr.(*net.UDPAddr); ok {
+		addr = udpAddr.AddrPort()
+	}
+
+	cc, ok := paths[addr]
+	if !ok || cc.retry.IsStopped() {
+		for a, pc := range paths {
+			if pc.retry.IsStopped() {
+				pc.ack.Stop()
+				delete(paths, a)
+			}
+		}
+		cc = newControl()
+		paths[addr] = cc
+	}
+	conn.Retry = cc.retry
+	conn.Ack = cc.ack
+
+	return !exists
+}
@@ -22,6 +22,7 @@ type V1 struct {
 	ackFactory          entity.NetworkAckFactory
 	packetDecoder       entity.PacketDecoder
 	addSessionStatistic entity.StatisticHandler
+	replayFilter        *entity.ReplayFilter
 	droppedSessions     map[uint32]struct{}
 	dsMux               sync.Mutex
 }
@@ -39,6 +40,7 @@ func NewV1(ctx context.Context, log *logger.Logger, cfg *Config, retryFactory en
 				retryFactory:    retryFactory,
 				ackFactory:      ackFactory,
 				packetDecoder:   packetDecoder,
+				replayFilter:    entity.NewReplayFilter(cfg.ReplayWindowSize, cfg.ReplayWindowTTL),
 				droppedSessions: make(map[uint32]struct{}),
 			}
 		}
@@ -48,17 +50,22 @@ func NewV1(ctx context.Context, log *logger.Logger, cfg *Config, retryFactory en
 }
 
 func (s *V1) Run(host string, port uint16, proto entity.Protocol) error {
+	network, addr, err := s.cfg.listenAddress(host, port, proto)
+	if err != nil {
+		return err
+	}
+
 	switch proto {
 	case entity.ProtoTCP:
 		lc := &net.ListenConfig{}
 		lc.SetMultipathTCP(s.cfg.MultipathTCP)
-		lst, err := lc.Listen(s.ctx, proto.String(), fmt.Sprintf("%s:%d", host, port))
+		lst, err := lc.Listen(s.ctx, network, addr.String())
 		if err != nil {
 			return err
 		}
 		s.listenerTCP(lst.(*net.TCPListener))
 	case entity.ProtoUDP:
-		conn, err := net.ListenUDP(proto.String(), &net.UDPAddr{IP: net.ParseIP(host), Port: int(port)})
+		conn, err := net.ListenUDP(network, addr)
 		if err != nil {
 			return err
 		}
@@ -90,10 +97,11 @@ func (s *V1) Send(msg *entity.Message, conn *entity.Connection) error {
 			s.log.Error().Err(err).Uint32("session_id", conn.SessionID).Msg("failed to get connection encryptor")
 			return err
 		}
+		msg.KeyPhase = entity.GetKeyPhase(userEncryptor)
 	}
 
 	if conn.TCPConn != nil {
-		return s.sendTCP(msg, userEncryptor, conn.TCPConn)
+		return s.sendTCP(msg, userEncryptor, conn)
 	}
 
 	return s.sendUDP(msg, userEncryptor, conn, conn.Retry)
@@ -119,4 +127,5 @@ func (s *V1) DropSession(sessionID uint32) {
 	s.dsMux.Lock()
 	defer s.dsMux.Unlock()
 	s.droppedSessions[sessionID] = struct{}{}
+	s.replayFilter.DeleteSession(sessionID)
 }
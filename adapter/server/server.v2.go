@@ -13,7 +13,6 @@ import (
 
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/logger"
-	"github.com/forest33/tapir/pkg/structs"
 )
 
 type V2 struct {
@@ -28,6 +27,7 @@ type V2 struct {
 	ackFactory          entity.NetworkAckFactory
 	packetDecoder       entity.PacketDecoder
 	addSessionStatistic entity.StatisticHandler
+	replayFilter        *entity.ReplayFilter
 	droppedSessions     map[uint32]struct{}
 	dsMux               sync.Mutex
 }
@@ -35,7 +35,7 @@ type V2 struct {
 type server struct {
 	srv               *V2
 	conn              *entity.Connection
-	connectionControl map[uint32]connControl
+	connectionControl connControls
 	curSessionsCount  int
 	maxSessionsCount  int
 }
@@ -53,6 +53,7 @@ func NewV2(ctx context.Context, log *logger.Logger, cfg *Config, retryFactory en
 				retryFactory:    retryFactory,
 				ackFactory:      ackFactory,
 				packetDecoder:   packetDecoder,
+				replayFilter:    entity.NewReplayFilter(cfg.ReplayWindowSize, cfg.ReplayWindowTTL),
 				droppedSessions: make(map[uint32]struct{}),
 			}
 		}
@@ -71,12 +72,15 @@ func (s *V2) Run(host string, port uint16, proto entity.Protocol) error {
 		},
 	}
 
-	host = structs.If(host != "", host, "0.0.0.0")
+	network, addr, err := s.cfg.listenAddress(host, port, proto)
+	if err != nil {
+		return err
+	}
 
 	go func() {
 		switch proto {
 		case entity.ProtoTCP:
-			err := gnet.Run(srv, fmt.Sprintf("tcp://%s:%d", host, port),
+			err := gnet.Run(srv, fmt.Sprintf("%s://%s", network, addr.String()),
 				gnet.WithMulticore(true),
 				gnet.WithReuseAddr(true),
 				gnet.WithReusePort(true))
@@ -84,8 +88,8 @@ func (s *V2) Run(host string, port uint16, proto entity.Protocol) error {
 				s.log.Fatalf("failed to start server: %v", err)
 			}
 		case entity.ProtoUDP:
-			srv.connectionControl = make(map[uint32]connControl, 10)
-			err := gnet.Run(srv, fmt.Sprintf("udp://%s:%d", host, port),
+			srv.connectionControl = make(connControls, 10)
+			err := gnet.Run(srv, fmt.Sprintf("%s://%s", network, addr.String()),
 				gnet.WithMulticore(true),
 				gnet.WithReuseAddr(true),
 				gnet.WithReusePort(true))
@@ -112,6 +116,7 @@ func (s *V2) Send(msg *entity.Message, conn *entity.Connection) error {
 			s.log.Error().Err(err).Uint32("session_id", conn.SessionID).Msg("failed to get connection encryptor")
 			return err
 		}
+		msg.KeyPhase = entity.GetKeyPhase(userEncryptor)
 	}
 
 	encodedHeader, encodedPayload, err := s.cfg.Codec.Marshal(msg)
@@ -120,7 +125,7 @@ func (s *V2) Send(msg *entity.Message, conn *entity.Connection) error {
 		return err
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -180,7 +185,7 @@ func (s *V2) sendKeepalive(conn *entity.Connection, ack bool) {
 		return
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, connectionEncryptor(s.getUserEncryptor, conn))
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt keepalive header")
 		return
@@ -212,7 +217,7 @@ func (s *V2) sendUDP(msg *entity.Message, userEncryptor entity.Encryptor, conn *
 		}
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -236,14 +241,16 @@ func (s *V2) sendUDP(msg *entity.Message, userEncryptor entity.Encryptor, conn *
 func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 	var (
 		s               = srv.srv
-		headerLength    = s.cfg.PrimaryEncryptor.GetLength(entity.HeaderSize)
+		headerLength    int
 		header          []byte
 		decryptedHeader []byte
+		legacy          bool
 		enc             entity.Encryptor
 		err             error
 	)
 
 	for {
+		headerLength = srv.peekHeaderLength(conn)
 		header, err = conn.Peek(headerLength)
 		if errors.Is(err, io.ErrShortBuffer) {
 			break
@@ -257,22 +264,39 @@ func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 			break
 		}
 
-		decryptedHeader, err = s.cfg.PrimaryEncryptor.Decrypt(header)
+		var port int
+		if srv.conn.Proto == entity.ProtoTCP {
+			port = conn.LocalAddr().(*net.TCPAddr).Port
+		} else {
+			port = conn.LocalAddr().(*net.UDPAddr).Port
+		}
+
+		connection := &entity.Connection{
+			GNetConn: conn,
+			Addr:     conn.RemoteAddr(),
+			Port:     uint16(port),
+			Proto:    srv.conn.Proto,
+		}
+
+		decryptedHeader, legacy, err = s.cfg.HeaderProtection.Unprotect(header, headerEncryptorLookup(s.getUserEncryptor, connection))
 		if err != nil {
 			s.log.Error().Err(err).
 				Int("header_size", len(header)).
-				Str("key", s.cfg.PrimaryEncryptor.GetKey()).
 				Str("header", fmt.Sprintf("% x", header)).
 				Msg("failed to decrypt header")
 			return gnet.Close
 		}
 
+		headerLength = s.cfg.HeaderProtection.Length(legacy)
+		if srv.conn.Proto == entity.ProtoTCP && conn.Context() == nil {
+			conn.SetContext(legacy)
+		}
+
 		msg := &entity.Message{}
 		if err = s.cfg.Codec.UnmarshalHeader(decryptedHeader, msg); err != nil {
 			s.log.Error().Err(err).
-				Int("encrypt_header_size", len(header)).
+				Int("encrypt_header_size", headerLength).
 				Int("header_size", len(decryptedHeader)).
-				Str("key", s.cfg.PrimaryEncryptor.GetKey()).
 				Str("header", fmt.Sprintf("% x", decryptedHeader)).
 				Msg("failed to unmarshal header")
 			return gnet.Close
@@ -307,27 +331,15 @@ func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 			OutgoingFrames: 1,
 		})
 
-		var port int
-		if srv.conn.Proto == entity.ProtoTCP {
-			port = conn.LocalAddr().(*net.TCPAddr).Port
-		} else {
-			port = conn.LocalAddr().(*net.UDPAddr).Port
-		}
-
-		connection := &entity.Connection{
-			GNetConn:  conn,
-			Addr:      conn.RemoteAddr(),
-			Port:      uint16(port),
-			Proto:     srv.conn.Proto,
-			SessionID: msg.SessionID,
-		}
+		connection.SessionID = msg.SessionID
+		connection.LegacyHeader = legacy
 
 		if msg.IsUserData() {
 			enc, err = s.getUserEncryptor(connection)
 			if err != nil {
 				s.log.Error().Err(err).Uint32("session_id", connection.SessionID).Msg("failed to get connection encryptor")
 				return gnet.Close
-			} else if enc == nil {
+			} else if enc = entity.GetPhaseEncryptor(enc, msg.KeyPhase); enc == nil {
 				break
 			}
 		} else {
@@ -358,17 +370,14 @@ func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 
 		if srv.conn.Proto == entity.ProtoUDP {
 			if msg.SessionID != 0 {
-				if cc, ok := srv.connectionControl[msg.SessionID]; !ok {
-					connection.Retry = s.retryFactory(s.ctx, s.originalLog, s.send, s.sendKeepalive, s.disconnect, connection)
-					connection.Ack = s.ackFactory(s.ctx, s.originalLog, s.sendUDP, connection, msg.SessionID)
-					srv.connectionControl[msg.SessionID] = connControl{
-						retry: connection.Retry,
-						ack:   connection.Ack,
+				newSession := srv.connectionControl.bind(connection, func() connControl {
+					return connControl{
+						retry: s.retryFactory(s.ctx, s.originalLog, s.send, s.sendKeepalive, s.disconnect, connection),
+						ack:   s.ackFactory(s.ctx, s.originalLog, s.sendUDP, connection, msg.SessionID),
 					}
+				})
+				if newSession {
 					srv.curSessionsCount++
-				} else {
-					connection.Retry = cc.retry
-					connection.Ack = cc.ack
 				}
 			}
 
@@ -386,6 +395,15 @@ func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 				break
 			} else if msg.IsSendACK() {
 				connection.Ack.Push(msg.ID, msg.GetEndpoint()) // panic: runtime error: invalid memory address or nil pointer dereference
+				if !s.replayFilter.Check(msg.SessionID, msg.GetEndpoint(), msg.ID, msg.MonotonicID) {
+					s.addSessionStatistic(msg.SessionID, &entity.Statistic{ReplayedFrames: 1})
+					s.log.Debug().
+						Uint32("session_id", msg.SessionID).
+						Uint32("id", msg.ID).
+						Str("remote", connection.Addr.String()).
+						Msg("replayed message dropped")
+					break
+				}
 			}
 		}
 
@@ -413,6 +431,23 @@ func (srv *server) OnTraffic(conn gnet.Conn) (action gnet.Action) {
 	return gnet.None
 }
 
+// peekHeaderLength returns the number of bytes containing the protected header.
+// The kind of headers of a TCP connection is detected by the first message and kept in the connection context,
+// UDP datagrams are detected one by one.
+func (srv *server) peekHeaderLength(conn gnet.Conn) int {
+	hp := srv.srv.cfg.HeaderProtection
+	if hp.IsFixed() {
+		return hp.MaxLength()
+	}
+	if srv.conn.Proto == entity.ProtoUDP {
+		return max(min(conn.InboundBuffered(), hp.MaxLength()), hp.MinLength())
+	}
+	if legacy, ok := conn.Context().(bool); ok {
+		return hp.Length(legacy)
+	}
+	return hp.MaxLength()
+}
+
 func (srv *server) OnBoot(eng gnet.Engine) (action gnet.Action) {
 	return gnet.None
 }
@@ -458,4 +493,5 @@ func (s *V2) DropSession(sessionID uint32) {
 	s.dsMux.Lock()
 	defer s.dsMux.Unlock()
 	s.droppedSessions[sessionID] = struct{}{}
+	s.replayFilter.DeleteSession(sessionID)
 }
@@ -60,17 +60,24 @@ func (s *V1) receiverTCP(conn *net.TCPConn) {
 			}
 		}()
 
+		// in compatibility mode the kind of headers is detected by the first message,
+		// which is always longer than any protected header, the rest of the header buffer is the payload
 		var (
-			headerLength    = s.cfg.PrimaryEncryptor.GetLength(entity.HeaderSize)
+			headerLength    = s.cfg.HeaderProtection.MaxLength()
 			header          = make([]byte, headerLength)
+			isDetected      = s.cfg.HeaderProtection.IsFixed()
+			surplus         []byte
 			receivedHeader  int
 			receivedPayload int
 			decryptedHeader []byte
+			legacy          bool
 			enc             entity.Encryptor
 		)
 
+		connection.LegacyHeader = s.cfg.HeaderProtection.Mode() == entity.HeaderProtectionLegacy
+
 		for {
-			n, err := conn.Read(header[receivedHeader:])
+			n, err := conn.Read(header[receivedHeader:headerLength])
 			if err != nil {
 				if entity.IsErrorInterruptingNetwork(err) {
 					return
@@ -86,29 +93,43 @@ func (s *V1) receiverTCP(conn *net.TCPConn) {
 			receivedHeader = 0
 			receivedPayload = 0
 
-			decryptedHeader, err = s.cfg.PrimaryEncryptor.Decrypt(header)
+			decryptedHeader, legacy, err = s.cfg.HeaderProtection.Unprotect(header[:headerLength], headerEncryptorLookup(s.getUserEncryptor, connection))
 			if err != nil {
 				s.log.Error().Err(err).
-					Int("header_size", len(header)).
-					Str("key", s.cfg.PrimaryEncryptor.GetKey()).
-					Str("header", fmt.Sprintf("% x", header)).
+					Int("header_size", headerLength).
+					Str("header", fmt.Sprintf("% x", header[:headerLength])).
 					Msg("failed to decrypt header")
 				return
 			}
 
+			if !isDetected {
+				isDetected = true
+				connection.LegacyHeader = legacy
+				headerLength = s.cfg.HeaderProtection.Length(legacy)
+				surplus = header[headerLength:]
+			}
+
 			msg := &entity.Message{}
 			if err = s.cfg.Codec.UnmarshalHeader(decryptedHeader, msg); err != nil {
 				s.log.Error().Err(err).
-					Int("encrypt_header_size", len(header)).
+					Int("encrypt_header_size", headerLength).
 					Int("header_size", len(decryptedHeader)).
-					Str("key", s.cfg.PrimaryEncryptor.GetKey()).
 					Str("header", fmt.Sprintf("% x", decryptedHeader)).
 					Msg("failed to unmarshal header")
 				return
 			}
 
+			if len(surplus) > int(msg.PayloadLength) {
+				s.log.Error().Err(entity.ErrWrongMessagePayloadSize).
+					Uint16("payload_size", msg.PayloadLength).
+					Msg("wrong first message length")
+				return
+			}
+
 			if msg.PayloadLength > 0 {
 				msg.Payload = make([]byte, msg.PayloadLength)
+				receivedPayload = copy(msg.Payload.([]byte), surplus)
+				surplus = nil
 				for receivedPayload < int(msg.PayloadLength) {
 					n, err = conn.Read(msg.Payload.([]byte)[receivedPayload:])
 					if err != nil {
@@ -132,7 +153,7 @@ func (s *V1) receiverTCP(conn *net.TCPConn) {
 				if err != nil {
 					s.log.Error().Err(err).Uint32("session_id", connection.SessionID).Msg("failed to get connection encryptor")
 					return
-				} else if enc == nil {
+				} else if enc = entity.GetPhaseEncryptor(enc, msg.KeyPhase); enc == nil {
 					continue
 				}
 			} else {
@@ -170,14 +191,14 @@ func (s *V1) receiverTCP(conn *net.TCPConn) {
 	}()
 }
 
-func (s *V1) sendTCP(msg *entity.Message, userEncryptor entity.Encryptor, conn *net.TCPConn) error {
+func (s *V1) sendTCP(msg *entity.Message, userEncryptor entity.Encryptor, conn *entity.Connection) error {
 	encodedHeader, encodedPayload, err := s.cfg.Codec.Marshal(msg)
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encode message")
 		return err
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -202,7 +223,7 @@ func (s *V1) sendTCP(msg *entity.Message, userEncryptor entity.Encryptor, conn *
 	)
 
 	for sent < len(data) {
-		n, err = conn.Write(data[sent:])
+		n, err = conn.TCPConn.Write(data[sent:])
 		if err != nil {
 			return err
 		}
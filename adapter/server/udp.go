@@ -12,7 +12,7 @@ import (
 
 func (s *V1) receiverUDP(conn *net.UDPConn) {
 	var (
-		connectionControl = make(map[uint32]connControl, 10)
+		connectionControl = make(connControls, 10)
 		decryptedHeader   []byte
 		enc               entity.Encryptor
 		maxSessionsCount  = s.cfg.MaxSessionsCount
@@ -22,8 +22,9 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 
 	go func() {
 		var (
-			headerLength = s.cfg.PrimaryEncryptor.GetLength(entity.HeaderSize)
-			buf          = make([]byte, s.cfg.PrimaryEncryptor.GetLength(s.cfg.MTU)+headerLength)
+			headerLength int
+			legacy       bool
+			buf          = make([]byte, s.cfg.PrimaryEncryptor.GetLength(s.cfg.MTU)+s.cfg.HeaderProtection.MaxLength())
 		)
 
 		for {
@@ -35,7 +36,7 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 				s.log.Error().Err(err).Msg("failed to read header from socket")
 				continue
 			}
-			if n < headerLength {
+			if n < s.cfg.HeaderProtection.MinLength() {
 				continue
 			}
 
@@ -46,10 +47,10 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 				Proto:   entity.ProtoUDP,
 			}
 
-			decryptedHeader, err = s.cfg.PrimaryEncryptor.Decrypt(buf[:headerLength])
+			decryptedHeader, legacy, err = s.cfg.HeaderProtection.Unprotect(buf[:n], headerEncryptorLookup(s.getUserEncryptor, connection))
 			if err != nil {
 				s.log.Error().Err(err).
-					Str("header", fmt.Sprintf("% x", buf[:headerLength])).
+					Str("header", fmt.Sprintf("% x", buf[:min(n, s.cfg.HeaderProtection.MaxLength())])).
 					Str("local", conn.LocalAddr().String()).
 					Str("remote", addr.String()).
 					Msg("failed to decrypt header")
@@ -57,6 +58,9 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 				continue
 			}
 
+			headerLength = s.cfg.HeaderProtection.Length(legacy)
+			connection.LegacyHeader = legacy
+
 			msg := &entity.Message{}
 			if err = s.cfg.Codec.UnmarshalHeader(decryptedHeader, msg); err != nil {
 				s.log.Error().Err(err).
@@ -99,7 +103,7 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 						Str("remote", addr.String()).
 						Msg("failed to get connection encryptor")
 					continue
-				} else if enc == nil {
+				} else if enc = entity.GetPhaseEncryptor(enc, msg.KeyPhase); enc == nil {
 					continue
 				}
 			} else {
@@ -134,17 +138,14 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 			}
 
 			if msg.SessionID != 0 {
-				if cc, ok := connectionControl[msg.SessionID]; !ok {
-					connection.Retry = s.retryFactory(s.ctx, s.originalLog, s.send, s.sendKeepalive, s.disconnect, connection)
-					connection.Ack = s.ackFactory(s.ctx, s.originalLog, s.sendUDP, connection, msg.SessionID)
-					connectionControl[msg.SessionID] = connControl{
-						retry: connection.Retry,
-						ack:   connection.Ack,
+				newSession := connectionControl.bind(connection, func() connControl {
+					return connControl{
+						retry: s.retryFactory(s.ctx, s.originalLog, s.send, s.sendKeepalive, s.disconnect, connection),
+						ack:   s.ackFactory(s.ctx, s.originalLog, s.sendUDP, connection, msg.SessionID),
 					}
+				})
+				if newSession {
 					curSessionsCount++
-				} else {
-					connection.Retry = cc.retry
-					connection.Ack = cc.ack
 				}
 			}
 
@@ -164,6 +165,15 @@ func (s *V1) receiverUDP(conn *net.UDPConn) {
 				continue
 			} else if msg.IsSendACK() {
 				connection.Ack.Push(msg.ID, msg.GetEndpoint()) // panic: runtime error: invalid memory address or nil pointer dereference
+				if !s.replayFilter.Check(msg.SessionID, msg.GetEndpoint(), msg.ID, msg.MonotonicID) {
+					s.addSessionStatistic(msg.SessionID, &entity.Statistic{ReplayedFrames: 1})
+					s.log.Debug().
+						Uint32("session_id", msg.SessionID).
+						Uint32("id", msg.ID).
+						Str("remote", addr.String()).
+						Msg("replayed message dropped")
+					continue
+				}
 			}
 
 			if err = s.receiver(msg, connection); err != nil {
@@ -209,7 +219,7 @@ func (s *V1) sendUDP(msg *entity.Message, userEncryptor entity.Encryptor, conn *
 		}
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, userEncryptor)
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt header")
 		return err
@@ -281,7 +291,7 @@ func (s *V1) sendKeepalive(conn *entity.Connection, ack bool) {
 		return
 	}
 
-	cypherHeader, err := s.cfg.PrimaryEncryptor.Encrypt(encodedHeader)
+	cypherHeader, err := s.cfg.HeaderProtection.Protect(encodedHeader, conn.LegacyHeader, connectionEncryptor(s.getUserEncryptor, conn))
 	if err != nil {
 		s.log.Error().Err(err).Msg("failed to encrypt keepalive header")
 		return
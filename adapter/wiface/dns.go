@@ -0,0 +1,166 @@
+package wiface
+
+import (
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/structs"
+)
+
+const (
+	dnsRouteMinTTL        = 5 * time.Minute
+	dnsRouteCheckInterval = 10 * time.Second
+)
+
+// dnsRoutes watches the DNS responses written to the interface and adds host routes through the tunnel
+// for the addresses of the include domains, the routes are removed when the TTL of the answers expires
+type dnsRoutes struct {
+	iface   *Iface
+	info    *entity.Interface
+	domains []string
+	hosts   map[netip.Addr]time.Time
+	done    chan struct{}
+	closed  bool
+	sync.RWMutex
+}
+
+func newDNSRoutes(iface *Iface, info *entity.Interface, domains []string) *dnsRoutes {
+	r := &dnsRoutes{
+		iface:   iface,
+		info:    info,
+		domains: make([]string, 0, len(domains)),
+		hosts:   make(map[netip.Addr]time.Time),
+		done:    make(chan struct{}),
+	}
+
+	for _, d := range domains {
+		r.domains = append(r.domains, strings.TrimSuffix(strings.ToLower(d), "."))
+	}
+
+	go r.expire()
+
+	return r
+}
+
+// inspect adds the routes before the response is written to the interface,
+// so the application never connects to the resolved address through the default gateway
+func (r *dnsRoutes) inspect(packet []byte) {
+	resp, err := r.iface.packetDecoder.DecodeDNS(packet)
+	if err != nil || resp == nil || len(resp.Answers) == 0 || !matchDomain(r.domains, resp.Question) {
+		return
+	}
+
+	now := time.Now()
+	added := make([]netip.Addr, 0, len(resp.Answers))
+
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	for _, a := range resp.Answers {
+		expiredAt := now.Add(max(time.Duration(a.TTL)*time.Second, dnsRouteMinTTL))
+		if exp, ok := r.hosts[a.Addr]; ok {
+			r.hosts[a.Addr] = structs.If(expiredAt.After(exp), expiredAt, exp)
+			continue
+		}
+		r.hosts[a.Addr] = expiredAt
+		added = append(added, a.Addr)
+	}
+	r.Unlock()
+
+	if len(added) != 0 {
+		r.route(added, true, resp.Question)
+	}
+}
+
+// contains returns true if the address has a host route through the tunnel
+func (r *dnsRoutes) contains(addr netip.Addr) bool {
+	r.RLock()
+	defer r.RUnlock()
+	_, ok := r.hosts[addr]
+	return ok
+}
+
+func (r *dnsRoutes) expire() {
+	ticker := time.NewTicker(dnsRouteCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.done:
+			return
+		case now := <-ticker.C:
+			expired := make([]netip.Addr, 0)
+			r.Lock()
+			for addr, exp := range r.hosts {
+				if now.After(exp) {
+					expired = append(expired, addr)
+					delete(r.hosts, addr)
+				}
+			}
+			r.Unlock()
+
+			if len(expired) != 0 {
+				r.route(expired, false, "")
+			}
+		}
+	}
+}
+
+// close removes all the host routes
+func (r *dnsRoutes) close() {
+	r.Lock()
+	if r.closed {
+		r.Unlock()
+		return
+	}
+	r.closed = true
+	close(r.done)
+
+	hosts := make([]netip.Addr, 0, len(r.hosts))
+	for addr := range r.hosts {
+		hosts = append(hosts, addr)
+	}
+	clear(r.hosts)
+	r.Unlock()
+
+	if len(hosts) != 0 {
+		r.route(hosts, false, "")
+	}
+}
+
+func (r *dnsRoutes) route(hosts []netip.Addr, isUp bool, domain string) {
+	prefixes := make([]netip.Prefix, 0, len(hosts))
+	for _, addr := range hosts {
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+
+	tmplVar := r.iface.templateVars(r.info)
+	tmplVar["include_routes"] = newTemplateRoutes(prefixes)
+	tmplVar["exclude_routes"] = []templateRoute(nil)
+
+	commands := structs.If(isUp, splitTunnelUp, splitTunnelDown)
+	if err := r.iface.execute(commands, tmplVar, structs.If(isUp, "domain route added", "domain route removed"), false); err != nil {
+		r.iface.log.Error().Err(err).Str("domain", domain).Msg("failed to execute domain route commands")
+	}
+}
+
+// matchDomain returns true if the name matches one of the patterns,
+// "*.example.com" matches the subdomains of example.com
+func matchDomain(patterns []string, name string) bool {
+	name = strings.TrimSuffix(name, ".")
+	for _, p := range patterns {
+		if suffix, ok := strings.CutPrefix(p, "*"); ok {
+			if strings.HasSuffix(name, suffix) {
+				return true
+			}
+		} else if name == p {
+			return true
+		}
+	}
+	return false
+}
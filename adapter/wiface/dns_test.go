@@ -0,0 +1,146 @@
+//go:build linux
+
+package wiface
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+
+	"github.com/forest33/tapir/adapter/packet"
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/logger"
+)
+
+type testCommandExecutor struct {
+	commands []string
+	sync.Mutex
+}
+
+func (e *testCommandExecutor) Run(command string) (string, error) {
+	e.Lock()
+	defer e.Unlock()
+	e.commands = append(e.commands, command)
+	return "", nil
+}
+
+func (e *testCommandExecutor) Start(string) error {
+	return nil
+}
+
+func (e *testCommandExecutor) RunAndWaitResponse(string, string, string) error {
+	return nil
+}
+
+type testInterfaceHandler struct{}
+
+func (testInterfaceHandler) Name() (string, error)       { return "tun0", nil }
+func (testInterfaceHandler) Close() error                { return nil }
+func (testInterfaceHandler) Read([]byte) (int, error)    { return 0, nil }
+func (testInterfaceHandler) Write(p []byte) (int, error) { return len(p), nil }
+
+func TestMatchDomain(t *testing.T) {
+	patterns := []string{"*.corp.example.com", "vpn.example.org"}
+
+	data := []struct {
+		name string
+		want bool
+	}{
+		{"www.corp.example.com", true},
+		{"a.b.corp.example.com.", true},
+		{"corp.example.com", false},
+		{"notcorp.example.com", false},
+		{"vpn.example.org", true},
+		{"www.vpn.example.org", false},
+	}
+
+	for _, d := range data {
+		if got := matchDomain(patterns, d.name); got != d.want {
+			t.Errorf("wrong result for %s: %t, should be %t", d.name, got, d.want)
+		}
+	}
+}
+
+func TestDNSRoutes(t *testing.T) {
+	cmd := &testCommandExecutor{}
+	iface := &Iface{
+		log:           logger.NewDefault(),
+		cfg:           &Config{Tunnel: &entity.TunnelConfig{MTU: 1400, IncludeDomains: []string{"*.corp.example.com"}}},
+		cmd:           cmd,
+		packetDecoder: packet.New(&packet.Config{EndpointHashType: packet.EndpointHashDestinationAddress}),
+	}
+	info := &entity.Interface{
+		Handler: testInterfaceHandler{},
+		IP:      entity.IfIP{ServerRemote: net.ParseIP("192.168.30.1")},
+	}
+
+	r := newDNSRoutes(iface, info, iface.cfg.Tunnel.IncludeDomains)
+	f := newRouteFilter(nil, nil, r, info)
+
+	r.inspect(testDNSResponse(t, "git.corp.example.com", "10.20.30.40"))
+	r.inspect(testDNSResponse(t, "www.example.com", "93.184.216.34"))
+	r.inspect(testDNSResponse(t, "git.corp.example.com", "10.20.30.40"))
+
+	if len(cmd.commands) != 1 || !strings.Contains(cmd.commands[0], "ip route add 10.20.30.40/32 dev tun0") {
+		t.Fatalf("wrong commands: %q", cmd.commands)
+	}
+
+	if !f.allowed(testPacket(netip.MustParseAddr("10.20.30.40"))) {
+		t.Error("10.20.30.40 should be allowed")
+	}
+	if f.allowed(testPacket(netip.MustParseAddr("93.184.216.34"))) {
+		t.Error("93.184.216.34 should be dropped")
+	}
+	if !f.allowed(testPacket(netip.MustParseAddr("192.168.30.1"))) {
+		t.Error("192.168.30.1 should be allowed")
+	}
+
+	r.close()
+
+	if len(cmd.commands) != 2 || !strings.Contains(cmd.commands[1], "ip route del 10.20.30.40/32 dev tun0") {
+		t.Fatalf("wrong commands: %q", cmd.commands)
+	}
+	if f.allowed(testPacket(netip.MustParseAddr("10.20.30.40"))) {
+		t.Error("10.20.30.40 should be dropped after close")
+	}
+}
+
+func testDNSResponse(t *testing.T, name, addr string) []byte {
+	ip := &layers.IPv4{
+		Version:  4,
+		TTL:      64,
+		Protocol: layers.IPProtocolUDP,
+		SrcIP:    net.ParseIP("10.0.0.53"),
+		DstIP:    net.ParseIP("192.168.30.2"),
+	}
+	udp := &layers.UDP{SrcPort: 53, DstPort: 40000}
+	if err := udp.SetNetworkLayerForChecksum(ip); err != nil {
+		t.Fatal(err)
+	}
+	dns := &layers.DNS{
+		ID:           1,
+		QR:           true,
+		ResponseCode: layers.DNSResponseCodeNoErr,
+		Questions:    []layers.DNSQuestion{{Name: []byte(name), Type: layers.DNSTypeA, Class: layers.DNSClassIN}},
+		Answers: []layers.DNSResourceRecord{{
+			Name:  []byte(name),
+			Type:  layers.DNSTypeA,
+			Class: layers.DNSClassIN,
+			TTL:   60,
+			IP:    net.ParseIP(addr),
+		}},
+	}
+
+	buf := gopacket.NewSerializeBuffer()
+	opts := gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}
+	if err := gopacket.SerializeLayers(buf, opts, ip, udp, dns); err != nil {
+		t.Fatalf("failed to serialize DNS response: %v", err)
+	}
+
+	return buf.Bytes()
+}
@@ -13,16 +13,38 @@ import (
 	"github.com/forest33/tapir/business/entity"
 )
 
+// splitTunnelUp installs the include routes through the tunnel and the exclude routes through the default gateway
+var splitTunnelUp = []string{
+	"{{ range .include_routes }}route add {{ if .IPv6 }}-inet6 {{ end }}-net {{ . }} -interface {{ $.tunnel_dev }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if .IPv6 }}{{ if $.gateway_ip6 }}route add -inet6 -net {{ . }} {{ $.gateway_ip6 }}; {{ end }}{{ else if $.gateway_ip }}route add -net {{ . }} {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+var splitTunnelDown = []string{
+	"{{ range .include_routes }}route delete {{ if .IPv6 }}-inet6 {{ end }}-net {{ . }} -interface {{ $.tunnel_dev }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if .IPv6 }}{{ if $.gateway_ip6 }}route delete -inet6 -net {{ . }} {{ $.gateway_ip6 }}; {{ end }}{{ else if $.gateway_ip }}route delete -net {{ . }} {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+// subnetRoutesUp routes the subnets of a site-to-site client to the tunnel
+var subnetRoutesUp = []string{
+	"{{ range .subnets }}route add {{ if .IPv6 }}-inet6 {{ end }}-net {{ . }} -interface {{ $.tunnel_dev }}; {{ end }}",
+}
+
+var subnetRoutesDown = []string{
+	"{{ range .subnets }}route delete {{ if .IPv6 }}-inet6 {{ end }}-net {{ . }} -interface {{ $.tunnel_dev }}; {{ end }}",
+}
+
 func (i *Iface) Create(ifc *entity.Interface) (*entity.Interface, error) {
 	i.Lock()
 	defer i.Unlock()
 
 	var err error
 	ifc.Handler, err = func() (entity.InterfaceHandler, error) {
-		if i.interfaceCreatorFunc == nil {
-			return tun.CreateTUN("utun", i.cfg.Tunnel.MTU)
+		if i.interfaceCreatorFunc == nil && ifc.Type == entity.DeviceTypeTAP {
+			return nil, errTAPNotSupported
+		} else if i.interfaceCreatorFunc == nil {
+			return tun.CreateTUN("utun", i.getMTU(ifc))
 		}
-		return i.interfaceCreatorFunc(i.cfg.Tunnel.MTU)
+		return i.interfaceCreatorFunc(i.getMTU(ifc))
 	}()
 	if err != nil {
 		return nil, err
@@ -5,7 +5,10 @@ import (
 	"fmt"
 	"io/fs"
 	"net"
+	"net/netip"
 	"runtime"
+	"slices"
+	"strings"
 	"sync"
 	"text/template"
 	"time"
@@ -26,14 +29,26 @@ type Iface struct {
 	interfaceStartupFunc interfaceStartupFunc
 	defaultGatewayIP     string
 	defaultGatewayDev    string
+	defaultGatewayIP6    string
+	defaultGatewayDev6   string
+	serverIP             string
+	serverIP6            string
+	serverIPs            templateList
+	serverIPs6           templateList
 	deviceIndex          string
+	includeRoutes        []netip.Prefix
+	excludeRoutes        []netip.Prefix
+	dnsRoutes            *dnsRoutes
+	killSwitchVars       map[string]any
 	seqPool              *endpointSequencePool
 	sync.Mutex
 }
 
 type Config struct {
 	Tracing              bool
-	ServerHost           string
+	ServerIPs            []net.IP
+	ServerPortMin        uint16
+	ServerPortMax        uint16
 	Tunnel               *entity.TunnelConfig
 	InterfaceCreatorFunc interfaceCreatorFunc
 	InterfaceStartupFunc interfaceStartupFunc
@@ -48,6 +63,8 @@ type endpointSequence struct {
 	ts int64
 }
 
+var errTAPNotSupported = errors.New("TAP devices are supported on Linux only")
+
 const (
 	initialEndpoints   = 100
 	incrementEndpoints = 10
@@ -65,14 +82,27 @@ func New(log *logger.Logger, cfg *Config, cmd entity.CommandExecutor, decoder en
 
 	ifc.interfaceStartupFunc = structs.If(cfg.InterfaceStartupFunc == nil, ifc.startup, cfg.InterfaceStartupFunc)
 
-	if cfg.ServerHost != "" {
-		ips, err := net.LookupIP(cfg.ServerHost)
-		if err != nil {
-			return nil, err
-		} else if len(ips) == 0 {
-			return nil, fmt.Errorf("failed to resolve server address %s", cfg.ServerHost)
+	var err error
+	if ifc.includeRoutes, err = parsePrefixes(cfg.Tunnel.IncludeRoutes); err != nil {
+		return nil, err
+	}
+	if ifc.excludeRoutes, err = parsePrefixes(cfg.Tunnel.ExcludeRoutes); err != nil {
+		return nil, err
+	}
+
+	// the routes use the first address of each family, it is the address the client selects
+	for _, ip := range cfg.ServerIPs {
+		if ip.To4() != nil {
+			ifc.serverIPs = append(ifc.serverIPs, ip.String())
+		} else {
+			ifc.serverIPs6 = append(ifc.serverIPs6, ip.String())
 		}
-		cfg.ServerHost = ips[0].String()
+	}
+	if len(ifc.serverIPs) != 0 {
+		ifc.serverIP = ifc.serverIPs[0]
+	}
+	if len(ifc.serverIPs6) != 0 {
+		ifc.serverIP6 = ifc.serverIPs6[0]
 	}
 
 	return ifc, nil
@@ -82,6 +112,12 @@ func (i *Iface) listen(ifc *entity.Interface) {
 	ifName, _ := ifc.Name()
 	i.log.Debug().Str("device", ifName).Msg("listening network interface")
 
+	// the routes filter the IP packets of the TUN device only
+	isTUN := ifc.Type != entity.DeviceTypeTAP
+	if isTUN && len(i.cfg.Tunnel.IncludeDomains) != 0 {
+		i.dnsRoutes = newDNSRoutes(i, ifc, i.cfg.Tunnel.IncludeDomains)
+	}
+
 	go func() {
 		defer func() {
 			if err := i.Close(ifc); err != nil {
@@ -94,18 +130,29 @@ func (i *Iface) listen(ifc *entity.Interface) {
 			endpointsMap    = make(map[entity.PacketEndpoint]*endpointSequence, initialEndpoints)
 			maxEndpoints    = initialEndpoints
 			endpointsMapLen int
+			lastID          uint32
 		)
 
 		getMessageID := func(endpointID entity.PacketEndpoint) uint32 {
 			if _, ok := endpointsMap[endpointID]; !ok {
 				endpointsMap[endpointID] = endpointsPool.get()
 				endpointsMapLen++
+				// the peer expects monotonic IDs, so a new or evicted endpoint continues above all issued IDs
+				if ifc.MonotonicID {
+					endpointsMap[endpointID].id = lastID
+				}
 			}
 			endpointsMap[endpointID].id++
 			endpointsMap[endpointID].ts = time.Now().Unix()
+			lastID = max(lastID, endpointsMap[endpointID].id)
 			return endpointsMap[endpointID].id
 		}
 
+		var filter *routeFilter
+		if isTUN {
+			filter = newRouteFilter(i.includeRoutes, i.excludeRoutes, i.dnsRoutes, ifc)
+		}
+
 		buf := make([]byte, i.cfg.Tunnel.MTU)
 		for {
 			n, err := ifc.Handler.Read(buf)
@@ -116,6 +163,10 @@ func (i *Iface) listen(ifc *entity.Interface) {
 				return
 			}
 
+			if filter != nil && !filter.allowed(buf[:n]) {
+				continue
+			}
+
 			msg := entity.MessagePool.Get(n)
 			//msg := &entity.Message{}
 			//msg.Payload = make([]byte, n)
@@ -126,6 +177,7 @@ func (i *Iface) listen(ifc *entity.Interface) {
 			}
 
 			msg.ID = getMessageID(msg.GetEndpoint())
+			msg.MonotonicID = ifc.MonotonicID
 			copy(msg.Payload.([]byte), buf[:n])
 			msg.PayloadLength = uint16(n)
 			msg.PacketInfo.IfName = ifName
@@ -158,10 +210,21 @@ func (i *Iface) listen(ifc *entity.Interface) {
 }
 
 func (i *Iface) Write(ifc *entity.Interface, data interface{}) error {
+	if i.dnsRoutes != nil {
+		i.dnsRoutes.inspect(data.([]byte))
+	}
 	_, err := ifc.Handler.Write(data.([]byte))
 	return err
 }
 
+// getMTU returns the MTU of the device, the negotiated MTU if it is set
+func (i *Iface) getMTU(ifc *entity.Interface) int {
+	if ifc.Network != nil && ifc.Network.MTU != 0 {
+		return ifc.Network.MTU
+	}
+	return i.cfg.Tunnel.MTU
+}
+
 func (i *Iface) startup(info *entity.Interface, isUp bool) error {
 	commands, ok := i.cfg.Tunnel.InterfaceUp[runtime.GOOS]
 	if !isUp {
@@ -171,39 +234,88 @@ func (i *Iface) startup(info *entity.Interface, isUp bool) error {
 		return fmt.Errorf("failed to get network startup configuration for OS %s", runtime.GOOS)
 	}
 
-	if len(commands) == 0 {
-		return nil
+	// the split tunnel routes are installed after the startup commands and removed before them
+	if isUp {
+		commands = slices.Concat(commands, splitTunnelUp)
+	} else {
+		commands = slices.Concat(splitTunnelDown, commands)
 	}
 
+	return i.execute(commands, i.templateVars(info), structs.If(isUp, "interface up", "interface down"), false)
+}
+
+func (i *Iface) templateVars(info *entity.Interface) map[string]any {
 	ifName, _ := info.Handler.Name()
-	tmplVar := map[string]string{
-		"mtu":                     fmt.Sprintf("%d", i.cfg.Tunnel.MTU),
-		"client_tunnel_local_ip":  info.IP.ClientLocal.String(),
-		"client_tunnel_remote_ip": info.IP.ClientRemote.String(),
-		"server_tunnel_local_ip":  info.IP.ServerLocal.String(),
-		"server_tunnel_remote_ip": info.IP.ServerRemote.String(),
-		"tunnel_dev":              ifName,
-		"gateway_ip":              i.defaultGatewayIP,
-		"gateway_dev":             i.defaultGatewayDev,
-		"server_ip":               i.cfg.ServerHost,
-		"tunnel_index":            i.deviceIndex,
-	}
-
-	logMsg := structs.If(isUp, "interface up", "interface down")
+	tmplVar := map[string]any{
+		"mtu":                      fmt.Sprintf("%d", i.getMTU(info)),
+		"device_type":              string(info.Type),
+		"client_tunnel_local_ip":   info.IP.ClientLocal.String(),
+		"client_tunnel_remote_ip":  info.IP.ClientRemote.String(),
+		"server_tunnel_local_ip":   info.IP.ServerLocal.String(),
+		"server_tunnel_remote_ip":  info.IP.ServerRemote.String(),
+		"client_tunnel_local_ip6":  ipString(info.IP.ClientLocal6),
+		"client_tunnel_remote_ip6": ipString(info.IP.ClientRemote6),
+		"server_tunnel_local_ip6":  ipString(info.IP.ServerLocal6),
+		"server_tunnel_remote_ip6": ipString(info.IP.ServerRemote6),
+		"tunnel_dev":               ifName,
+		"gateway_ip":               i.defaultGatewayIP,
+		"gateway_dev":              i.defaultGatewayDev,
+		"gateway_ip6":              i.defaultGatewayIP6,
+		"gateway_dev6":             i.defaultGatewayDev6,
+		"server_ip":                i.serverIP,
+		"server_ip6":               i.serverIP6,
+		"server_ips":               i.serverIPs,
+		"server_ips6":              i.serverIPs6,
+		"server_port_min":          i.cfg.ServerPortMin,
+		"server_port_max":          i.cfg.ServerPortMax,
+		"tunnel_index":             i.deviceIndex,
+		"tunnel_network":           tunnelNetwork(i.cfg.Tunnel.AddrMin, i.cfg.Tunnel.AddrMax),
+		"tunnel_network6":          tunnelNetwork(i.cfg.Tunnel.AddrMin6, i.cfg.Tunnel.AddrMax6),
+		"dns_servers":              templateList(nil),
+		"search_domains":           templateList(nil),
+		"routes":                   []templateRoute(nil),
+		"split_tunnel":             i.cfg.Tunnel.IsSplitTunnel(),
+		"include_routes":           newTemplateRoutes(i.includeRoutes),
+		"exclude_routes":           newTemplateRoutes(i.excludeRoutes),
+	}
+	if info.Network != nil {
+		addPushedNetworkVars(tmplVar, info.Network)
+	}
+
+	// the frames of the TAP device include the Ethernet header
+	if info.Type == entity.DeviceTypeTAP {
+		mtu := structs.If(info.Network != nil && info.Network.MTU != 0, info.Network.MTU, i.cfg.Tunnel.MTU)
+		tmplVar["mtu"] = fmt.Sprintf("%d", mtu-entity.EthernetHeaderMaxLength)
+	}
+
+	return tmplVar
+}
+
+// execute runs the commands created from the templates, the failed commands are logged
+// and the execution continues unless failFast is set
+func (i *Iface) execute(commands []string, tmplVar map[string]any, logMsg string, failFast bool) error {
 	buf := bytes.NewBuffer(nil)
 
 	for _, c := range commands {
-		tmpl, err := template.New(fmt.Sprintf("startup-%t", isUp)).Parse(c)
+		tmpl, err := template.New("startup").Parse(c)
 		if err != nil {
 			return err
 		}
 		if err := tmpl.Execute(buf, tmplVar); err != nil {
 			return err
 		}
+		if len(strings.TrimSpace(buf.String())) == 0 {
+			// command is disabled by a template condition, e.g. no IPv6 address assigned
+			buf.Reset()
+			continue
+		}
 
 		_, err = i.cmd.Run(buf.String())
 		if err != nil {
 			i.log.Error().Str("cmd", buf.String()).Msg("failed to execute")
+			if failFast {
+				return errors.Wrapf(err, "failed to execute %s", buf.String())
+			}
 		} else {
 			i.log.Info().Str("cmd", buf.String()).Msg(logMsg)
 		}
@@ -222,11 +334,22 @@ func (i *Iface) Close(info *entity.Interface) error {
 		return nil
 	}
 
+	if i.dnsRoutes != nil {
+		i.dnsRoutes.close()
+	}
+
 	err := i.startup(info, false)
 	close(info.Receiver)
 	return err
 }
 
+func ipString(ip net.IP) string {
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
 func isInterfaceClosed(err error) bool {
 	if fsErr, ok := err.(*fs.PathError); ok {
 		if errors.Is(fsErr, fs.ErrClosed) {
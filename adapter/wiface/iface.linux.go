@@ -12,16 +12,43 @@ import (
 	"github.com/forest33/tapir/business/entity"
 )
 
+var (
+	rxDefaultGateway  = regexp.MustCompile(`default via ([\d.]+) dev ([a-zA-Z0-9]+)`)
+	rxDefaultGateway6 = regexp.MustCompile(`default via ([0-9a-fA-F:]+) dev ([a-zA-Z0-9]+)`)
+)
+
+// splitTunnelUp installs the include routes through the tunnel and the exclude routes through the default gateway
+var splitTunnelUp = []string{
+	"{{ range .include_routes }}ip {{ if .IPv6 }}-6 {{ end }}route add {{ . }} dev {{ $.tunnel_dev }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if .IPv6 }}{{ if $.gateway_ip6 }}ip -6 route add {{ . }} via {{ $.gateway_ip6 }} dev {{ $.gateway_dev6 }}; {{ end }}{{ else if $.gateway_ip }}ip route add {{ . }} via {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+var splitTunnelDown = []string{
+	"{{ range .include_routes }}ip {{ if .IPv6 }}-6 {{ end }}route del {{ . }} dev {{ $.tunnel_dev }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if .IPv6 }}{{ if $.gateway_ip6 }}ip -6 route del {{ . }} via {{ $.gateway_ip6 }} dev {{ $.gateway_dev6 }}; {{ end }}{{ else if $.gateway_ip }}ip route del {{ . }} via {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+// subnetRoutesUp routes the subnets of a site-to-site client to the tunnel
+var subnetRoutesUp = []string{
+	"{{ range .subnets }}ip {{ if .IPv6 }}-6 {{ end }}route add {{ . }} dev {{ $.tunnel_dev }}; {{ end }}",
+}
+
+var subnetRoutesDown = []string{
+	"{{ range .subnets }}ip {{ if .IPv6 }}-6 {{ end }}route del {{ . }} dev {{ $.tunnel_dev }}; {{ end }}",
+}
+
 func (i *Iface) Create(ifc *entity.Interface) (*entity.Interface, error) {
 	i.Lock()
 	defer i.Unlock()
 
 	var err error
 	ifc.Handler, err = func() (entity.InterfaceHandler, error) {
-		if i.interfaceCreatorFunc == nil {
-			return tun.CreateTUN("", i.cfg.Tunnel.MTU, 0)
+		if i.interfaceCreatorFunc == nil && ifc.Type == entity.DeviceTypeTAP {
+			return createTAP()
+		} else if i.interfaceCreatorFunc == nil {
+			return tun.CreateTUN("", i.getMTU(ifc), 0)
 		}
-		return i.interfaceCreatorFunc(i.cfg.Tunnel.MTU)
+		return i.interfaceCreatorFunc(i.getMTU(ifc))
 	}()
 	if err != nil {
 		return nil, err
@@ -42,18 +69,28 @@ func (i *Iface) up(info *entity.Interface) error {
 		return errors.Wrap(err, out)
 	}
 
-	r := regexp.MustCompile(`default via ([\d.]+) dev ([a-zA-Z0-9]+)`)
-	match := r.FindStringSubmatch(out)
-	if len(match) < 3 {
-		return fmt.Errorf("failed to get default gateway (%s)", out)
+	if match := rxDefaultGateway.FindStringSubmatch(out); len(match) == 3 {
+		i.defaultGatewayIP = match[1]
+		i.defaultGatewayDev = match[2]
+	}
+
+	// the IPv6 default route is optional
+	if out6, err := i.cmd.Run("ip -6 route show default"); err == nil {
+		if match := rxDefaultGateway6.FindStringSubmatch(out6); len(match) == 3 {
+			i.defaultGatewayIP6 = match[1]
+			i.defaultGatewayDev6 = match[2]
+		}
 	}
 
-	i.defaultGatewayIP = match[1]
-	i.defaultGatewayDev = match[2]
+	if i.defaultGatewayIP == "" && i.defaultGatewayIP6 == "" {
+		return fmt.Errorf("failed to get default gateway (%s)", out)
+	}
 
 	i.log.Info().
 		Str("ip", i.defaultGatewayIP).
 		Str("device", i.defaultGatewayDev).
+		Str("ip6", i.defaultGatewayIP6).
+		Str("device6", i.defaultGatewayDev6).
 		Msg("gateway info")
 
 	return i.interfaceStartupFunc(info, true)
@@ -12,16 +12,38 @@ import (
 	"time"
 )
 
+// splitTunnelUp installs the include routes through the tunnel and the exclude routes through the default gateway
+var splitTunnelUp = []string{
+	"{{ range .include_routes }}{{ if .IPv6 }}netsh interface ipv6 add route {{ . }} \"{{ $.tunnel_dev }}\"{{ else }}route add {{ .Network }} mask {{ .Mask }} {{ $.server_tunnel_local_ip }} IF {{ $.tunnel_index }}{{ end }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if not .IPv6 }}route add {{ .Network }} mask {{ .Mask }} {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+var splitTunnelDown = []string{
+	"{{ range .include_routes }}{{ if .IPv6 }}netsh interface ipv6 delete route {{ . }} \"{{ $.tunnel_dev }}\"{{ else }}route delete {{ .Network }} mask {{ .Mask }}{{ end }}; {{ end }}",
+	"{{ range .exclude_routes }}{{ if not .IPv6 }}route delete {{ .Network }} mask {{ .Mask }} {{ $.gateway_ip }}; {{ end }}{{ end }}",
+}
+
+// subnetRoutesUp routes the subnets of a site-to-site client to the tunnel
+var subnetRoutesUp = []string{
+	"{{ range .subnets }}{{ if .IPv6 }}netsh interface ipv6 add route {{ . }} \"{{ $.tunnel_dev }}\"{{ else }}route add {{ .Network }} mask {{ .Mask }} {{ $.server_tunnel_local_ip }} IF {{ $.tunnel_index }}{{ end }}; {{ end }}",
+}
+
+var subnetRoutesDown = []string{
+	"{{ range .subnets }}{{ if .IPv6 }}netsh interface ipv6 delete route {{ . }} \"{{ $.tunnel_dev }}\"{{ else }}route delete {{ .Network }} mask {{ .Mask }}{{ end }}; {{ end }}",
+}
+
 func (i *Iface) Create(ifc *entity.Interface) (*entity.Interface, error) {
 	i.Lock()
 	defer i.Unlock()
 
 	var err error
 	ifc.Handler, err = func() (entity.InterfaceHandler, error) {
-		if i.interfaceCreatorFunc == nil {
-			return tun.CreateTUN(fmt.Sprintf("tapir-%d", time.Now().Unix()), "Tapir", i.cfg.Tunnel.MTU)
+		if i.interfaceCreatorFunc == nil && ifc.Type == entity.DeviceTypeTAP {
+			return nil, errTAPNotSupported
+		} else if i.interfaceCreatorFunc == nil {
+			return tun.CreateTUN(fmt.Sprintf("tapir-%d", time.Now().Unix()), "Tapir", i.getMTU(ifc))
 		}
-		return i.interfaceCreatorFunc(i.cfg.Tunnel.MTU)
+		return i.interfaceCreatorFunc(i.getMTU(ifc))
 	}()
 	if err != nil {
 		return nil, err
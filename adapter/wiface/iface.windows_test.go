@@ -20,7 +20,7 @@ func TestCreate(t *testing.T) {
 
 	cfg := &Config{
 		Tracing:     true,
-		ServerHost:  "8.8.8.8",
+		ServerIPs:   []net.IP{net.ParseIP("8.8.8.8")},
 		EndpointTTL: 600,
 		Tunnel: &entity.TunnelConfig{
 			MTU:                    1500,
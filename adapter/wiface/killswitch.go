@@ -0,0 +1,49 @@
+package wiface
+
+import (
+	"fmt"
+	"runtime"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// EnableKillSwitch blocks the traffic outside the tunnel device and the server endpoints.
+// It is called again for every new interface, the rules stay in place until DisableKillSwitch
+// so the traffic does not leak while the client is reconnecting.
+func (i *Iface) EnableKillSwitch(info *entity.Interface) error {
+	i.Lock()
+	defer i.Unlock()
+
+	commands, ok := i.cfg.Tunnel.KillSwitchUp[runtime.GOOS]
+	if !ok {
+		commands, ok = entity.DefaultKillSwitchUp[runtime.GOOS]
+	}
+	if !ok {
+		return fmt.Errorf("kill switch is not supported on OS %s", runtime.GOOS)
+	}
+
+	// the variables are saved before the execution, so partially applied rules are removed too
+	i.killSwitchVars = i.templateVars(info)
+
+	return i.execute(commands, i.killSwitchVars, "kill switch enabled", true)
+}
+
+// DisableKillSwitch removes the kill switch rules, it does nothing if the kill switch is not enabled
+func (i *Iface) DisableKillSwitch() error {
+	i.Lock()
+	defer i.Unlock()
+
+	if i.killSwitchVars == nil {
+		return nil
+	}
+
+	commands, ok := i.cfg.Tunnel.KillSwitchDown[runtime.GOOS]
+	if !ok {
+		commands = entity.DefaultKillSwitchDown[runtime.GOOS]
+	}
+
+	err := i.execute(commands, i.killSwitchVars, "kill switch disabled", false)
+	i.killSwitchVars = nil
+
+	return err
+}
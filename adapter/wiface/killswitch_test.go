@@ -0,0 +1,57 @@
+//go:build linux
+
+package wiface
+
+import (
+	"testing"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/logger"
+)
+
+func TestKillSwitch(t *testing.T) {
+	cmd := &testCommandExecutor{}
+	iface := &Iface{
+		log:       logger.NewDefault(),
+		cfg:       &Config{Tunnel: &entity.TunnelConfig{MTU: 1400}, ServerPortMin: 1977, ServerPortMax: 1986},
+		cmd:       cmd,
+		serverIP:  "203.0.113.10",
+		serverIPs: templateList{"203.0.113.10", "203.0.113.11"},
+	}
+	info := &entity.Interface{Handler: testInterfaceHandler{}}
+
+	if err := iface.DisableKillSwitch(); err != nil || len(cmd.commands) != 0 {
+		t.Fatalf("disabled kill switch should not execute commands: %v %q", err, cmd.commands)
+	}
+
+	if err := iface.EnableKillSwitch(info); err != nil {
+		t.Fatalf("failed to enable kill switch: %v", err)
+	}
+
+	want := []string{
+		"nft add rule inet tapir_killswitch output oifname tun0 accept",
+		"nft add rule inet tapir_killswitch output ip daddr { 203.0.113.10, 203.0.113.11 } meta l4proto { tcp, udp } th dport 1977-1986 accept",
+	}
+	for _, w := range want {
+		found := false
+		for _, c := range cmd.commands {
+			found = found || c == w
+		}
+		if !found {
+			t.Errorf("command %q is not executed: %q", w, cmd.commands)
+		}
+	}
+	if len(cmd.commands) != len(entity.DefaultKillSwitchUp["linux"])-1 {
+		t.Errorf("wrong number of commands: %q", cmd.commands)
+	}
+
+	cmd.commands = nil
+	for range 2 {
+		if err := iface.DisableKillSwitch(); err != nil {
+			t.Fatalf("failed to disable kill switch: %v", err)
+		}
+	}
+	if len(cmd.commands) != 1 || cmd.commands[0] != "nft delete table inet tapir_killswitch" {
+		t.Errorf("wrong commands: %q", cmd.commands)
+	}
+}
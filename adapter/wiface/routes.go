@@ -0,0 +1,119 @@
+package wiface
+
+import (
+	"net"
+	"net/netip"
+
+	"github.com/pkg/errors"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const (
+	ipv4HeaderSize = 20
+	ipv6HeaderSize = 40
+)
+
+// routeFilter checks the destination of the packets read from the interface,
+// it drops the packets that would not be routed through the tunnel with the include and exclude routes.
+type routeFilter struct {
+	includeOnly bool
+	include     []netip.Prefix
+	exclude     []netip.Prefix
+	hosts       *dnsRoutes
+}
+
+func parsePrefixes(list []string) ([]netip.Prefix, error) {
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	prefixes := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse route %s", s)
+		}
+		prefixes = append(prefixes, p.Masked())
+	}
+
+	return prefixes, nil
+}
+
+// newRouteFilter returns nil if all destinations are allowed.
+// The pushed routes, the pushed DNS servers and the tunnel addresses of the server are always allowed.
+func newRouteFilter(include, exclude []netip.Prefix, hosts *dnsRoutes, info *entity.Interface) *routeFilter {
+	if len(include) == 0 && len(exclude) == 0 && hosts == nil {
+		return nil
+	}
+
+	f := &routeFilter{
+		includeOnly: len(include) != 0 || hosts != nil,
+		exclude:     exclude,
+		hosts:       hosts,
+	}
+	if !f.includeOnly {
+		return f
+	}
+
+	f.include = append(f.include, include...)
+	if info.Network != nil {
+		f.include = append(f.include, info.Network.Routes...)
+		for _, addr := range info.Network.DNS {
+			f.include = append(f.include, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+	for _, ip := range []net.IP{info.IP.ServerLocal, info.IP.ServerRemote, info.IP.ServerLocal6, info.IP.ServerRemote6} {
+		if addr, ok := netip.AddrFromSlice(ip); ok {
+			addr = addr.Unmap()
+			f.include = append(f.include, netip.PrefixFrom(addr, addr.BitLen()))
+		}
+	}
+
+	return f
+}
+
+// allowed returns true if the destination of the IP packet is in the include routes or the domain routes
+// and not in the exclude routes, the packets with unknown destination are passed to the decoder
+func (f *routeFilter) allowed(packet []byte) bool {
+	dst, ok := packetDestination(packet)
+	if !ok {
+		return true
+	}
+
+	if f.includeOnly && !containsAddr(f.include, dst) && (f.hosts == nil || !f.hosts.contains(dst)) {
+		return false
+	}
+
+	return !containsAddr(f.exclude, dst)
+}
+
+func packetDestination(packet []byte) (netip.Addr, bool) {
+	if len(packet) == 0 {
+		return netip.Addr{}, false
+	}
+
+	switch packet[0] >> 4 {
+	case 4:
+		if len(packet) < ipv4HeaderSize {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom4([4]byte(packet[16:20])), true
+	case 6:
+		if len(packet) < ipv6HeaderSize {
+			return netip.Addr{}, false
+		}
+		return netip.AddrFrom16([16]byte(packet[24:40])), true
+	}
+
+	return netip.Addr{}, false
+}
+
+func containsAddr(prefixes []netip.Prefix, addr netip.Addr) bool {
+	for _, p := range prefixes {
+		if p.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,85 @@
+package wiface
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+func TestRouteFilter(t *testing.T) {
+	include, err := parsePrefixes([]string{"10.0.0.0/8", "fd00::1/64"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	exclude, err := parsePrefixes([]string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newRouteFilter(include, exclude, nil, &entity.Interface{
+		IP: entity.IfIP{
+			ServerRemote: net.ParseIP("192.168.30.1"),
+		},
+		Network: &entity.PushedNetwork{
+			Routes: []netip.Prefix{netip.MustParsePrefix("172.16.0.0/12")},
+		},
+	})
+
+	data := []struct {
+		dst  string
+		want bool
+	}{
+		{"10.2.3.4", true},
+		{"10.1.2.3", false},
+		{"8.8.8.8", false},
+		{"172.16.5.5", true},
+		{"192.168.30.1", true},
+		{"192.168.30.2", false},
+		{"fd00::53", true},
+		{"2001:db8::1", false},
+	}
+
+	for _, d := range data {
+		if got := f.allowed(testPacket(netip.MustParseAddr(d.dst))); got != d.want {
+			t.Errorf("wrong result for %s: %t, should be %t", d.dst, got, d.want)
+		}
+	}
+
+	if !f.allowed([]byte{0x45, 0, 0}) {
+		t.Error("truncated packet should be passed to the decoder")
+	}
+}
+
+func TestRouteFilterExcludeOnly(t *testing.T) {
+	exclude, err := parsePrefixes([]string{"192.168.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f := newRouteFilter(nil, exclude, nil, &entity.Interface{})
+	if !f.allowed(testPacket(netip.MustParseAddr("8.8.8.8"))) {
+		t.Error("8.8.8.8 should be allowed")
+	}
+	if f.allowed(testPacket(netip.MustParseAddr("192.168.1.1"))) {
+		t.Error("192.168.1.1 should be dropped")
+	}
+
+	if newRouteFilter(nil, nil, nil, &entity.Interface{}) != nil {
+		t.Error("filter without routes should be nil")
+	}
+}
+
+func testPacket(dst netip.Addr) []byte {
+	if dst.Is4() {
+		packet := make([]byte, ipv4HeaderSize)
+		packet[0] = 0x45
+		copy(packet[16:], dst.AsSlice())
+		return packet
+	}
+	packet := make([]byte, ipv6HeaderSize)
+	packet[0] = 0x60
+	copy(packet[24:], dst.AsSlice())
+	return packet
+}
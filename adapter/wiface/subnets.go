@@ -0,0 +1,29 @@
+package wiface
+
+import (
+	"net/netip"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// AddRoutes routes the subnets of a site-to-site client to the interface of its session
+func (i *Iface) AddRoutes(info *entity.Interface, subnets []netip.Prefix) error {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.execute(subnetRoutesUp, i.subnetVars(info, subnets), "subnet routes added", true)
+}
+
+// DeleteRoutes removes the routes of the subnets added with AddRoutes
+func (i *Iface) DeleteRoutes(info *entity.Interface, subnets []netip.Prefix) error {
+	i.Lock()
+	defer i.Unlock()
+
+	return i.execute(subnetRoutesDown, i.subnetVars(info, subnets), "subnet routes deleted", false)
+}
+
+func (i *Iface) subnetVars(info *entity.Interface, subnets []netip.Prefix) map[string]any {
+	vars := i.templateVars(info)
+	vars["subnets"] = newTemplateRoutes(subnets)
+	return vars
+}
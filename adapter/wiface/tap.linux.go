@@ -0,0 +1,67 @@
+//go:build linux
+
+package wiface
+
+import (
+	"os"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sys/unix"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const tunDevicePath = "/dev/net/tun"
+
+// tapDevice is a TAP device, the frames are read and written without the packet information header
+type tapDevice struct {
+	file *os.File
+	name string
+}
+
+// createTAP creates a TAP device named by the kernel, the MTU is set by the startup commands
+func createTAP() (entity.InterfaceHandler, error) {
+	fd, err := unix.Open(tunDevicePath, unix.O_RDWR|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open %s", tunDevicePath)
+	}
+
+	ifr, err := unix.NewIfreq("")
+	if err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+	ifr.SetUint16(unix.IFF_TAP | unix.IFF_NO_PI)
+
+	if err := unix.IoctlIfreq(fd, unix.TUNSETIFF, ifr); err != nil {
+		_ = unix.Close(fd)
+		return nil, errors.Wrap(err, "failed to create TAP device")
+	}
+
+	// the non-blocking descriptor is added to the poller, Close interrupts Read
+	if err := unix.SetNonblock(fd, true); err != nil {
+		_ = unix.Close(fd)
+		return nil, err
+	}
+
+	return &tapDevice{
+		file: os.NewFile(uintptr(fd), tunDevicePath),
+		name: ifr.Name(),
+	}, nil
+}
+
+func (t *tapDevice) Name() (string, error) {
+	return t.name, nil
+}
+
+func (t *tapDevice) Read(p []byte) (int, error) {
+	return t.file.Read(p)
+}
+
+func (t *tapDevice) Write(p []byte) (int, error) {
+	return t.file.Write(p)
+}
+
+func (t *tapDevice) Close() error {
+	return t.file.Close()
+}
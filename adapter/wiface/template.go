@@ -0,0 +1,97 @@
+package wiface
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"strings"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// templateList is a list of values of the startup templates,
+// it is printed as space-separated values and can be used with range.
+type templateList []string
+
+func (l templateList) String() string {
+	return strings.Join(l, " ")
+}
+
+// Join returns the values separated by sep, e.g. {{ .dns_servers.Join "," }}
+func (l templateList) Join(sep string) string {
+	return strings.Join(l, sep)
+}
+
+// Quote returns the values enclosed in single quotes and separated by spaces, e.g. {{ .search_domains.Quote }}
+func (l templateList) Quote() string {
+	quoted := make([]string, 0, len(l))
+	for _, v := range l {
+		quoted = append(quoted, "'"+strings.ReplaceAll(v, "'", `'\''`)+"'")
+	}
+	return strings.Join(quoted, " ")
+}
+
+// templateRoute is a route of the startup templates, it is printed in CIDR notation
+type templateRoute struct {
+	Network string
+	Bits    int
+	Mask    string
+	IPv6    bool
+}
+
+func (r templateRoute) String() string {
+	return fmt.Sprintf("%s/%d", r.Network, r.Bits)
+}
+
+func addPushedNetworkVars(vars map[string]any, n *entity.PushedNetwork) {
+	if n.MTU != 0 {
+		vars["mtu"] = fmt.Sprintf("%d", n.MTU)
+	}
+
+	dns := make(templateList, 0, len(n.DNS))
+	for _, addr := range n.DNS {
+		dns = append(dns, addr.String())
+	}
+	vars["dns_servers"] = dns
+	vars["search_domains"] = templateList(n.SearchDomains)
+
+	vars["routes"] = newTemplateRoutes(n.Routes)
+}
+
+func newTemplateRoutes(prefixes []netip.Prefix) []templateRoute {
+	routes := make([]templateRoute, 0, len(prefixes))
+	for _, p := range prefixes {
+		r := templateRoute{
+			Network: p.Addr().String(),
+			Bits:    p.Bits(),
+			IPv6:    !p.Addr().Is4(),
+		}
+		if !r.IPv6 {
+			r.Mask = net.IP(net.CIDRMask(p.Bits(), 32)).String()
+		}
+		routes = append(routes, r)
+	}
+	return routes
+}
+
+// tunnelNetwork returns the smallest network containing the addresses from minAddr to maxAddr in CIDR notation,
+// it is used to route the address pool of the server to the shared interface
+func tunnelNetwork(minAddr, maxAddr string) string {
+	from, err := netip.ParseAddr(minAddr)
+	if err != nil {
+		return ""
+	}
+	to, err := netip.ParseAddr(maxAddr)
+	if err != nil || from.Is4() != to.Is4() {
+		return ""
+	}
+
+	for bits := from.BitLen(); bits >= 0; bits-- {
+		p, _ := from.Prefix(bits)
+		if p.Contains(to) {
+			return p.String()
+		}
+	}
+
+	return ""
+}
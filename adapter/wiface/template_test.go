@@ -0,0 +1,63 @@
+package wiface
+
+import (
+	"bytes"
+	"net/netip"
+	"testing"
+	"text/template"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+func TestPushedNetworkVars(t *testing.T) {
+	vars := map[string]any{"tunnel_dev": "tun0", "mtu": "1400"}
+	addPushedNetworkVars(vars, &entity.PushedNetwork{
+		DNS:           []netip.Addr{netip.MustParseAddr("10.0.0.53"), netip.MustParseAddr("fd00::53")},
+		SearchDomains: []string{"corp.example.com"},
+		Routes:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00::/64")},
+		MTU:           1280,
+	})
+
+	data := []struct {
+		tmpl string
+		want string
+	}{
+		{"{{ .mtu }}", "1280"},
+		{"{{ if .dns_servers }}resolvectl dns {{ .tunnel_dev }} {{ .dns_servers }}{{ end }}", "resolvectl dns tun0 10.0.0.53 fd00::53"},
+		{"{{ .dns_servers.Join \",\" }}", "10.0.0.53,fd00::53"},
+		{"{{ .search_domains }}", "corp.example.com"},
+		{"{{ .search_domains.Quote }}", "'corp.example.com'"},
+		{"{{ range .routes }}{{ . }} {{ .Mask }};{{ end }}", "10.0.0.0/8 255.0.0.0;fd00::/64 ;"},
+	}
+
+	for _, d := range data {
+		buf := bytes.NewBuffer(nil)
+		if err := template.Must(template.New("test").Parse(d.tmpl)).Execute(buf, vars); err != nil {
+			t.Fatalf("failed to execute template %s: %v", d.tmpl, err)
+		}
+		if buf.String() != d.want {
+			t.Errorf("wrong result of %s: %q, should be %q", d.tmpl, buf.String(), d.want)
+		}
+	}
+}
+
+func TestTunnelNetwork(t *testing.T) {
+	data := []struct {
+		min  string
+		max  string
+		want string
+	}{
+		{"192.168.30.0", "192.168.50.254", "192.168.0.0/18"},
+		{"10.0.0.0", "10.0.0.255", "10.0.0.0/24"},
+		{"10.0.0.1", "10.0.0.1", "10.0.0.1/32"},
+		{"fd00:7a70::", "fd00:7a70::ffff", "fd00:7a70::/112"},
+		{"10.0.0.0", "fd00::1", ""},
+		{"", "", ""},
+	}
+
+	for _, d := range data {
+		if got := tunnelNetwork(d.min, d.max); got != d.want {
+			t.Errorf("wrong network of %s - %s: %q, should be %q", d.min, d.max, got, d.want)
+		}
+	}
+}
@@ -10,7 +10,7 @@ const (
 )
 
 type NetworkClient interface {
-	Run(host string, port uint16, proto Protocol) (*Connection, error)
+	Run(host string, port uint16, proto Protocol, uplink *Uplink) (*Connection, error)
 	ReceiverTCP(conn *Connection, sessionID uint32)
 	ReceiverUDP(conn *Connection, sessionID uint32)
 	SendAsync(msg *Message, conn *Connection) error
@@ -24,6 +24,7 @@ type NetworkClient interface {
 type ClientConnectionHandler interface {
 	Start() error
 	Exit()
+	GetReconnectState() ReconnectState
 }
 
 func GetClientBinaryName() string {
@@ -2,9 +2,17 @@
 package entity
 
 import (
+	"crypto/subtle"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"math"
 	"math/rand"
+	"net/netip"
 	"runtime"
+	"slices"
+	"strings"
+	"time"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
@@ -23,6 +31,16 @@ const (
 	CompressionNameLZO  = "lzo"
 	CompressionNameZSTD = "zstd"
 
+	AddressFamilyNamePreferIPv4 = "prefer-ipv4"
+	AddressFamilyNamePreferIPv6 = "prefer-ipv6"
+	AddressFamilyNameDual       = "dual"
+
+	HeaderProtectionNameAEAD   = "aead"
+	HeaderProtectionNameCompat = "compat"
+	HeaderProtectionNameLegacy = "legacy"
+
+	MinMTU = 576
+
 	DefaultServerConfigFileName = "tapir-server.yaml"
 	DefaultClientConfigFileName = "tapir-client.yaml"
 )
@@ -40,6 +58,7 @@ type ServerConfig struct {
 	Ack            *AckConfig            `yaml:"Acknowledgement"`
 	Authentication *AuthenticationConfig `yaml:"Authentication"`
 	Users          []*User               `yaml:"Users"`
+	Push           *PushConfig           `yaml:"Push,omitempty"`
 	Tracing        *TracingConfig        `yaml:"Tracing,omitempty"`
 	Profiler       *ProfilerConfig       `yaml:"Profiler"`
 	Rest           *RestConfig           `yaml:"Rest"`
@@ -108,6 +127,18 @@ type NetworkConfig struct {
 	Compression           string `yaml:"compression" default:"none"`
 	CompressionLevel      int    `yaml:"compressionLevel,omitempty" default:"0"`
 	ObfuscateData         *bool  `yaml:"obfuscateData,omitempty" default:"true"`
+	AddressFamily         string `yaml:"addressFamily,omitempty" default:"prefer-ipv4"`
+	ReplayWindowSize      int    `yaml:"replayWindowSize" default:"2048"`
+	HeaderProtection      string `yaml:"headerProtection" default:"compat"`
+	MinProtocolVersion    uint8  `yaml:"minProtocolVersion" default:"1"`
+	Reconnect             *bool  `yaml:"reconnect" default:"true"`
+	ReconnectMinTimeout   int    `yaml:"reconnectMinTimeout" default:"1"`
+	ReconnectMaxTimeout   int    `yaml:"reconnectMaxTimeout" default:"60"`
+	ReconnectMaxAttempts  int    `yaml:"reconnectMaxAttempts" default:"0"`
+	SessionTimeout        int    `yaml:"sessionTimeout" default:"60"`
+
+	// local interfaces or source addresses the client paths are bound to, all paths use the default route if empty
+	Uplinks []string `yaml:"uplinks,omitempty"`
 }
 
 type ClientConnection struct {
@@ -123,10 +154,27 @@ type TunnelConfig struct {
 	MTU                    int                 `yaml:"mtu" default:"1400"`
 	AddrMin                string              `yaml:"addrMin,omitempty" default:"192.168.30.0"`
 	AddrMax                string              `yaml:"addrMax,omitempty" default:"192.168.50.0"`
+	AddrMin6               string              `yaml:"addrMin6,omitempty" default:""`
+	AddrMax6               string              `yaml:"addrMax6,omitempty" default:""`
 	InterfaceUp            map[string][]string `yaml:"interfaceUp"`
 	InterfaceDown          map[string][]string `yaml:"interfaceDown"`
 	NumberOfHandlerThreads int                 `yaml:"numberOfHandlerThreads" default:"4"`
+	DeviceType             DeviceType          `yaml:"deviceType,omitempty" default:"tun"`
+	SharedInterface        *bool               `yaml:"sharedInterface,omitempty" default:"false"`
+	LeaseFile              string              `yaml:"leaseFile,omitempty" default:""`
+	Hub                    *bool               `yaml:"hub,omitempty" default:"false"`
+	Subnets                []string            `yaml:"subnets,omitempty"`
 	Encryption             EncryptorMethod     `yaml:"encryption" default:"aes-256-ecb"`
+	RekeyInterval          int                 `yaml:"rekeyInterval" default:"0"`
+	RekeyBytes             uint64              `yaml:"rekeyBytes" default:"0"`
+	RekeyGracePeriod       int                 `yaml:"rekeyGracePeriod" default:"10"`
+	KeyExchange            KeyExchangeMethod   `yaml:"keyExchange" default:"x25519"`
+	IncludeRoutes          []string            `yaml:"includeRoutes,omitempty"`
+	ExcludeRoutes          []string            `yaml:"excludeRoutes,omitempty"`
+	IncludeDomains         []string            `yaml:"includeDomains,omitempty"`
+	KillSwitch             *bool               `yaml:"killSwitch,omitempty" default:"false"`
+	KillSwitchUp           map[string][]string `yaml:"killSwitchUp,omitempty" default:""`
+	KillSwitchDown         map[string][]string `yaml:"killSwitchDown,omitempty" default:""`
 }
 
 // StreamMergerConfig stream merger configuration
@@ -150,13 +198,58 @@ type AckConfig struct {
 
 // AuthenticationConfig authentication config
 type AuthenticationConfig struct {
-	Key string `yaml:"key" default:""`
+	Key             string `yaml:"key" default:""`
+	SigningKey      string `yaml:"signingKey,omitempty" default:""`
+	ServerPublicKey string `yaml:"serverPublicKey,omitempty" default:""`
 }
 
 // User system user
 type User struct {
-	Name     string `yaml:"name"`
-	Password string `yaml:"password"`
+	Name       string      `yaml:"name"`
+	Password   string      `yaml:"password,omitempty" default:""`
+	PublicKeys []string    `yaml:"publicKeys,omitempty"`
+	PrivateKey string      `yaml:"privateKey,omitempty" default:""`
+	Address    string      `yaml:"address,omitempty" default:""`
+	Address6   string      `yaml:"address6,omitempty" default:""`
+	Peers      []string    `yaml:"peers,omitempty"`
+	Subnets    []string    `yaml:"subnets,omitempty"`
+	Push       *PushConfig `yaml:"push,omitempty"`
+}
+
+// PushConfig network configuration pushed by the server to clients
+type PushConfig struct {
+	DNS           []string `yaml:"dns,omitempty"`
+	SearchDomains []string `yaml:"searchDomains,omitempty"`
+	Routes        []string `yaml:"routes,omitempty"`
+	MTU           int      `yaml:"mtu,omitempty" default:"0"`
+}
+
+// HasPublicKey reports whether the public key is allowed for the user
+func (u *User) HasPublicKey(key []byte) bool {
+	encoded := base64.StdEncoding.EncodeToString(key)
+	for _, k := range u.PublicKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(encoded)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// CanReach reports whether the clients of the user can send packets to the clients of the user name in the hub mode,
+// "*" allows all users
+func (u *User) CanReach(name string) bool {
+	return slices.Contains(u.Peers, name) || slices.Contains(u.Peers, "*")
+}
+
+// CanRoute reports whether the clients of the user can route the subnet in the site-to-site mode,
+// the subnet must be inside one of the subnets of the user
+func (u *User) CanRoute(subnet netip.Prefix) bool {
+	for _, s := range u.Subnets {
+		if p, err := netip.ParsePrefix(s); err == nil && p.Bits() <= subnet.Bits() && p.Masked().Contains(subnet.Addr()) {
+			return true
+		}
+	}
+	return false
 }
 
 // TracingConfig tracing configuration
@@ -219,17 +312,82 @@ type RestConfig struct {
 }
 
 func (c NetworkConfig) MaxPorts() int {
-	ports := c.PortMax - c.PortMin + 1
+	ports := int(c.PortMax - c.PortMin + 1)
 	if *c.UseTCP && *c.UseUDP {
 		ports *= 2
 	}
-	return int(ports)
+	return ports * max(len(c.Uplinks), 1)
+}
+
+// GetUplinks returns the uplinks the paths are bound to, nil if the paths use the default route
+func (c NetworkConfig) GetUplinks() []*Uplink {
+	if len(c.Uplinks) == 0 {
+		return []*Uplink{nil}
+	}
+
+	uplinks := make([]*Uplink, 0, len(c.Uplinks))
+	for i, name := range c.Uplinks {
+		uplinks = append(uplinks, &Uplink{ID: uint8(i + 1), Name: name})
+	}
+
+	return uplinks
 }
 
 func (c NetworkConfig) UseStreamMerger() bool {
 	return c.PortMin < c.PortMax || *c.UseUDP
 }
 
+func (c TunnelConfig) UseRekey() bool {
+	return c.RekeyInterval > 0 || c.RekeyBytes > 0
+}
+
+// UseSharedInterface returns true if the server uses a single interface for all sessions,
+// the TAP device is always shared
+func (c TunnelConfig) UseSharedInterface() bool {
+	return c.IsTAP() || c.SharedInterface != nil && *c.SharedInterface
+}
+
+// IsTAP returns true if the tunnel transfers Ethernet frames
+func (c TunnelConfig) IsTAP() bool {
+	return c.DeviceType == DeviceTypeTAP
+}
+
+// UseHub returns true if the server forwards the packets between the sessions
+func (c TunnelConfig) UseHub() bool {
+	return c.Hub != nil && *c.Hub
+}
+
+func (c TunnelConfig) UseKillSwitch() bool {
+	return c.KillSwitch != nil && *c.KillSwitch
+}
+
+func (c NetworkConfig) UseReconnect() bool {
+	return c.Reconnect != nil && *c.Reconnect
+}
+
+// GetReconnectDelay returns the delay before the reconnect attempt (starting from 1),
+// the delay is doubled on each attempt up to the maximum timeout and randomized by half
+// so the clients disconnected at the same time do not reconnect at the same time.
+func (c NetworkConfig) GetReconnectDelay(attempt int) time.Duration {
+	var (
+		minDelay = time.Duration(max(c.ReconnectMinTimeout, 1)) * time.Second
+		maxDelay = max(time.Duration(c.ReconnectMaxTimeout)*time.Second, minDelay)
+		delay    = minDelay
+	)
+
+	for i := 1; i < attempt && delay < maxDelay; i++ {
+		delay *= 2
+	}
+	delay = min(delay, maxDelay)
+
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// GetMinProtocolVersion returns the lowest protocol version accepted from the peer
+func (c NetworkConfig) GetMinProtocolVersion() ProtocolVersion {
+	return ProtocolVersion(c.MinProtocolVersion).Get()
+}
+
 func (c NetworkConfig) GetConnectionProtocol() Protocol {
 	proto := make([]Protocol, 0, 2)
 	if *c.UseTCP {
@@ -244,14 +402,100 @@ func (c NetworkConfig) GetConnectionProtocol() Protocol {
 func (c *ServerConfig) Validate() error {
 	return validation.ValidateStruct(c,
 		validation.Field(&c.ServerHost, validation.Required, is.Host),
+		validation.Field(&c.Push),
+		validation.Field(&c.Users),
 	)
 }
 
-func (c *ClientConfig) Validate() error {
-	// TODO
+func (u *User) Validate() error {
+	return validation.ValidateStruct(u,
+		validation.Field(&u.Address, is.IPv4),
+		validation.Field(&u.Address6, is.IPv6),
+		validation.Field(&u.Peers, validation.Each(validation.Required)),
+		validation.Field(&u.Subnets, validation.Each(validation.By(validatePrefix))),
+		validation.Field(&u.Push),
+	)
+}
+
+func (c *PushConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.DNS, validation.Each(is.IP)),
+		validation.Field(&c.SearchDomains, validation.Each(is.DNSName)),
+		validation.Field(&c.Routes, validation.Each(validation.By(validatePrefix))),
+		validation.Field(&c.MTU, validation.Min(MinMTU)),
+	)
+}
+
+// Merge returns the configuration with the fields of c replaced by the non-empty fields of override
+func (c *PushConfig) Merge(override *PushConfig) *PushConfig {
+	res := &PushConfig{}
+	if c != nil {
+		*res = *c
+	}
+	if override == nil {
+		return res
+	}
+	if len(override.DNS) != 0 {
+		res.DNS = override.DNS
+	}
+	if len(override.SearchDomains) != 0 {
+		res.SearchDomains = override.SearchDomains
+	}
+	if len(override.Routes) != 0 {
+		res.Routes = override.Routes
+	}
+	if override.MTU != 0 {
+		res.MTU = override.MTU
+	}
+	return res
+}
+
+func validatePrefix(value interface{}) error {
+	if _, err := netip.ParsePrefix(value.(string)); err != nil {
+		return errors.New("must be a valid CIDR")
+	}
 	return nil
 }
 
+// validateDomainPattern checks a domain name, "*." matches its subdomains
+func validateDomainPattern(value interface{}) error {
+	return is.DNSName.Validate(strings.TrimPrefix(value.(string), "*."))
+}
+
+func (c *ClientConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.Connections),
+	)
+}
+
+func (c *ClientConnection) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.Server),
+		validation.Field(&c.Tunnel),
+	)
+}
+
+func (c *NetworkConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.Uplinks, validation.Length(0, math.MaxUint8), validation.Each(validation.Required)),
+	)
+}
+
+func (c *TunnelConfig) Validate() error {
+	return validation.ValidateStruct(c,
+		validation.Field(&c.IncludeRoutes, validation.Each(validation.By(validatePrefix))),
+		validation.Field(&c.ExcludeRoutes, validation.Each(validation.By(validatePrefix))),
+		validation.Field(&c.IncludeDomains, validation.Each(validation.By(validateDomainPattern))),
+		validation.Field(&c.Subnets, validation.Each(validation.By(validatePrefix))),
+		validation.Field(&c.DeviceType, validation.In(DeviceTypeTUN, DeviceTypeTAP)),
+	)
+}
+
+// IsSplitTunnel returns true if only the include routes and domains are sent through the tunnel
+func (c *TunnelConfig) IsSplitTunnel() bool {
+	return len(c.IncludeRoutes) != 0 || len(c.IncludeDomains) != 0
+}
+
 func (c *ClientConfig) Normalize() {
 	c.System.ClientID = structs.If(c.System.ClientID == "", uuid.New().String(), c.System.ClientID)
 	c.System.Shell = structs.If(c.System.Shell == "", GetSystemShell(), c.System.Shell)
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestGetReconnectDelay(t *testing.T) {
+	cfg := NetworkConfig{ReconnectMinTimeout: 1, ReconnectMaxTimeout: 10}
+
+	data := []struct {
+		attempt int
+		max     time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 8 * time.Second},
+		{5, 10 * time.Second},
+		{100, 10 * time.Second},
+	}
+
+	for _, d := range data {
+		for range 100 {
+			delay := cfg.GetReconnectDelay(d.attempt)
+			if delay < d.max/2 || delay > d.max {
+				t.Fatalf("wrong delay for attempt %d: %s, should be between %s and %s", d.attempt, delay, d.max/2, d.max)
+			}
+		}
+	}
+}
+
+func TestUserCanReach(t *testing.T) {
+	data := []struct {
+		peers []string
+		name  string
+		want  bool
+	}{
+		{nil, "user2", false},
+		{[]string{"user2"}, "user2", true},
+		{[]string{"user2"}, "user3", false},
+		{[]string{"user3", "*"}, "user2", true},
+	}
+
+	for _, d := range data {
+		u := &User{Name: "user1", Peers: d.peers}
+		if got := u.CanReach(d.name); got != d.want {
+			t.Errorf("wrong result for peers %v and user %s: %v, should be %v", d.peers, d.name, got, d.want)
+		}
+	}
+}
+
+func TestUserCanRoute(t *testing.T) {
+	data := []struct {
+		subnets []string
+		subnet  string
+		want    bool
+	}{
+		{nil, "192.168.1.0/24", false},
+		{[]string{"192.168.0.0/16"}, "192.168.1.0/24", true},
+		{[]string{"192.168.1.0/24"}, "192.168.1.0/24", true},
+		{[]string{"192.168.1.0/24"}, "192.168.0.0/16", false},
+		{[]string{"10.0.0.0/8", "fd00::/8"}, "fd00:1::/64", true},
+		{[]string{"10.0.0.0/8"}, "fd00:1::/64", false},
+	}
+
+	for _, d := range data {
+		u := &User{Name: "user1", Subnets: d.subnets}
+		if got := u.CanRoute(netip.MustParsePrefix(d.subnet)); got != d.want {
+			t.Errorf("wrong result for subnets %v and subnet %s: %v, should be %v", d.subnets, d.subnet, got, d.want)
+		}
+	}
+}
@@ -4,40 +4,83 @@ var (
 	DefaultClientInterfaceUp = map[string][]string{
 		"linux": {
 			"ip addr add dev {{ .tunnel_dev }} local {{ .server_tunnel_local_ip }} remote {{ .server_tunnel_remote_ip }}",
+			"{{ if .server_tunnel_local_ip6 }}ip -6 addr add dev {{ .tunnel_dev }} local {{ .server_tunnel_local_ip6 }} peer {{ .server_tunnel_remote_ip6 }}{{ end }}",
 			"ip link set dev {{ .tunnel_dev }} mtu {{ .mtu }} up",
-			"ip route add {{ .server_ip }}/32 via {{ .gateway_ip }}",
-			"ip route add 0.0.0.0/1 via {{ .server_tunnel_remote_ip }}",
-			"ip route add 128.0.0.0/1 via {{ .server_tunnel_remote_ip }}",
+			"{{ if .server_ip }}ip route add {{ .server_ip }}/32 via {{ .gateway_ip }}{{ end }}",
+			"{{ if and .server_ip6 .gateway_ip6 }}ip -6 route add {{ .server_ip6 }}/128 via {{ .gateway_ip6 }} dev {{ .gateway_dev6 }}{{ end }}",
+			"{{ if not .split_tunnel }}ip route add 0.0.0.0/1 via {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}ip route add 128.0.0.0/1 via {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}ip -6 route add ::/1 via {{ .server_tunnel_remote_ip6 }} dev {{ .tunnel_dev }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}ip -6 route add 8000::/1 via {{ .server_tunnel_remote_ip6 }} dev {{ .tunnel_dev }}{{ end }}",
+			"{{ range .routes }}ip {{ if .IPv6 }}-6 {{ end }}route add {{ . }} dev {{ $.tunnel_dev }}; {{ end }}",
+			"{{ if .dns_servers }}resolvectl dns {{ .tunnel_dev }} {{ .dns_servers }}{{ end }}",
+			"{{ if .search_domains }}resolvectl domain {{ .tunnel_dev }} {{ .search_domains.Quote }}{{ end }}",
 		},
 		"darwin": {
 			"ifconfig {{ .tunnel_dev }} {{ .server_tunnel_local_ip }} {{ .server_tunnel_remote_ip }} mtu {{ .mtu }} up",
-			"route add {{ .server_ip }} {{ .gateway_ip }}",
-			"route add -net 0.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}",
-			"route add -net 128.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}",
+			"{{ if .server_tunnel_local_ip6 }}ifconfig {{ .tunnel_dev }} inet6 {{ .server_tunnel_local_ip6 }} {{ .server_tunnel_remote_ip6 }} prefixlen 128{{ end }}",
+			"{{ if .server_ip }}route add {{ .server_ip }} {{ .gateway_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}route add -net 0.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}route add -net 128.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}route add -inet6 -net ::/1 {{ .server_tunnel_remote_ip6 }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}route add -inet6 -net 8000::/1 {{ .server_tunnel_remote_ip6 }}{{ end }}",
+			"{{ range .routes }}route add {{ if .IPv6 }}-inet6 {{ end }}-net {{ . }} -interface {{ $.tunnel_dev }}; {{ end }}",
+			"{{ if or .dns_servers .search_domains }}printf '%s\\n' open d.init{{ if .dns_servers }} 'd.add ServerAddresses * {{ .dns_servers }}'{{ end }}{{ if .search_domains }} 'd.add SearchDomains * {{ .search_domains }}'{{ end }} 'set State:/Network/Service/tapir-{{ .tunnel_dev }}/DNS' quit | scutil{{ end }}",
 		},
 		"windows": {
-			"Disable-NetAdapterBinding -Name \"{{ .tunnel_dev }}\" -ComponentID ms_tcpip6",
+			"{{ if not .server_tunnel_local_ip6 }}Disable-NetAdapterBinding -Name \"{{ .tunnel_dev }}\" -ComponentID ms_tcpip6{{ end }}",
 			"Disable-NetAdapterBinding -Name \"{{ .tunnel_dev }}\" -ComponentID ms_lldp",
 			"netsh interface ip set address name=\"{{ .tunnel_dev }}\" source=static addr={{ .server_tunnel_local_ip }} mask=255.255.255.0 gateway=none",
+			"{{ if .server_tunnel_local_ip6 }}netsh interface ipv6 add address \"{{ .tunnel_dev }}\" {{ .server_tunnel_local_ip6 }}/128{{ end }}",
 			"netsh interface ip set interface \"{{ .tunnel_dev }}\" mtu={{ .mtu }}",
-			"route add {{ .server_ip }}/32 {{ .gateway_ip }}",
-			"route add 0.0.0.0/0 {{ .server_tunnel_local_ip }} IF {{ .tunnel_index }}",
+			"{{ if .server_ip }}route add {{ .server_ip }}/32 {{ .gateway_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}route add 0.0.0.0/0 {{ .server_tunnel_local_ip }} IF {{ .tunnel_index }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}netsh interface ipv6 add route ::/0 \"{{ .tunnel_dev }}\" {{ .server_tunnel_remote_ip6 }}{{ end }}",
+			"{{ range .routes }}{{ if not .IPv6 }}route add {{ .Network }} mask {{ .Mask }} {{ $.server_tunnel_local_ip }} IF {{ $.tunnel_index }}; {{ end }}{{ end }}",
+			"{{ if .dns_servers }}Set-DnsClientServerAddress -InterfaceAlias \"{{ .tunnel_dev }}\" -ServerAddresses {{ .dns_servers.Join \",\" }}{{ end }}",
+			"{{ if .search_domains }}Set-DnsClient -InterfaceAlias \"{{ .tunnel_dev }}\" -ConnectionSpecificSuffix '{{ index .search_domains 0 }}'{{ end }}",
 		},
 	}
 
 	DefaultClientInterfaceDown = map[string][]string{
 		"linux": {
-			"ip route del {{ .server_ip }}/32 via {{ .gateway_ip }}",
-			"ip route del 0.0.0.0/1 via {{ .server_tunnel_remote_ip }}",
-			"ip route del 128.0.0.0/1 via {{ .server_tunnel_remote_ip }}",
+			"{{ if .server_ip }}ip route del {{ .server_ip }}/32 via {{ .gateway_ip }}{{ end }}",
+			"{{ if and .server_ip6 .gateway_ip6 }}ip -6 route del {{ .server_ip6 }}/128 via {{ .gateway_ip6 }} dev {{ .gateway_dev6 }}{{ end }}",
+			"{{ if not .split_tunnel }}ip route del 0.0.0.0/1 via {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}ip route del 128.0.0.0/1 via {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}ip -6 route del ::/1 via {{ .server_tunnel_remote_ip6 }} dev {{ .tunnel_dev }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}ip -6 route del 8000::/1 via {{ .server_tunnel_remote_ip6 }} dev {{ .tunnel_dev }}{{ end }}",
 		},
 		"darwin": {
-			"route delete {{ .server_ip }} {{ .gateway_ip }}",
-			"route delete -net 0.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}",
-			"route delete -net 128.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}",
+			"{{ if .server_ip }}route delete {{ .server_ip }} {{ .gateway_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}route delete -net 0.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if not .split_tunnel }}route delete -net 128.0.0.0 -netmask 128.0.0.0 {{ .server_tunnel_remote_ip }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}route delete -inet6 -net ::/1 {{ .server_tunnel_remote_ip6 }}{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}route delete -inet6 -net 8000::/1 {{ .server_tunnel_remote_ip6 }}{{ end }}",
+			"{{ if or .dns_servers .search_domains }}printf '%s\\n' open 'remove State:/Network/Service/tapir-{{ .tunnel_dev }}/DNS' quit | scutil{{ end }}",
 		},
 		"windows": {
-			"route delete {{ .server_ip }}/32",
+			"{{ if .server_ip }}route delete {{ .server_ip }}/32{{ end }}",
+			"{{ if and .server_tunnel_remote_ip6 (not .split_tunnel) }}netsh interface ipv6 delete route ::/0 \"{{ .tunnel_dev }}\" {{ .server_tunnel_remote_ip6 }}{{ end }}",
+		},
+	}
+
+	// DefaultKillSwitchUp blocks all outgoing traffic except the tunnel device and the server endpoints
+	DefaultKillSwitchUp = map[string][]string{
+		"linux": {
+			"nft add table inet tapir_killswitch",
+			"nft flush table inet tapir_killswitch",
+			"nft add chain inet tapir_killswitch output '{ type filter hook output priority 0; policy drop; }'",
+			"nft add rule inet tapir_killswitch output oifname lo accept",
+			"nft add rule inet tapir_killswitch output oifname {{ .tunnel_dev }} accept",
+			"{{ if .server_ips }}nft add rule inet tapir_killswitch output ip daddr { {{ .server_ips.Join \", \" }} } meta l4proto { tcp, udp } th dport {{ .server_port_min }}-{{ .server_port_max }} accept{{ end }}",
+			"{{ if .server_ips6 }}nft add rule inet tapir_killswitch output ip6 daddr { {{ .server_ips6.Join \", \" }} } meta l4proto { tcp, udp } th dport {{ .server_port_min }}-{{ .server_port_max }} accept{{ end }}",
+		},
+	}
+
+	DefaultKillSwitchDown = map[string][]string{
+		"linux": {
+			"nft delete table inet tapir_killswitch",
 		},
 	}
 )
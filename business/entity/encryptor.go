@@ -4,10 +4,27 @@ const (
 	EncryptionNone      EncryptorMethod = "none"
 	EncryptionAES256ECB EncryptorMethod = "aes-256-ecb"
 	EncryptionAES256GCM EncryptorMethod = "aes-256-gcm"
+	EncryptionChaCha20  EncryptorMethod = "chacha20-poly1305"
+	EncryptionXChaCha20 EncryptorMethod = "xchacha20-poly1305"
+)
+
+const (
+	HeaderProtectionAEAD HeaderProtectionMode = iota + 1
+	HeaderProtectionCompat
+	HeaderProtectionLegacy
+
+	HeaderProtectionKeySize = 32
 )
 
 type EncryptorMethod string
 
+// HeaderProtectionMode defines how message headers are protected.
+// AEAD headers are sent and accepted in HeaderProtectionAEAD mode, headers encrypted
+// with the primary encryptor in HeaderProtectionLegacy mode. In HeaderProtectionCompat mode
+// both kinds are accepted, the server responds with the kind used by the client,
+// the client sends legacy headers, so it can connect to older servers.
+type HeaderProtectionMode int
+
 func (m EncryptorMethod) String() string {
 	return string(m)
 }
@@ -16,12 +33,17 @@ func (m EncryptorMethod) KeySize() int {
 	switch m {
 	case EncryptionNone:
 		return 0
-	case EncryptionAES256ECB, EncryptionAES256GCM:
+	case EncryptionAES256ECB, EncryptionAES256GCM, EncryptionChaCha20, EncryptionXChaCha20:
 		return 32
 	}
 	panic("unknown encryption method")
 }
 
+// IsAuthenticated returns true if the integrity of the encrypted messages is verified
+func (m EncryptorMethod) IsAuthenticated() bool {
+	return m == EncryptionAES256GCM || m == EncryptionChaCha20 || m == EncryptionXChaCha20
+}
+
 type Decoder interface {
 	Decrypt(data any) ([]byte, error)
 }
@@ -37,3 +59,55 @@ type Encryptor interface {
 	SetKey([]byte)
 	GetKey() string
 }
+
+func GetHeaderProtectionMode(name string) HeaderProtectionMode {
+	switch name {
+	case HeaderProtectionNameAEAD:
+		return HeaderProtectionAEAD
+	case HeaderProtectionNameLegacy:
+		return HeaderProtectionLegacy
+	default:
+		return HeaderProtectionCompat
+	}
+}
+
+// KeyPhaseEncryptor is an encryptor of a connection that can be rekeyed.
+// Messages are marked with the key phase they are encrypted with,
+// the key of the previous phase is kept for a grace period to decrypt messages in flight.
+type KeyPhaseEncryptor interface {
+	Encryptor
+	KeyPhase() bool
+	Phase(phase bool) Encryptor
+}
+
+// GetKeyPhase returns the key phase of the encryptor, false for encryptors without key phases.
+func GetKeyPhase(enc Encryptor) bool {
+	if kp, ok := enc.(KeyPhaseEncryptor); ok {
+		return kp.KeyPhase()
+	}
+	return false
+}
+
+// GetPhaseEncryptor returns the encryptor for messages of the key phase,
+// nil is returned if the key of this phase has expired.
+func GetPhaseEncryptor(enc Encryptor, phase bool) Encryptor {
+	if kp, ok := enc.(KeyPhaseEncryptor); ok {
+		return kp.Phase(phase)
+	}
+	return enc
+}
+
+// HeaderKeyEncryptor is an encryptor of a connection with the header protection keys of the session,
+// enc protects the headers of outgoing messages and dec the headers of incoming messages.
+type HeaderKeyEncryptor interface {
+	Encryptor
+	HeaderKeys() (enc, dec []byte)
+}
+
+// GetHeaderKeys returns the header protection keys of the encryptor, nil for encryptors without them.
+func GetHeaderKeys(enc Encryptor) ([]byte, []byte) {
+	if hk, ok := enc.(HeaderKeyEncryptor); ok {
+		return hk.HeaderKeys()
+	}
+	return nil, nil
+}
@@ -34,16 +34,28 @@ var (
 	ErrWrongConnectionId           = errors.New("wrong connection index")
 	ErrGrpcServerUnavailable       = errors.New("gRPC server is unavailable")
 	ErrValidation                  = errors.New("validation error")
+	ErrRekeyVerificationFailed     = errors.New("rekey verification failed")
+	ErrServerVerificationFailed    = errors.New("server identity verification failed")
+	ErrKeyExchangeNotSupported     = errors.New("key exchange method not supported")
+	ErrUnsupportedVersion          = errors.New("unsupported protocol version")
+	ErrAddressPoolExhausted        = errors.New("address pool exhausted")
+	ErrAddressLeaseConflict        = errors.New("address lease of the client is in use")
+	ErrWrongDeviceType             = errors.New("device type mismatch")
 )
 
 var (
 	unknownMessageError     MessageError = 0xff
 	errorToMessageErrorCode              = map[error]MessageError{
-		ErrNoError:             0x00,
-		ErrWrongMessagePayload: 0x01,
-		ErrUnknownCommand:      0x02,
-		ErrUnauthorized:        0x03,
-		ErrInternalError:       0x04,
+		ErrNoError:                 0x00,
+		ErrWrongMessagePayload:     0x01,
+		ErrUnknownCommand:          0x02,
+		ErrUnauthorized:            0x03,
+		ErrInternalError:           0x04,
+		ErrRekeyVerificationFailed: 0x05,
+		ErrKeyExchangeNotSupported: 0x06,
+		ErrUnsupportedVersion:      0x07,
+		ErrAddressPoolExhausted:    0x08,
+		ErrWrongDeviceType:         0x09,
 	}
 	messageErrorToError map[MessageError]error
 )
@@ -3,6 +3,7 @@ package entity
 import (
 	"context"
 	"errors"
+	"net/netip"
 )
 
 const (
@@ -29,11 +30,13 @@ func (d DeviceType) Int() int {
 type InterfaceReceiver func(*Message) error
 
 type Interface struct {
-	Type     DeviceType
-	IP       IfIP
-	Handler  InterfaceHandler
-	Receiver chan *Message
-	Cancel   context.CancelFunc
+	Type        DeviceType
+	IP          IfIP
+	Network     *PushedNetwork
+	Handler     InterfaceHandler
+	Receiver    chan *Message
+	Cancel      context.CancelFunc
+	MonotonicID bool
 }
 
 func (i Interface) Name() (string, error) {
@@ -63,6 +66,18 @@ type InterfaceAdapter interface {
 	Create(*Interface) (*Interface, error)
 	Write(*Interface, interface{}) error
 	Close(*Interface) error
+	EnableKillSwitch(*Interface) error
+	AddRoutes(*Interface, []netip.Prefix) error
+	DeleteRoutes(*Interface, []netip.Prefix) error
+	DisableKillSwitch() error
 	SendLog(*Message, string)
 	ReceiveLog(*Message)
 }
+
+// AddressManager assigns the tunnel addresses of the sessions from the address pool of the server
+type AddressManager interface {
+	SetStatic(users []*User) error
+	Acquire(userName, clientID string) (IfIP, error)
+	Reserve() (IfIP, error)
+	Release(clientID string)
+}
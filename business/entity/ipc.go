@@ -5,8 +5,18 @@ type ClientState struct {
 }
 
 type ConnectionInfo struct {
-	ID          int
-	Handler     ClientConnectionHandler
-	IsConnected bool
-	ConnectTs   int64
+	ID               int
+	Handler          ClientConnectionHandler
+	IsConnected      bool
+	ConnectTs        int64
+	IsReconnecting   bool
+	ReconnectAttempt int
+	NextReconnectTs  int64
+}
+
+// ReconnectState state of the automatic reconnect of the client connection
+type ReconnectState struct {
+	IsReconnecting bool
+	Attempt        int
+	NextAttemptTs  int64
 }
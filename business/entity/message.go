@@ -38,6 +38,8 @@ type Message struct {
 	IsError          bool
 	IsRequest        bool
 	IsACK            bool
+	MonotonicID      bool
+	KeyPhase         bool
 	Payload          interface{}
 	PacketInfo       *NetworkPacketInfo
 }
@@ -114,6 +116,8 @@ func (m *Message) Reset() {
 	m.IsError = false
 	m.IsRequest = false
 	m.IsACK = false
+	m.MonotonicID = false
+	m.KeyPhase = false
 	m.PacketInfo = nil
 }
 
@@ -144,16 +148,33 @@ type MessageAuthenticationRequest struct {
 	Password         string
 	CompressionType  CompressionType
 	CompressionLevel CompressionLevel
+	PublicKey        []byte
+	Signature        []byte
+	Version          ProtocolVersion
+	Extensions       Extensions
 }
 
 // MessageAuthenticationResponse represents an authentication response message.
 type MessageAuthenticationResponse struct {
-	SessionID uint32
-	LocalIP   net.IP
-	RemoteIP  net.IP
+	SessionID  uint32
+	LocalIP    net.IP
+	RemoteIP   net.IP
+	LocalIP6   net.IP
+	RemoteIP6  net.IP
+	Challenge  []byte
+	Version    ProtocolVersion
+	Extensions Extensions
 }
 
 // MessageHandshake represents a handshake message.
+// KEM is the ML-KEM-768 encapsulation key of the client or the ciphertext of the server
+// in the hybrid key exchange, it is empty in the X25519 key exchange.
+// Proof is the digest of the rekey request sealed with the current key of the connection,
+// it is empty in the initial handshake.
 type MessageHandshake struct {
-	Key []byte
+	Key       []byte
+	Version   ProtocolVersion
+	Signature []byte
+	KEM       []byte
+	Proof     []byte
 }
@@ -6,16 +6,18 @@ import (
 	"fmt"
 	"net"
 	"net/netip"
+	"slices"
 	"time"
 
 	"github.com/panjf2000/gnet/v2"
 
 	"github.com/forest33/tapir/pkg/logger"
+	"github.com/forest33/tapir/pkg/structs"
 )
 
 const (
 	HeaderSize        = 12
-	connectionKeySize = 9
+	connectionKeySize = 22
 )
 
 const (
@@ -23,6 +25,12 @@ const (
 	ProtoUDP
 )
 
+const (
+	AddressFamilyPreferIPv4 AddressFamily = iota + 1
+	AddressFamilyPreferIPv6
+	AddressFamilyDual
+)
+
 const (
 	PortSelectionStrategyRandom PortSelectionStrategy = iota + 1
 	PortSelectionStrategyHash
@@ -75,6 +83,65 @@ func GetPortSelectionStrategy(name string) PortSelectionStrategy {
 	}
 }
 
+type AddressFamily uint8
+
+func GetAddressFamily(name string) AddressFamily {
+	switch name {
+	case AddressFamilyNamePreferIPv4:
+		return AddressFamilyPreferIPv4
+	case AddressFamilyNamePreferIPv6:
+		return AddressFamilyPreferIPv6
+	case AddressFamilyNameDual:
+		return AddressFamilyDual
+	default:
+		return AddressFamilyPreferIPv4
+	}
+}
+
+// ListenIP returns the wildcard address to listen on when no host is configured.
+// In dual mode it returns nil, which makes the listener accept both IPv4 and IPv6 peers.
+func (f AddressFamily) ListenIP() net.IP {
+	switch f {
+	case AddressFamilyPreferIPv6:
+		return net.IPv6unspecified
+	case AddressFamilyDual:
+		return nil
+	default:
+		return net.IPv4zero
+	}
+}
+
+// SelectIP picks the address to connect to from the resolved addresses of the server.
+// If only one address family is available it is used regardless of the preference,
+// in dual mode odd ports use IPv6 and even ports use IPv4.
+func (f AddressFamily) SelectIP(ips []net.IP, port uint16) net.IP {
+	var ip4, ip6 net.IP
+	for _, ip := range ips {
+		if ip.To4() != nil {
+			if ip4 == nil {
+				ip4 = ip
+			}
+		} else if ip6 == nil {
+			ip6 = ip
+		}
+	}
+
+	if ip4 == nil {
+		return ip6
+	} else if ip6 == nil {
+		return ip4
+	}
+
+	switch f {
+	case AddressFamilyPreferIPv6:
+		return ip6
+	case AddressFamilyDual:
+		return structs.If(port%2 == 1, ip6, ip4)
+	default:
+		return ip4
+	}
+}
+
 type ReceiverHandler func(*Message, *Connection) error
 type DisconnectHandler func(*Connection, error)
 type ResetHandler func(uint32, *Connection)
@@ -90,13 +157,16 @@ type NetworkRetry interface {
 	Ack(*MessageAcknowledgement)
 	Keepalive()
 	GetRTO() time.Duration
+	SetConnection(*Connection)
 	Stop()
+	IsStopped() bool
 }
 
 type NetworkRetryFactory func(context.Context, *logger.Logger, RetrySender, KeepaliveSender, DisconnectHandler, *Connection) NetworkRetry
 
 type NetworkAck interface {
 	Push(uint32, PacketEndpoint)
+	SetConnection(*Connection)
 	Stop()
 }
 
@@ -116,23 +186,70 @@ type Protocol uint8
 func (p Protocol) String() string {
 	switch p {
 	case ProtoTCP:
-		return "tcp4"
+		return "tcp"
 	case ProtoUDP:
-		return "udp4"
+		return "udp"
 	default:
 		return "unknown"
 	}
 }
 
+// Network returns the network name for the given address, e.g. "tcp4" or "udp6".
+// For a nil address the dual-stack network name is returned.
+func (p Protocol) Network(ip net.IP) string {
+	switch {
+	case ip == nil:
+		return p.String()
+	case ip.To4() != nil:
+		return p.String() + "4"
+	default:
+		return p.String() + "6"
+	}
+}
+
 type NetworkMessage interface {
 	Encode()
 }
 
 type IfIP struct {
-	ServerLocal  net.IP
-	ServerRemote net.IP
-	ClientLocal  net.IP
-	ClientRemote net.IP
+	ServerLocal   net.IP
+	ServerRemote  net.IP
+	ClientLocal   net.IP
+	ClientRemote  net.IP
+	ServerLocal6  net.IP
+	ServerRemote6 net.IP
+	ClientLocal6  net.IP
+	ClientRemote6 net.IP
+}
+
+// HasIPv6 reports whether IPv6 tunnel addresses are assigned.
+func (ip IfIP) HasIPv6() bool {
+	return ip.ServerLocal6 != nil && ip.ServerRemote6 != nil
+}
+
+// Equal reports whether all tunnel addresses are the same
+func (ip IfIP) Equal(other IfIP) bool {
+	return ip.ServerLocal.Equal(other.ServerLocal) && ip.ServerRemote.Equal(other.ServerRemote) &&
+		ip.ClientLocal.Equal(other.ClientLocal) && ip.ClientRemote.Equal(other.ClientRemote) &&
+		ip.ServerLocal6.Equal(other.ServerLocal6) && ip.ServerRemote6.Equal(other.ServerRemote6) &&
+		ip.ClientLocal6.Equal(other.ClientLocal6) && ip.ClientRemote6.Equal(other.ClientRemote6)
+}
+
+// PushedNetwork is the network configuration pushed by the server in the authentication response
+type PushedNetwork struct {
+	DNS           []netip.Addr
+	SearchDomains []string
+	Routes        []netip.Prefix
+	MTU           int
+}
+
+// Equal reports whether the pushed network configurations are the same
+func (n *PushedNetwork) Equal(other *PushedNetwork) bool {
+	if n == nil || other == nil {
+		return n == other
+	}
+	return n.MTU == other.MTU && slices.Equal(n.DNS, other.DNS) &&
+		slices.Equal(n.SearchDomains, other.SearchDomains) && slices.Equal(n.Routes, other.Routes)
 }
 
 type Connection struct {
@@ -148,6 +265,22 @@ type Connection struct {
 	CreatedAt        int64
 	CompressionType  CompressionType
 	CompressionLevel CompressionLevel
+	LegacyHeader     bool
+	Uplink           *Uplink
+}
+
+// Uplink is a local interface or a source address the client paths are bound to
+type Uplink struct {
+	ID   uint8
+	Name string
+}
+
+// GetName returns the name of the uplink, an empty string for the paths through the default route
+func (u *Uplink) GetName() string {
+	if u == nil {
+		return ""
+	}
+	return u.Name
 }
 
 type ConnectionKey [connectionKeySize]byte
@@ -188,7 +321,7 @@ func (c *Connection) KeyOld() ConnectionKey {
 	binary.LittleEndian.PutUint16(lPort, c.Port)
 	binary.LittleEndian.PutUint16(rPort, rAddr.Port())
 
-	rIP := rAddr.Addr().As4()
+	rIP := rAddr.Addr().As16()
 	key = append(key, lPort...)
 	key = append(key, rIP[:]...)
 	key = append(key, rPort...)
@@ -207,7 +340,7 @@ func (c *Connection) KeyOld() ConnectionKey {
 //
 // - 1 byte for connection protocol (TCP or UDP)
 // - 2 bytes for local port of connection
-// - 4 bytes for remote IPv4 address
+// - 16 bytes for remote IP address (IPv4 addresses are stored as IPv4-mapped IPv6)
 // - 2 bytes for remote port
 //
 // If the port is 0, it returns an empty ConnectionKey.
@@ -229,21 +362,21 @@ func (c *Connection) Key() ConnectionKey {
 
 	if c.TCPConn != nil {
 		addr := c.TCPConn.RemoteAddr().(*net.TCPAddr)
-		ip = addr.IP.To4()
+		ip = addr.IP.To16()
 		port = uint16(addr.Port)
 		key = append(key, byte(c.Proto))
 	} else if c.UDPConn != nil {
 		addr := c.Addr.(*net.UDPAddr)
-		ip = addr.IP.To4()
+		ip = addr.IP.To16()
 		port = uint16(addr.Port)
 		key = append(key, byte(c.Proto))
 	} else if c.GNetConn != nil {
 		switch addr := c.GNetConn.RemoteAddr().(type) {
 		case *net.UDPAddr:
-			ip = addr.IP.To4()
+			ip = addr.IP.To16()
 			port = uint16(addr.Port)
 		case *net.TCPAddr:
-			ip = addr.IP.To4()
+			ip = addr.IP.To16()
 			port = uint16(addr.Port)
 		default:
 			panic(fmt.Sprintf("wrong address type: %+v", c))
@@ -259,6 +392,9 @@ func (c *Connection) Key() ConnectionKey {
 	key = append(key, lPort...)
 	key = append(key, ip...)
 	key = append(key, rPort...)
+	if c.Uplink != nil {
+		key = append(key, c.Uplink.ID)
+	}
 
 	copy(cKey[:], key)
 
@@ -290,4 +426,57 @@ type Statistic struct {
 	OutgoingRateBytes  float64
 	IncomingRateFrames float64
 	OutgoingRateFrames float64
+	ReplayedFrames     uint64
+	Uplink             string                `json:"-"`
+	Uplinks            map[string]*Statistic `json:",omitempty"`
+}
+
+// Add adds the counters of the other statistic, the counters of the uplink are added to the uplink statistic too
+func (s *Statistic) Add(other *Statistic) {
+	s.IncomingBytes += other.IncomingBytes
+	s.OutgoingBytes += other.OutgoingBytes
+	s.IncomingFrames += other.IncomingFrames
+	s.OutgoingFrames += other.OutgoingFrames
+	s.ReplayedFrames += other.ReplayedFrames
+
+	if other.Uplink != "" {
+		if s.Uplinks == nil {
+			s.Uplinks = make(map[string]*Statistic)
+		}
+		if _, ok := s.Uplinks[other.Uplink]; !ok {
+			s.Uplinks[other.Uplink] = &Statistic{}
+		}
+		s.Uplinks[other.Uplink].Add(&Statistic{
+			IncomingBytes:  other.IncomingBytes,
+			OutgoingBytes:  other.OutgoingBytes,
+			IncomingFrames: other.IncomingFrames,
+			OutgoingFrames: other.OutgoingFrames,
+			ReplayedFrames: other.ReplayedFrames,
+		})
+	}
+	for name, u := range other.Uplinks {
+		if s.Uplinks == nil {
+			s.Uplinks = make(map[string]*Statistic)
+		}
+		if _, ok := s.Uplinks[name]; !ok {
+			s.Uplinks[name] = &Statistic{}
+		}
+		s.Uplinks[name].Add(u)
+	}
+}
+
+// SetRate sets the rates from the counters collected during the interval in seconds
+func (s *Statistic) SetRate(delta *Statistic, interval float64) {
+	s.IncomingRateBytes = float64(delta.IncomingBytes) / interval
+	s.OutgoingRateBytes = float64(delta.OutgoingBytes) / interval
+	s.IncomingRateFrames = float64(delta.IncomingFrames) / interval
+	s.OutgoingRateFrames = float64(delta.OutgoingFrames) / interval
+
+	for name, u := range s.Uplinks {
+		if d, ok := delta.Uplinks[name]; ok {
+			u.SetRate(d, interval)
+		} else {
+			u.SetRate(&Statistic{}, interval)
+		}
+	}
 }
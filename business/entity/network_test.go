@@ -2,6 +2,7 @@ package entity
 
 import (
 	"net"
+	"net/netip"
 	"testing"
 )
 
@@ -37,7 +38,7 @@ func TestConnectionKey(t *testing.T) {
 	for i := range data {
 		k := data[i].in.Key()
 		if k != data[i].out {
-			t.Errorf("wrong key")
+			t.Errorf("wrong key %d", i)
 		}
 	}
 }
@@ -46,6 +47,7 @@ func getNetworkTestData() []connTestData {
 	addrTCP, _ := net.ResolveTCPAddr("tcp", "216.58.211.238:443")
 	addrUDP, _ := net.ResolveUDPAddr("udp", "8.8.8.8:53")
 	tcp, _ := net.DialTCP("tcp", nil, addrTCP)
+	addrUDP6, _ := net.ResolveUDPAddr("udp", "[2001:4860:4860::8888]:53")
 	udp, _ := net.DialUDP("udp", nil, addrUDP)
 
 	return []connTestData{
@@ -55,7 +57,8 @@ func getNetworkTestData() []connTestData {
 				Port:    1977,
 				Proto:   ProtoTCP,
 			},
-			out: ConnectionKey{0x01, 0xb9, 0x07, 0xd8, 0x3a, 0xd3, 0xee, 0xbb, 0x01},
+			out: ConnectionKey{0x01, 0xb9, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff,
+				0xd8, 0x3a, 0xd3, 0xee, 0xbb, 0x01},
 		},
 		{
 			in: Connection{
@@ -64,7 +67,112 @@ func getNetworkTestData() []connTestData {
 				Port:    1977,
 				Proto:   ProtoUDP,
 			},
-			out: ConnectionKey{0x02, 0xb9, 0x07, 0x08, 0x08, 0x08, 0x08, 0x35, 0x00},
+			out: ConnectionKey{0x02, 0xb9, 0x07, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff,
+				0x08, 0x08, 0x08, 0x08, 0x35, 0x00},
 		},
+		{
+			in: Connection{
+				UDPConn: udp,
+				Addr:    addrUDP6,
+				Port:    1977,
+				Proto:   ProtoUDP,
+			},
+			out: ConnectionKey{0x02, 0xb9, 0x07, 0x20, 0x01, 0x48, 0x60, 0x48, 0x60, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x00, 0x00, 0x88, 0x88, 0x35, 0x00},
+		},
+	}
+}
+
+func TestAddressFamilySelectIP(t *testing.T) {
+	ip4 := net.ParseIP("192.0.2.1")
+	ip6 := net.ParseIP("2001:db8::1")
+
+	data := []struct {
+		family AddressFamily
+		ips    []net.IP
+		port   uint16
+		out    net.IP
+	}{
+		{AddressFamilyPreferIPv4, []net.IP{ip6, ip4}, 1977, ip4},
+		{AddressFamilyPreferIPv6, []net.IP{ip4, ip6}, 1977, ip6},
+		{AddressFamilyPreferIPv4, []net.IP{ip6}, 1977, ip6},
+		{AddressFamilyPreferIPv6, []net.IP{ip4}, 1977, ip4},
+		{AddressFamilyDual, []net.IP{ip4, ip6}, 1977, ip6},
+		{AddressFamilyDual, []net.IP{ip4, ip6}, 1978, ip4},
+		{AddressFamilyDual, nil, 1978, nil},
+	}
+
+	for i, d := range data {
+		if ip := d.family.SelectIP(d.ips, d.port); !ip.Equal(d.out) {
+			t.Errorf("wrong address %d: %s, should be %s", i, ip, d.out)
+		}
+	}
+}
+
+func TestInterfaceEqual(t *testing.T) {
+	ip := IfIP{
+		ServerLocal:  net.ParseIP("192.168.30.2"),
+		ServerRemote: net.ParseIP("192.168.30.1"),
+	}
+	other := IfIP{
+		ServerLocal:  net.ParseIP("192.168.30.2").To4(),
+		ServerRemote: net.ParseIP("192.168.30.1"),
+	}
+	if !ip.Equal(other) {
+		t.Error("addresses should be equal")
+	}
+	other.ServerLocal6 = net.ParseIP("fd00::2")
+	if ip.Equal(other) {
+		t.Error("addresses should not be equal")
+	}
+
+	network := &PushedNetwork{DNS: []netip.Addr{netip.MustParseAddr("10.0.0.53")}, MTU: 1400}
+	if !network.Equal(&PushedNetwork{DNS: []netip.Addr{netip.MustParseAddr("10.0.0.53")}, MTU: 1400}) {
+		t.Error("networks should be equal")
+	}
+	if network.Equal(&PushedNetwork{MTU: 1400}) || network.Equal(nil) {
+		t.Error("networks should not be equal")
+	}
+	if !(*PushedNetwork)(nil).Equal(nil) {
+		t.Error("nil networks should be equal")
+	}
+}
+
+func TestConnectionKeyUplink(t *testing.T) {
+	addr, _ := net.ResolveUDPAddr("udp", "8.8.8.8:53")
+	udp, _ := net.DialUDP("udp", nil, addr)
+
+	conn := Connection{UDPConn: udp, Addr: addr, Port: 1977, Proto: ProtoUDP}
+	wlan := Connection{UDPConn: udp, Addr: addr, Port: 1977, Proto: ProtoUDP, Uplink: &Uplink{ID: 1, Name: "wlan0"}}
+	lte := Connection{UDPConn: udp, Addr: addr, Port: 1977, Proto: ProtoUDP, Uplink: &Uplink{ID: 2, Name: "wwan0"}}
+
+	if conn.Key() == wlan.Key() || wlan.Key() == lte.Key() {
+		t.Error("paths through different uplinks should have different keys")
+	}
+}
+
+func TestStatisticUplinks(t *testing.T) {
+	delta := &Statistic{}
+	delta.Add(&Statistic{IncomingBytes: 100, IncomingFrames: 1, Uplink: "wlan0"})
+	delta.Add(&Statistic{OutgoingBytes: 50, OutgoingFrames: 1, Uplink: "wwan0"})
+	delta.Add(&Statistic{OutgoingBytes: 10, OutgoingFrames: 1})
+
+	stat := &Statistic{}
+	stat.Add(delta)
+	stat.SetRate(delta, 2)
+
+	if stat.IncomingBytes != 100 || stat.OutgoingBytes != 60 || stat.OutgoingFrames != 2 {
+		t.Errorf("wrong total statistic: %+v", stat)
+	}
+	if len(stat.Uplinks) != 2 || stat.Uplinks["wlan0"].IncomingBytes != 100 || stat.Uplinks["wwan0"].OutgoingBytes != 50 {
+		t.Errorf("wrong uplink statistic: %+v", stat.Uplinks)
+	}
+	if stat.OutgoingRateBytes != 30 || stat.Uplinks["wwan0"].OutgoingRateBytes != 25 {
+		t.Errorf("wrong rate: %f %f", stat.OutgoingRateBytes, stat.Uplinks["wwan0"].OutgoingRateBytes)
+	}
+
+	stat.SetRate(&Statistic{}, 2)
+	if stat.OutgoingRateBytes != 0 || stat.Uplinks["wwan0"].OutgoingRateBytes != 0 {
+		t.Error("rates should be reset")
 	}
 }
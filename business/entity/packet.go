@@ -1,9 +1,22 @@
 package entity
 
+import (
+	"net"
+	"net/netip"
+)
+
 type PacketDecoder interface {
 	Decode(data []byte) (*NetworkPacketInfo, error)
+	DecodeDNS(data []byte) (*DNSResponse, error)
 }
 
+const (
+	// EthernetHeaderLength is the length of the header of an Ethernet frame
+	EthernetHeaderLength = 14
+	// EthernetHeaderMaxLength is the length of the header of an Ethernet frame with a VLAN tag
+	EthernetHeaderMaxLength = 18
+)
+
 type PacketEndpoint uint64
 
 func (e PacketEndpoint) Uint64() uint64 {
@@ -11,14 +24,31 @@ func (e PacketEndpoint) Uint64() uint64 {
 }
 
 type NetworkPacketInfo struct {
-	Endpoint PacketEndpoint
-	Protocol IPProtocol
-	IP       *IP
-	TCP      *TCP
-	UDP      *UDP
-	TLS      *TLS
-	ICMPv4   *ICMP
-	IfName   string
+	Endpoint       PacketEndpoint
+	PeerEndpoint   PacketEndpoint // the endpoint of the packet on the other side of the tunnel
+	Protocol       IPProtocol
+	Source         netip.Addr
+	Destination    netip.Addr
+	SourceMAC      HardwareAddr
+	DestinationMAC HardwareAddr
+	IP             *IP
+	TCP            *TCP
+	UDP            *UDP
+	TLS            *TLS
+	ICMPv4         *ICMP
+	IfName         string
+}
+
+// HardwareAddr is a MAC address of an Ethernet frame
+type HardwareAddr [6]byte
+
+// IsMulticast returns true if the address is a multicast or the broadcast address
+func (a HardwareAddr) IsMulticast() bool {
+	return a[0]&0x01 != 0
+}
+
+func (a HardwareAddr) String() string {
+	return net.HardwareAddr(a[:]).String()
 }
 
 type IP struct {
@@ -59,6 +89,17 @@ type ICMP struct {
 	Error  error
 }
 
+// DNSResponse addresses resolved by a DNS response
+type DNSResponse struct {
+	Question string
+	Answers  []DNSAnswer
+}
+
+type DNSAnswer struct {
+	Addr netip.Addr
+	TTL  uint32
+}
+
 type IPProtocol uint8
 
 const (
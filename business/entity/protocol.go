@@ -0,0 +1,256 @@
+package entity
+
+import (
+	"encoding/binary"
+	"math"
+	"net/netip"
+
+	"github.com/go-ozzo/ozzo-validation/v4/is"
+)
+
+const (
+	// ProtocolVersion1 uses the raw shared key of the handshake for both directions
+	ProtocolVersion1 ProtocolVersion = 1
+	// ProtocolVersion2 derives separate per-direction and header protection keys from the shared key with HKDF
+	ProtocolVersion2 ProtocolVersion = 2
+	// ProtocolVersion3 adds the protocol version and TLV extensions to the authentication exchange
+	ProtocolVersion3 ProtocolVersion = 3
+
+	// ProtocolVersionCurrent is the highest version supported by this implementation
+	ProtocolVersionCurrent = ProtocolVersion3
+)
+
+const (
+	// ExtensionEncryption is the name of the encryption method
+	ExtensionEncryption ExtensionType = iota + 1
+	// ExtensionCompression is the compression type followed by the compression level
+	ExtensionCompression
+	// ExtensionMTU is the 16-bit MTU of the tunnel, the server responds with the lowest MTU of both peers
+	ExtensionMTU
+	// ExtensionFeatures is the 32-bit mask of the optional features, the server responds with the features supported by both peers
+	ExtensionFeatures
+	// ExtensionDNS is the list of DNS servers pushed by the server
+	ExtensionDNS
+	// ExtensionSearchDomains is the list of DNS search domains pushed by the server
+	ExtensionSearchDomains
+	// ExtensionRoutes is the list of routes in CIDR notation pushed by the server
+	ExtensionRoutes
+	// ExtensionUplink is the 8-bit ID of the client uplink the path is bound to
+	ExtensionUplink
+	// ExtensionSubnets is the list of subnets in CIDR notation routed by the client, the server responds with the accepted subnets
+	ExtensionSubnets
+	// ExtensionDeviceType is the type of the tunnel device, tun if not set
+	ExtensionDeviceType
+)
+
+const (
+	FeatureHeaderProtection Feature = 1 << iota
+	FeatureHybridKeyExchange
+	FeaturePublicKeyAuthentication
+)
+
+// ProtocolVersion is a version of the handshake protocol negotiated by the client and the server.
+// Peers that do not send a version are treated as ProtocolVersion1.
+type ProtocolVersion uint8
+
+// Get returns the effective protocol version
+func (v ProtocolVersion) Get() ProtocolVersion {
+	if v == 0 {
+		return ProtocolVersion1
+	}
+	return v
+}
+
+// Negotiate returns the highest version supported by both peers
+func (v ProtocolVersion) Negotiate(peer ProtocolVersion) ProtocolVersion {
+	if peer.Get() < v.Get() {
+		return peer.Get()
+	}
+	return v.Get()
+}
+
+const (
+	// KeyExchangeX25519 uses the X25519 key exchange only
+	KeyExchangeX25519 KeyExchangeMethod = "x25519"
+	// KeyExchangeX25519MLKEM768 combines X25519 with the ML-KEM-768 encapsulation,
+	// so the session keys stay secret even if one of them is broken
+	KeyExchangeX25519MLKEM768 KeyExchangeMethod = "x25519-mlkem768"
+)
+
+// KeyExchangeMethod is a key exchange method of the handshake
+type KeyExchangeMethod string
+
+func (m KeyExchangeMethod) String() string {
+	return string(m)
+}
+
+// IsHybrid reports whether the post-quantum ML-KEM encapsulation is used
+func (m KeyExchangeMethod) IsHybrid() bool {
+	return m == KeyExchangeX25519MLKEM768
+}
+
+// ExtensionType is a type of the TLV extension of the authentication exchange
+type ExtensionType uint8
+
+// Extensions are TLV extensions of the authentication exchange, unknown types are ignored by peers
+type Extensions map[ExtensionType][]byte
+
+// Feature is an optional feature negotiated in the authentication exchange
+type Feature uint32
+
+// Has reports whether all features of f are set
+func (f Feature) Has(feature Feature) bool {
+	return f&feature == feature
+}
+
+// SetUint8 sets the 8-bit value of the extension
+func (e Extensions) SetUint8(t ExtensionType, v uint8) {
+	e[t] = []byte{v}
+}
+
+// Uint8 returns the 8-bit value of the extension
+func (e Extensions) Uint8(t ExtensionType) (uint8, bool) {
+	if v, ok := e[t]; ok && len(v) == 1 {
+		return v[0], true
+	}
+	return 0, false
+}
+
+// SetUint16 sets the 16-bit value of the extension
+func (e Extensions) SetUint16(t ExtensionType, v uint16) {
+	e[t] = binary.BigEndian.AppendUint16(nil, v)
+}
+
+// Uint16 returns the 16-bit value of the extension
+func (e Extensions) Uint16(t ExtensionType) (uint16, bool) {
+	if v, ok := e[t]; ok && len(v) == 2 {
+		return binary.BigEndian.Uint16(v), true
+	}
+	return 0, false
+}
+
+// SetUint32 sets the 32-bit value of the extension
+func (e Extensions) SetUint32(t ExtensionType, v uint32) {
+	e[t] = binary.BigEndian.AppendUint32(nil, v)
+}
+
+// Uint32 returns the 32-bit value of the extension
+func (e Extensions) Uint32(t ExtensionType) (uint32, bool) {
+	if v, ok := e[t]; ok && len(v) == 4 {
+		return binary.BigEndian.Uint32(v), true
+	}
+	return 0, false
+}
+
+// SetStrings sets the list of strings of the extension, each string is prefixed with its 8-bit length
+func (e Extensions) SetStrings(t ExtensionType, v []string) {
+	var data []byte
+	for _, s := range v {
+		if len(s) > math.MaxUint8 {
+			continue
+		}
+		data = append(data, byte(len(s)))
+		data = append(data, s...)
+	}
+	e[t] = data
+}
+
+// Strings returns the list of strings of the extension
+func (e Extensions) Strings(t ExtensionType) ([]string, bool) {
+	data, ok := e[t]
+	if !ok {
+		return nil, false
+	}
+
+	var res []string
+	for len(data) > 0 {
+		l := int(data[0])
+		if l > len(data)-1 {
+			return nil, false
+		}
+		res = append(res, string(data[1:l+1]))
+		data = data[l+1:]
+	}
+
+	return res, true
+}
+
+// Prefixes returns the list of networks of the extension in CIDR notation, invalid networks are skipped
+func (e Extensions) Prefixes(t ExtensionType) ([]netip.Prefix, bool) {
+	list, ok := e.Strings(t)
+	if !ok {
+		return nil, false
+	}
+
+	res := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if prefix, err := netip.ParsePrefix(s); err == nil {
+			res = append(res, prefix.Masked())
+		}
+	}
+
+	return res, true
+}
+
+// DeviceType returns the type of the tunnel device of the peer
+func (e Extensions) DeviceType() DeviceType {
+	if v, ok := e[ExtensionDeviceType]; ok {
+		return DeviceType(v)
+	}
+	return DeviceTypeTUN
+}
+
+// Features returns the optional features of the peer
+func (e Extensions) Features() Feature {
+	f, _ := e.Uint32(ExtensionFeatures)
+	return Feature(f)
+}
+
+// SetPushedNetwork adds the pushed network configuration to the extensions
+func (e Extensions) SetPushedNetwork(c *PushConfig) {
+	if len(c.DNS) != 0 {
+		e.SetStrings(ExtensionDNS, c.DNS)
+	}
+	if len(c.SearchDomains) != 0 {
+		e.SetStrings(ExtensionSearchDomains, c.SearchDomains)
+	}
+	if len(c.Routes) != 0 {
+		e.SetStrings(ExtensionRoutes, c.Routes)
+	}
+}
+
+// PushedNetwork returns the network configuration pushed by the server, invalid addresses and domains are skipped,
+// the search domains are passed to the commands of the interface templates.
+// The MTU is the value negotiated by the server.
+func (e Extensions) PushedNetwork() *PushedNetwork {
+	n := &PushedNetwork{}
+	if mtu, ok := e.Uint16(ExtensionMTU); ok {
+		n.MTU = int(mtu)
+	}
+	if dns, ok := e.Strings(ExtensionDNS); ok {
+		for _, s := range dns {
+			if addr, err := netip.ParseAddr(s); err == nil {
+				n.DNS = append(n.DNS, addr)
+			}
+		}
+	}
+	if domains, ok := e.Strings(ExtensionSearchDomains); ok {
+		for _, s := range domains {
+			if s != "" && is.DNSName.Validate(s) == nil {
+				n.SearchDomains = append(n.SearchDomains, s)
+			}
+		}
+	}
+	if routes, ok := e.Prefixes(ExtensionRoutes); ok && len(routes) != 0 {
+		n.Routes = routes
+	}
+	return n
+}
+
+// SessionKeys represents the keys derived from the shared key of the handshake
+type SessionKeys struct {
+	ClientToServer       []byte
+	ServerToClient       []byte
+	ClientToServerHeader []byte
+	ServerToClientHeader []byte
+}
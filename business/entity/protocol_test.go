@@ -0,0 +1,39 @@
+package entity
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+)
+
+func TestPushedNetwork(t *testing.T) {
+	ext := Extensions{}
+	ext.SetUint16(ExtensionMTU, 1380)
+	ext.SetPushedNetwork((&PushConfig{
+		DNS:    []string{"10.0.0.53"},
+		Routes: []string{"10.0.0.0/8", "fd00:10::1/64"},
+	}).Merge(&PushConfig{
+		DNS:           []string{"10.0.0.54", "fd00::53"},
+		SearchDomains: []string{"corp.example.com", "corp.example.com; reboot", "$(reboot)", ""},
+	}))
+
+	want := &PushedNetwork{
+		DNS:           []netip.Addr{netip.MustParseAddr("10.0.0.54"), netip.MustParseAddr("fd00::53")},
+		SearchDomains: []string{"corp.example.com"},
+		Routes:        []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8"), netip.MustParsePrefix("fd00:10::/64")},
+		MTU:           1380,
+	}
+
+	if n := ext.PushedNetwork(); !reflect.DeepEqual(n, want) {
+		t.Errorf("wrong pushed network: %+v, should be %+v", n, want)
+	}
+}
+
+func TestExtensionsDeviceType(t *testing.T) {
+	if typ := Extensions(nil).DeviceType(); typ != DeviceTypeTUN {
+		t.Errorf("wrong default device type %s", typ)
+	}
+	if typ := (Extensions{ExtensionDeviceType: []byte(DeviceTypeTAP)}).DeviceType(); typ != DeviceTypeTAP {
+		t.Errorf("wrong device type %s", typ)
+	}
+}
@@ -0,0 +1,162 @@
+package entity
+
+import (
+	"sync"
+	"time"
+)
+
+const replayBlockBits = 64
+
+// ReplayFilter is a sliding window anti-replay filter for data messages (see RFC 6479).
+//
+// Message IDs are sequences of the packet endpoint, so a separate window is kept
+// for every session and endpoint. Idle windows are removed after ttl seconds.
+//
+// Peers without monotonic IDs restart the sequence of an idle endpoint from 1, their windows are simply removed.
+// The monotonic sequences never go back: the highest ID of a removed window becomes the floor of the session,
+// and a new window starts with all IDs up to the floor marked as received, so removed windows can't be replayed.
+type ReplayFilter struct {
+	size    uint32
+	ttl     int64
+	lastGC  int64
+	windows map[replayKey]*replayWindow
+	floors  map[uint32]uint32
+	sync.Mutex
+}
+
+type replayKey struct {
+	sessionID uint32
+	endpoint  PacketEndpoint
+}
+
+type replayWindow struct {
+	last      uint32
+	ts        int64
+	monotonic bool
+	bitmap    []uint64
+}
+
+// NewReplayFilter creates a filter with a window of size message IDs (rounded up to 64).
+// If size is 0 the filter is disabled and nil is returned, all methods are safe to call on a nil filter.
+func NewReplayFilter(size int, ttl int64) *ReplayFilter {
+	if size <= 0 {
+		return nil
+	}
+
+	blocks := (size + replayBlockBits - 1) / replayBlockBits
+
+	return &ReplayFilter{
+		size:    uint32(blocks * replayBlockBits),
+		ttl:     ttl,
+		lastGC:  time.Now().Unix(),
+		windows: make(map[replayKey]*replayWindow, 100),
+		floors:  make(map[uint32]uint32),
+	}
+}
+
+// Check reports whether the message ID is received for the first time and marks it as received.
+// The monotonic flag of the message tells whether the sequence of the endpoint may be restarted by the sender.
+func (f *ReplayFilter) Check(sessionID uint32, endpoint PacketEndpoint, id uint32, monotonic bool) bool {
+	if f == nil {
+		return true
+	}
+	if id == 0 {
+		return false
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	now := time.Now().Unix()
+	f.gc(now)
+
+	key := replayKey{sessionID: sessionID, endpoint: endpoint}
+	w, ok := f.windows[key]
+	if !ok {
+		w = f.newWindow(sessionID, monotonic)
+		f.windows[key] = w
+	}
+	w.ts = now
+
+	if id > w.last {
+		if id-w.last >= f.size {
+			clear(w.bitmap)
+		} else {
+			for i := w.last + 1; i < id; i++ {
+				w.unset(i, f.size)
+			}
+		}
+		w.last = id
+		w.set(id, f.size)
+		return true
+	}
+
+	if w.last-id >= f.size || w.isSet(id, f.size) {
+		return false
+	}
+	w.set(id, f.size)
+
+	return true
+}
+
+// DeleteSession removes all windows of the session.
+func (f *ReplayFilter) DeleteSession(sessionID uint32) {
+	if f == nil {
+		return
+	}
+
+	f.Lock()
+	defer f.Unlock()
+
+	for k := range f.windows {
+		if k.sessionID == sessionID {
+			delete(f.windows, k)
+		}
+	}
+	delete(f.floors, sessionID)
+}
+
+// newWindow creates the window of a session endpoint, a monotonic window rejects the IDs up to the floor of the session
+func (f *ReplayFilter) newWindow(sessionID uint32, monotonic bool) *replayWindow {
+	w := &replayWindow{
+		monotonic: monotonic,
+		bitmap:    make([]uint64, f.size/replayBlockBits),
+	}
+	if floor, ok := f.floors[sessionID]; ok && monotonic {
+		w.last = floor
+		for i := range w.bitmap {
+			w.bitmap[i] = ^uint64(0)
+		}
+	}
+	return w
+}
+
+func (f *ReplayFilter) gc(now int64) {
+	if f.ttl <= 0 || now < f.lastGC+f.ttl {
+		return
+	}
+	for k, w := range f.windows {
+		if now > w.ts+f.ttl {
+			if w.monotonic && w.last > f.floors[k.sessionID] {
+				f.floors[k.sessionID] = w.last
+			}
+			delete(f.windows, k)
+		}
+	}
+	f.lastGC = now
+}
+
+func (w *replayWindow) set(id, size uint32) {
+	bit := id % size
+	w.bitmap[bit/replayBlockBits] |= 1 << (bit % replayBlockBits)
+}
+
+func (w *replayWindow) unset(id, size uint32) {
+	bit := id % size
+	w.bitmap[bit/replayBlockBits] &^= 1 << (bit % replayBlockBits)
+}
+
+func (w *replayWindow) isSet(id, size uint32) bool {
+	bit := id % size
+	return w.bitmap[bit/replayBlockBits]&(1<<(bit%replayBlockBits)) != 0
+}
@@ -0,0 +1,82 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestReplayFilter(t *testing.T) {
+	f := NewReplayFilter(64, 0)
+
+	data := []struct {
+		endpoint PacketEndpoint
+		id       uint32
+		ok       bool
+	}{
+		{1, 1, true},
+		{1, 3, true},
+		{1, 2, true},
+		{1, 2, false},
+		{1, 3, false},
+		{2, 3, true},
+		{1, 0, false},
+		{1, 100, true},
+		{1, 36, false},
+		{1, 37, true},
+		{1, 37, false},
+		{1, 99, true},
+		{1, 100, false},
+		{1, 1000, true},
+		{1, 999, true},
+		{1, 936, false},
+	}
+
+	for i, d := range data {
+		if ok := f.Check(1, d.endpoint, d.id, false); ok != d.ok {
+			t.Errorf("wrong result %d: endpoint %d id %d: %t, should be %t", i, d.endpoint, d.id, ok, d.ok)
+		}
+	}
+
+	f.DeleteSession(1)
+	if !f.Check(1, 1, 1, false) {
+		t.Errorf("window is not deleted with session")
+	}
+
+	var disabled *ReplayFilter
+	if !disabled.Check(1, 1, 1, false) || !disabled.Check(1, 1, 1, false) {
+		t.Errorf("disabled filter must accept all messages")
+	}
+}
+
+func TestReplayFilterExpiredWindow(t *testing.T) {
+	const ttl = 10
+	f := NewReplayFilter(64, ttl)
+
+	for id := uint32(1); id <= 100; id++ {
+		if !f.Check(1, 1, id, true) || !f.Check(2, 1, id, false) {
+			t.Fatalf("message %d is dropped", id)
+		}
+	}
+	f.gc(time.Now().Unix() + ttl + 1)
+	if len(f.windows) != 0 {
+		t.Fatal("idle windows are not removed")
+	}
+
+	for _, id := range []uint32{1, 37, 99, 100} {
+		if f.Check(1, 1, id, true) {
+			t.Errorf("message %d is replayed after the window is removed", id)
+		}
+		if f.Check(1, 2, id, true) {
+			t.Errorf("message %d is accepted by a new endpoint below the floor of the session", id)
+		}
+	}
+	if !f.Check(1, 1, 101, true) || !f.Check(1, 2, 102, true) {
+		t.Error("monotonic sequence above the floor is dropped")
+	}
+	if !f.Check(2, 1, 1, false) || !f.Check(2, 1, 2, false) {
+		t.Error("restarted sequence of a peer without monotonic IDs is dropped")
+	}
+	if !f.Check(3, 1, 1, true) {
+		t.Error("floor of the session is applied to another session")
+	}
+}
@@ -34,10 +34,6 @@ func (uc *ClientUseCase) removeConnection(conn *entity.Connection) {
 	}
 
 	uc.connMux.Unlock()
-
-	if len(uc.connectionsMap) == 0 {
-		uc.Stop()
-	}
 }
 
 func (uc *ClientUseCase) closeAndRemoveAllConnections() {
@@ -87,7 +83,7 @@ func (uc *ClientUseCase) getConnectionEncryptor(conn *entity.Connection) (entity
 	return enc, structs.If(!ok, entity.ErrConnectionNotExists, nil)
 }
 
-func (uc *ClientUseCase) createConnection(port uint16, proto entity.Protocol) error {
+func (uc *ClientUseCase) createConnection(port uint16, proto entity.Protocol, uplink *entity.Uplink) error {
 	uc.connMux.Lock()
 	defer uc.connMux.Unlock()
 
@@ -103,10 +99,11 @@ func (uc *ClientUseCase) createConnection(port uint16, proto entity.Protocol) er
 			Str("host", uc.conn.Server.Host).
 			Uint16("port", port).
 			Str("protocol", proto.String()).
+			Str("uplink", uplink.GetName()).
 			Int("attempt", i+1).
 			Msg("connecting to server...")
 
-		conn, err := uc.client.Run(uc.conn.Server.Host, port, proto)
+		conn, err := uc.client.Run(uc.conn.Server.Host, port, proto, uplink)
 		if err != nil {
 			uc.log.Error().Err(err).
 				Str("host", uc.conn.Server.Host).
@@ -124,7 +121,14 @@ func (uc *ClientUseCase) createConnection(port uint16, proto entity.Protocol) er
 
 		uc.addConnection(conn, cc)
 
-		if err := uc.commandAuthentication(cc); err != nil {
+		err = uc.commandAuthentication(cc)
+		if errors.Is(err, entity.ErrUnauthorized) && uc.sessionID != 0 {
+			// the server rejects the sessions it has already dropped, a new session is created
+			uc.log.Info().Uint32("session_id", uc.sessionID).Msg("session expired")
+			uc.sessionID = 0
+			err = uc.commandAuthentication(cc)
+		}
+		if err != nil {
 			if errors.Is(err, entity.ErrUnauthorized) {
 				return err
 			}
@@ -178,10 +182,18 @@ func (uc *ClientUseCase) disconnect(conn *entity.Connection, err error) {
 	if err := conn.Close(); err != nil && !entity.IsErrorInterruptingNetwork(err) {
 		uc.log.Error().Err(err).Uint32("session_id", uc.sessionID).Msg("error when closing connection")
 	}
-	if err != nil && !uc.isExit.Load() {
-		err := uc.createConnection(conn.Port, structs.If(conn.TCPConn != nil, entity.ProtoTCP, entity.ProtoUDP))
-		if err != nil {
-			uc.Stop()
-		}
+	if uc.isExit.Load() || uc.reconnecting.Load() {
+		return
+	}
+	if err != nil {
+		err = uc.createConnection(conn.Port, structs.If(conn.TCPConn != nil, entity.ProtoTCP, entity.ProtoUDP), conn.Uplink)
+	}
+
+	uc.connMux.RLock()
+	length := len(uc.connectionsMap)
+	uc.connMux.RUnlock()
+
+	if err != nil || length == 0 {
+		uc.startReconnect(true)
 	}
 }
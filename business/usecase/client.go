@@ -2,8 +2,9 @@ package usecase
 
 import (
 	"context"
-	"crypto/ecdh"
-	"crypto/rand"
+	"crypto/ed25519"
+	"net/netip"
+	"slices"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -13,7 +14,9 @@ import (
 
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/codec"
+	"github.com/forest33/tapir/pkg/encryptor"
 	"github.com/forest33/tapir/pkg/logger"
+	"github.com/forest33/tapir/pkg/structs"
 	"github.com/forest33/tapir/pkg/util/hash"
 )
 
@@ -25,6 +28,8 @@ type ClientUseCase struct {
 	cfg                   *entity.ClientConfig
 	codec                 codec.Codec
 	encryptor             entity.Encryptor
+	serverPublicKey       ed25519.PublicKey
+	clientKey             ed25519.PrivateKey
 	merger                entity.StreamMerger
 	client                entity.NetworkClient
 	iface                 entity.InterfaceAdapter
@@ -36,6 +41,9 @@ type ClientUseCase struct {
 	connMux               sync.RWMutex
 	isConnected           atomic.Bool
 	isExit                atomic.Bool
+	reconnecting          atomic.Bool
+	reconnectState        entity.ReconnectState
+	reconnectMux          sync.RWMutex
 	sessionID             uint32
 	portSelectionStrategy entity.PortSelectionStrategy
 	compressionType       entity.CompressionType
@@ -46,10 +54,20 @@ type clientConn struct {
 	idx       int
 	conn      *entity.Connection
 	encryptor entity.Encryptor
+	rekey     *clientRekey
+}
+
+type clientRekey struct {
+	kx     *clientKeyExchange
+	phase  bool
+	sentAt time.Time
 }
 
 type clientInterfaceInfo struct {
 	handler     *entity.Interface
+	ip          entity.IfIP
+	network     *entity.PushedNetwork
+	monotonicID bool
 	connections []*entity.Connection
 }
 
@@ -60,6 +78,16 @@ func NewClientUseCase(ctx context.Context, log *logger.Logger, cfg *entity.Clien
 		return nil, err
 	}
 
+	serverPublicKey, err := parseServerPublicKey(conn.Authentication.ServerPublicKey)
+	if err != nil {
+		return nil, err
+	}
+
+	clientKey, err := parseClientKey(conn.User.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(ctx)
 
 	uc := &ClientUseCase{
@@ -69,6 +97,8 @@ func NewClientUseCase(ctx context.Context, log *logger.Logger, cfg *entity.Clien
 		cfg:                   cfg,
 		codec:                 GetCodec(log, conn.Tunnel.MTU, conn.Tunnel.Encryption, conn.Server.ObfuscateData),
 		encryptor:             GetEncryptor(conn.Authentication.Key, conn.Tunnel.Encryption),
+		serverPublicKey:       serverPublicKey,
+		clientKey:             clientKey,
 		merger:                merger,
 		client:                client,
 		iface:                 iface,
@@ -102,6 +132,10 @@ func (uc *ClientUseCase) init() error {
 
 	uc.client.SetStatisticHandler(uc.addConnectionStat)
 
+	if uc.conn.Tunnel.UseRekey() {
+		uc.rekeyLoop()
+	}
+
 	uc.log.Info().
 		Str("host", uc.conn.Server.Host).
 		Uint16("portMin", uc.conn.Server.PortMin).
@@ -118,26 +152,58 @@ func (uc *ClientUseCase) reset() {
 	uc.isConnected.Store(false)
 }
 
+// Start connects to all server ports through every uplink.
+// With several uplinks the unavailable ones are skipped, an error is returned if no path is connected.
 func (uc *ClientUseCase) Start() error {
+	uplinks := uc.conn.Server.GetUplinks()
+	if len(uplinks) == 1 {
+		return uc.startUplink(uplinks[0])
+	}
+
+	var lastErr error
+	for _, uplink := range uplinks {
+		if err := uc.startUplink(uplink); err != nil {
+			if errors.Is(err, entity.ErrUnauthorized) {
+				return err
+			}
+			uc.log.Error().Err(err).Str("uplink", uplink.GetName()).Msg("uplink is not available")
+			lastErr = err
+		}
+	}
+
+	uc.connMux.RLock()
+	length := len(uc.connections)
+	uc.connMux.RUnlock()
+
+	if length == 0 {
+		return lastErr
+	}
+
+	return nil
+}
+
+func (uc *ClientUseCase) startUplink(uplink *entity.Uplink) error {
 	for port := uc.conn.Server.PortMin; port <= uc.conn.Server.PortMax; port++ {
 		if *uc.conn.Server.UseTCP {
-			err := uc.createConnection(port, entity.ProtoTCP)
+			err := uc.createConnection(port, entity.ProtoTCP, uplink)
 			if err != nil {
 				uc.log.Error().Err(err).
 					Str("host", uc.conn.Server.Host).
 					Uint16("port", port).
 					Str("protocol", entity.ProtoTCP.String()).
+					Str("uplink", uplink.GetName()).
 					Msg("server connection error")
 				return err
 			}
 		}
 		if *uc.conn.Server.UseUDP {
-			err := uc.createConnection(port, entity.ProtoUDP)
+			err := uc.createConnection(port, entity.ProtoUDP, uplink)
 			if err != nil {
 				uc.log.Error().Err(err).
 					Str("host", uc.conn.Server.Host).
 					Uint16("port", port).
 					Str("protocol", entity.ProtoUDP.String()).
+					Str("uplink", uplink.GetName()).
 					Msg("server connection error")
 				return err
 			}
@@ -174,6 +240,8 @@ func (uc *ClientUseCase) command(msg *entity.Message, conn *entity.Connection) (
 		err = uc.commandData(msg)
 	case entity.MessageTypeReset:
 		uc.commandReset()
+	case entity.MessageTypeHandshake:
+		uc.responseRekey(msg, conn)
 	default:
 		err = entity.ErrUnknownCommand
 	}
@@ -182,47 +250,91 @@ func (uc *ClientUseCase) command(msg *entity.Message, conn *entity.Connection) (
 }
 
 func (uc *ClientUseCase) commandAuthentication(cc *clientConn) error {
+	payload := &entity.MessageAuthenticationRequest{
+		ClientID:         uc.cfg.System.ClientID,
+		Name:             uc.conn.User.Name,
+		Password:         uc.conn.User.Password,
+		CompressionType:  uc.compressionType,
+		CompressionLevel: uc.compressionLevel,
+		Version:          entity.ProtocolVersionCurrent,
+		Extensions:       uc.getExtensions(cc.conn.Uplink),
+	}
+	if uc.clientKey != nil {
+		payload.PublicKey = uc.clientKey.Public().(ed25519.PublicKey)
+	}
+
 	req := &entity.Message{
-		Type:      entity.MessageTypeAuthentication,
-		SessionID: uc.sessionID,
-		Payload: &entity.MessageAuthenticationRequest{
-			ClientID:         uc.cfg.System.ClientID,
-			Name:             uc.conn.User.Name,
-			Password:         uc.conn.User.Password,
-			CompressionType:  uc.compressionType,
-			CompressionLevel: uc.compressionLevel,
-		},
+		Type:        entity.MessageTypeAuthentication,
+		SessionID:   uc.sessionID,
+		MonotonicID: true,
+		Payload:     payload,
 	}
 
-	msg, conn, err := uc.client.SendSync(req, cc.conn, time.Duration(uc.conn.Server.AuthenticationTimeout)*time.Second)
+	timeout := time.Duration(uc.conn.Server.AuthenticationTimeout) * time.Second
+
+	msg, conn, err := uc.client.SendSync(req, cc.conn, timeout)
 	if err != nil {
 		return errors.Wrap(err, "failed to send message")
 	}
 
-	return uc.responseAuthentication(msg, conn)
+	resp, err := uc.decodeAuthentication(msg)
+	if err != nil {
+		return err
+	}
+
+	if resp.Challenge != nil {
+		if uc.clientKey == nil {
+			return entity.ErrUnauthorized
+		}
+		payload.Signature = signAuthentication(uc.clientKey, payload, resp.Challenge)
+
+		msg, conn, err = uc.client.SendSync(req, cc.conn, timeout)
+		if err != nil {
+			return errors.Wrap(err, "failed to send message")
+		}
+
+		if resp, err = uc.decodeAuthentication(msg); err != nil {
+			return err
+		}
+		if resp.Challenge != nil {
+			return entity.ErrUnauthorized
+		}
+	}
+
+	return uc.responseAuthentication(resp, msg.MonotonicID, conn)
 }
 
-func (uc *ClientUseCase) responseAuthentication(msg *entity.Message, conn *entity.Connection) error {
+func (uc *ClientUseCase) decodeAuthentication(msg *entity.Message) (*entity.MessageAuthenticationResponse, error) {
 	var err error
 	msg.Payload, err = uc.encryptor.Decrypt(msg.Payload)
 	if err != nil {
 		uc.log.Error().Err(err).Msg("failed to decrypt payload")
-		return err
+		return nil, err
 	}
 
 	if err := uc.codec.UnmarshalPayload(msg); err != nil {
 		uc.log.Error().Err(err).Msg("failed to unmarshal payload")
-		return err
+		return nil, err
 	}
 
 	if msg.Error != 0 {
-		return msg.Error.Error()
+		return nil, msg.Error.Error()
 	}
 
 	resp := &entity.MessageAuthenticationResponse{}
 	if err := mapstructure.Decode(msg.Payload, &resp); err != nil {
+		return nil, err
+	}
+
+	return resp, nil
+}
+
+func (uc *ClientUseCase) responseAuthentication(resp *entity.MessageAuthenticationResponse, monotonicID bool, conn *entity.Connection) error {
+	if err := uc.checkExtensions(resp); err != nil {
+		uc.log.Error().Err(err).Uint8("version", uint8(resp.Version.Get())).Msg("failed to negotiate protocol")
 		return err
 	}
+	uc.checkSubnets(resp)
 
 	if uc.conn.Server.UseStreamMerger() {
 		if err := uc.merger.CreateStream(resp.SessionID); err != nil {
@@ -231,7 +343,13 @@ func (uc *ClientUseCase) responseAuthentication(msg *entity.Message, conn *entit
 		}
 	}
 
-	if err := uc.createInterface(resp.LocalIP, resp.RemoteIP, conn); err != nil {
+	ip := entity.IfIP{
+		ServerLocal:   resp.LocalIP,
+		ServerRemote:  resp.RemoteIP,
+		ServerLocal6:  resp.LocalIP6,
+		ServerRemote6: resp.RemoteIP6,
+	}
+	if err := uc.createInterface(ip, uc.getPushedNetwork(resp), monotonicID, conn); err != nil {
 		uc.log.Error().Err(err).Msg("failed to create network interface")
 		return err
 	}
@@ -246,18 +364,103 @@ func (uc *ClientUseCase) responseAuthentication(msg *entity.Message, conn *entit
 	return nil
 }
 
+// getExtensions returns the extensions of the authentication request
+func (uc *ClientUseCase) getExtensions(uplink *entity.Uplink) entity.Extensions {
+	ext := entity.Extensions{
+		entity.ExtensionEncryption:  []byte(uc.conn.Tunnel.Encryption),
+		entity.ExtensionCompression: {uc.compressionType.Byte(), uc.compressionLevel.Byte()},
+	}
+	ext.SetUint16(entity.ExtensionMTU, uint16(uc.conn.Tunnel.MTU))
+
+	var features entity.Feature
+	if entity.GetHeaderProtectionMode(uc.conn.Server.HeaderProtection) != entity.HeaderProtectionLegacy {
+		features |= entity.FeatureHeaderProtection
+	}
+	if uc.conn.Tunnel.KeyExchange.IsHybrid() {
+		features |= entity.FeatureHybridKeyExchange
+	}
+	if uc.clientKey != nil {
+		features |= entity.FeaturePublicKeyAuthentication
+	}
+	ext.SetUint32(entity.ExtensionFeatures, uint32(features))
+
+	// the server replaces the previous path of the uplink on the same port
+	if uplink != nil {
+		ext.SetUint8(entity.ExtensionUplink, uplink.ID)
+	}
+	if len(uc.conn.Tunnel.Subnets) != 0 {
+		ext.SetStrings(entity.ExtensionSubnets, uc.conn.Tunnel.Subnets)
+	}
+	if uc.conn.Tunnel.IsTAP() {
+		ext[entity.ExtensionDeviceType] = []byte(entity.DeviceTypeTAP)
+	}
+
+	return ext
+}
+
+// checkExtensions checks the protocol version and the extensions of the authentication response,
+// servers without versions are accepted unless the minimum protocol version is set.
+func (uc *ClientUseCase) checkExtensions(resp *entity.MessageAuthenticationResponse) error {
+	if resp.Version.Get() > entity.ProtocolVersionCurrent || resp.Version.Get() < uc.conn.Server.GetMinProtocolVersion() {
+		return entity.ErrUnsupportedVersion
+	}
+	if (resp.Extensions.DeviceType() == entity.DeviceTypeTAP) != uc.conn.Tunnel.IsTAP() {
+		return entity.ErrWrongDeviceType
+	}
+	if resp.Version == 0 {
+		return nil
+	}
+
+	if enc, ok := resp.Extensions[entity.ExtensionEncryption]; ok && entity.EncryptorMethod(enc) != uc.conn.Tunnel.Encryption {
+		return entity.ErrUnknownEncryptionMethod
+	}
+	if uc.conn.Tunnel.KeyExchange.IsHybrid() && !resp.Extensions.Features().Has(entity.FeatureHybridKeyExchange) {
+		return entity.ErrKeyExchangeNotSupported
+	}
+
+	return nil
+}
+
+// checkSubnets warns about the subnets of the client that are not routed by the server
+func (uc *ClientUseCase) checkSubnets(resp *entity.MessageAuthenticationResponse) {
+	if len(uc.conn.Tunnel.Subnets) == 0 {
+		return
+	}
+
+	accepted, _ := resp.Extensions.Prefixes(entity.ExtensionSubnets)
+	for _, s := range uc.conn.Tunnel.Subnets {
+		subnet, err := netip.ParsePrefix(s)
+		if err != nil || !slices.Contains(accepted, subnet.Masked()) {
+			uc.log.Warn().Str("subnet", s).Msg("subnet is not routed by the server")
+		}
+	}
+}
+
+// getPushedNetwork returns the network configuration pushed by the server,
+// the MTU never exceeds the MTU of the connection.
+func (uc *ClientUseCase) getPushedNetwork(resp *entity.MessageAuthenticationResponse) *entity.PushedNetwork {
+	if resp.Version == 0 {
+		return nil
+	}
+
+	network := resp.Extensions.PushedNetwork()
+	if network.MTU == 0 || network.MTU > uc.conn.Tunnel.MTU {
+		network.MTU = uc.conn.Tunnel.MTU
+	}
+
+	return network
+}
+
 func (uc *ClientUseCase) commandHandshake(cc *clientConn) error {
-	privateKey, err := GetECDHCurve().GenerateKey(rand.Reader)
+	kx, err := newClientKeyExchange(uc.conn.Tunnel.KeyExchange)
 	if err != nil {
-		return errors.Wrap(err, "failed to generate ECDSA private key")
+		return err
 	}
 
 	req := &entity.Message{
 		SessionID: uc.sessionID,
 		Type:      entity.MessageTypeHandshake,
-		Payload: &entity.MessageHandshake{
-			Key: privateKey.PublicKey().Bytes(),
-		},
+		Payload:   kx.request(),
 	}
 
 	msg, conn, err := uc.client.SendSync(req, cc.conn, time.Duration(uc.conn.Server.HandshakeTimeout)*time.Second)
@@ -265,10 +468,10 @@ func (uc *ClientUseCase) commandHandshake(cc *clientConn) error {
 		return errors.Wrap(err, "failed to send message")
 	}
 
-	return uc.responseHandshake(msg, conn, privateKey)
+	return uc.responseHandshake(msg, conn, kx)
 }
 
-func (uc *ClientUseCase) responseHandshake(msg *entity.Message, conn *entity.Connection, privateKey *ecdh.PrivateKey) error {
+func (uc *ClientUseCase) responseHandshake(msg *entity.Message, conn *entity.Connection, kx *clientKeyExchange) error {
 	var err error
 	msg.Payload, err = uc.encryptor.Decrypt(msg.Payload)
 	if err != nil {
@@ -290,19 +493,19 @@ func (uc *ClientUseCase) responseHandshake(msg *entity.Message, conn *entity.Con
 		return err
 	}
 
-	publicKey, err := GetECDHCurve().NewPublicKey(resp.Key)
+	shared, err := kx.sharedKey(resp)
 	if err != nil {
-		uc.log.Error().Err(err).Msg("failed to check public key")
+		uc.log.Error().Err(err).Msg("failed to create shared key")
 		return err
 	}
 
-	shared, err := privateKey.ECDH(publicKey)
+	enc, err := uc.getHandshakeEncryptor(kx, resp, shared)
 	if err != nil {
-		uc.log.Error().Err(err).Msg("failed to create shared key")
+		uc.log.Error().Err(err).Msg("failed to derive session keys")
 		return err
 	}
 
-	uc.setConnectionEncryptor(conn, GetEncryptor(string(shared), uc.conn.Tunnel.Encryption))
+	uc.setConnectionEncryptor(conn, encryptor.NewKeyPhase(enc, false, getRekeyGracePeriod(uc.conn.Tunnel)))
 
 	uc.isConnected.Store(true)
 
@@ -310,12 +513,171 @@ func (uc *ClientUseCase) responseHandshake(msg *entity.Message, conn *entity.Con
 		Uint32("session_id", uc.sessionID).
 		Str("addr", conn.Addr.String()).
 		Str("proto", conn.Protocol().String()).
+		Uint8("version", uint8(resp.Version.Get())).
+		Str("key_exchange", uc.conn.Tunnel.KeyExchange.String()).
 		Str("key", hash.MD5(shared)).
 		Msg("handshake successful")
 
 	return nil
 }
 
+func (uc *ClientUseCase) getHandshakeEncryptor(kx *clientKeyExchange, resp *entity.MessageHandshake, shared []byte) (entity.Encryptor, error) {
+	if resp.Version.Get() > entity.ProtocolVersionCurrent {
+		return nil, entity.ErrWrongMessagePayload
+	}
+
+	hk := &handshakeKeys{
+		version:   resp.Version,
+		shared:    shared,
+		clientKey: kx.privateKey.PublicKey().Bytes(),
+		serverKey: resp.Key,
+		clientKEM: kx.encapsulationKey(),
+		serverKEM: structs.If(kx.kemKey != nil, resp.KEM, nil),
+		sessionID: uc.sessionID,
+		clientID:  uc.cfg.System.ClientID,
+	}
+
+	if err := verifyHandshake(uc.serverPublicKey, hk, resp.Signature); err != nil {
+		return nil, err
+	}
+
+	return getHandshakeEncryptor(hk, uc.conn.Tunnel.Encryption, false)
+}
+
+func (uc *ClientUseCase) rekeyLoop() {
+	ticker := time.NewTicker(time.Second)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if uc.isConnected.Load() {
+					uc.checkRekey()
+				}
+			case <-uc.ctx.Done():
+				return
+			}
+		}
+	}()
+}
+
+func (uc *ClientUseCase) checkRekey() {
+	var (
+		interval = time.Duration(uc.conn.Tunnel.RekeyInterval) * time.Second
+		timeout  = time.Duration(uc.conn.Server.HandshakeTimeout) * time.Second
+	)
+
+	uc.connMux.RLock()
+	list := make([]*clientConn, 0, len(uc.connections))
+	for _, cc := range uc.connections {
+		kp, ok := cc.encryptor.(*encryptor.KeyPhase)
+		if !ok || (cc.rekey != nil && time.Since(cc.rekey.sentAt) < timeout) {
+			continue
+		}
+		if (interval > 0 && kp.Age() >= interval) || (uc.conn.Tunnel.RekeyBytes > 0 && kp.Bytes() >= uc.conn.Tunnel.RekeyBytes) {
+			list = append(list, cc)
+		}
+	}
+	uc.connMux.RUnlock()
+
+	for _, cc := range list {
+		if err := uc.commandRekey(cc); err != nil {
+			uc.log.Error().Err(err).
+				Uint32("session_id", uc.sessionID).
+				Str("addr", cc.conn.Addr.String()).
+				Msg("failed to start rekey")
+		}
+	}
+}
+
+func (uc *ClientUseCase) commandRekey(cc *clientConn) error {
+	kx, err := newClientKeyExchange(uc.conn.Tunnel.KeyExchange)
+	if err != nil {
+		return err
+	}
+
+	uc.connMux.Lock()
+	kp, ok := cc.encryptor.(*encryptor.KeyPhase)
+	if !ok {
+		uc.connMux.Unlock()
+		return nil
+	}
+	rekey := &clientRekey{
+		kx:     kx,
+		phase:  !kp.KeyPhase(),
+		sentAt: time.Now(),
+	}
+	cc.rekey = rekey
+	uc.connMux.Unlock()
+
+	payload := kx.request()
+	if err := sealRekey(kp, uc.sessionID, rekey.phase, payload); err != nil {
+		return err
+	}
+
+	req := &entity.Message{
+		SessionID: uc.sessionID,
+		Type:      entity.MessageTypeHandshake,
+		KeyPhase:  rekey.phase,
+		Payload:   payload,
+	}
+
+	return uc.client.SendAsync(req, cc.conn)
+}
+
+// responseRekey completes the rekey started by commandRekey.
+// Errors are only logged, the connection stays on the current key and the rekey is retried later.
+func (uc *ClientUseCase) responseRekey(msg *entity.Message, conn *entity.Connection) {
+	uc.connMux.Lock()
+	defer uc.connMux.Unlock()
+
+	cc, ok := uc.connectionsMap[conn.Key()]
+	if !ok || cc.rekey == nil || cc.rekey.phase != msg.KeyPhase {
+		return
+	}
+
+	rekey := cc.rekey
+	cc.rekey = nil
+
+	if msg.Error != 0 {
+		uc.log.Error().Err(msg.Error.Error()).Uint32("session_id", uc.sessionID).Msg("rekey rejected by server")
+		return
+	}
+
+	resp := &entity.MessageHandshake{}
+	if err := mapstructure.Decode(msg.Payload, &resp); err != nil {
+		uc.log.Error().Err(err).Uint32("session_id", uc.sessionID).Msg("failed to decode rekey response")
+		return
+	}
+
+	shared, err := rekey.kx.sharedKey(resp)
+	if err != nil {
+		uc.log.Error().Err(err).Uint32("session_id", uc.sessionID).Msg("failed to create shared key")
+		return
+	}
+
+	enc, err := uc.getHandshakeEncryptor(rekey.kx, resp, shared)
+	if err != nil {
+		uc.log.Error().Err(err).Uint32("session_id", uc.sessionID).Msg("failed to derive session keys")
+		return
+	}
+
+	kp, ok := cc.encryptor.(*encryptor.KeyPhase)
+	if !ok {
+		return
+	}
+	cc.encryptor = kp.Next(enc, rekey.phase)
+
+	uc.log.Info().
+		Uint32("session_id", uc.sessionID).
+		Str("addr", conn.Addr.String()).
+		Str("proto", conn.Protocol().String()).
+		Bool("key_phase", rekey.phase).
+		Str("key", hash.MD5(shared)).
+		Msg("rekey successful")
+}
+
 func (uc *ClientUseCase) commandData(msg *entity.Message) error {
 	if !uc.isConnected.Load() {
 		return nil
@@ -343,9 +705,7 @@ func (uc *ClientUseCase) commandReset() {
 	}
 
 	uc.log.Info().Msg("received reset command, reconnecting...")
-	uc.reset()
-	uc.closeAndRemoveAllConnections()
-	uc.closeInterface()
+	uc.startReconnect(false)
 }
 
 func (uc *ClientUseCase) sendReset() {
@@ -370,6 +730,8 @@ func (uc *ClientUseCase) sendReset() {
 }
 
 func (uc *ClientUseCase) Stop() {
+	defer uc.disableKillSwitch()
+
 	if !uc.isConnected.Load() {
 		return
 	}
@@ -380,10 +742,14 @@ func (uc *ClientUseCase) Stop() {
 }
 
 func (uc *ClientUseCase) Exit() {
+	defer uc.disableKillSwitch()
+
+	uc.isExit.Store(true)
 	if !uc.isConnected.Load() {
+		uc.cancel()
+		uc.closeInterface()
 		return
 	}
-	uc.isExit.Store(true)
 	uc.sendReset()
 	uc.cancel()
 	uc.merger.DeleteStream(uc.sessionID)
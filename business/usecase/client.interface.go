@@ -2,12 +2,22 @@ package usecase
 
 import (
 	"context"
-	"net"
+
+	"github.com/pkg/errors"
 
 	"github.com/forest33/tapir/business/entity"
 )
 
-func (uc *ClientUseCase) createInterface(localIP, remoteIP net.IP, conn *entity.Connection) error {
+// createInterface creates the network interface on the first authentication of the session,
+// the interface is kept while the client is reconnecting unless the server assigns different addresses
+// or the numbering of the messages negotiated with the server changes
+func (uc *ClientUseCase) createInterface(ip entity.IfIP, network *entity.PushedNetwork, monotonicID bool, conn *entity.Connection) error {
+	if uc.interfaceConn != nil && (!uc.interfaceConn.ip.Equal(ip) || !uc.interfaceConn.network.Equal(network) ||
+		uc.interfaceConn.monotonicID != monotonicID) {
+		uc.log.Info().Uint32("session_id", conn.SessionID).Msg("network configuration changed, recreating network interface")
+		uc.closeInterfaceHandler()
+	}
+
 	if uc.interfaceConn == nil {
 		ctx, cancel := context.WithCancel(uc.ctx)
 		ch := make(chan *entity.Message, uc.conn.Tunnel.NumberOfHandlerThreads*10)
@@ -16,13 +26,12 @@ func (uc *ClientUseCase) createInterface(localIP, remoteIP net.IP, conn *entity.
 		}
 
 		ifc, err := uc.iface.Create(&entity.Interface{
-			Type: entity.DeviceTypeTUN,
-			IP: entity.IfIP{
-				ServerLocal:  localIP,
-				ServerRemote: remoteIP,
-			},
-			Receiver: ch,
-			Cancel:   cancel,
+			Type:        uc.conn.Tunnel.DeviceType,
+			IP:          ip,
+			Network:     network,
+			Receiver:    ch,
+			Cancel:      cancel,
+			MonotonicID: monotonicID,
 		})
 		if err != nil {
 			return err
@@ -30,19 +39,53 @@ func (uc *ClientUseCase) createInterface(localIP, remoteIP net.IP, conn *entity.
 
 		uc.interfaceConn = &clientInterfaceInfo{
 			handler:     ifc,
+			ip:          ip,
+			network:     network,
+			monotonicID: monotonicID,
 			connections: make([]*entity.Connection, 0, uc.conn.Server.MaxPorts()),
 		}
 
+		mtu := uc.conn.Tunnel.MTU
+		if network != nil && network.MTU != 0 {
+			mtu = network.MTU
+		}
+
 		name, _ := ifc.Name()
 		uc.log.Info().
 			Uint32("session_id", conn.SessionID).
 			Str("device", name).
-			Int("MTU", uc.conn.Tunnel.MTU).
+			Int("MTU", mtu).
 			Str("server_local_ip", ifc.IP.ServerLocal.String()).
 			Str("server_remote_ip", ifc.IP.ServerRemote.String()).
 			Str("client_local_ip", ifc.IP.ClientLocal.String()).
-			Str("server_remote_ip", ifc.IP.ClientRemote.String()).
+			Str("client_remote_ip", ifc.IP.ClientRemote.String()).
 			Msg("network interface created")
+
+		if ifc.IP.HasIPv6() {
+			uc.log.Info().
+				Uint32("session_id", conn.SessionID).
+				Str("device", name).
+				Str("server_local_ip6", ifc.IP.ServerLocal6.String()).
+				Str("server_remote_ip6", ifc.IP.ServerRemote6.String()).
+				Msg("network interface IPv6 addresses assigned")
+		}
+
+		if network != nil && (len(network.DNS) != 0 || len(network.SearchDomains) != 0 || len(network.Routes) != 0) {
+			uc.log.Info().
+				Uint32("session_id", conn.SessionID).
+				Str("device", name).
+				Int("dns_servers", len(network.DNS)).
+				Strs("search_domains", network.SearchDomains).
+				Int("routes", len(network.Routes)).
+				Msg("network configuration pushed by the server")
+		}
+
+		if uc.conn.Tunnel.UseKillSwitch() {
+			if err := uc.iface.EnableKillSwitch(ifc); err != nil {
+				return errors.Wrap(err, "failed to enable kill switch")
+			}
+			uc.log.Info().Str("device", name).Msg("kill switch enabled")
+		}
 	}
 
 	uc.interfaceConn.connections = append(uc.interfaceConn.connections, conn)
@@ -50,10 +93,25 @@ func (uc *ClientUseCase) createInterface(localIP, remoteIP net.IP, conn *entity.
 	return nil
 }
 
+// disableKillSwitch removes the kill switch rules on explicit disconnect,
+// the rules stay in place while the client is reconnecting and after the reconnect is stopped
+func (uc *ClientUseCase) disableKillSwitch() {
+	if !uc.conn.Tunnel.UseKillSwitch() {
+		return
+	}
+	if err := uc.iface.DisableKillSwitch(); err != nil {
+		uc.log.Error().Err(err).Msg("failed to disable kill switch")
+	}
+}
+
 func (uc *ClientUseCase) closeInterface() {
 	uc.connMux.Lock()
 	defer uc.connMux.Unlock()
 
+	uc.closeInterfaceHandler()
+}
+
+func (uc *ClientUseCase) closeInterfaceHandler() {
 	if uc.interfaceConn != nil {
 		if err := uc.interfaceConn.handler.Close(); err != nil {
 			uc.log.Error().Err(err).Msg("failed to close interface")
@@ -105,6 +163,7 @@ func (uc *ClientUseCase) interfaceReceiver(msg *entity.Message) error {
 	uc.addConnectionStat(msg.SessionID, &entity.Statistic{
 		OutgoingBytes:  uint64(msg.PayloadLength),
 		OutgoingFrames: 1,
+		Uplink:         cc.conn.Uplink.GetName(),
 	})
 
 	return nil
@@ -0,0 +1,120 @@
+package usecase
+
+import (
+	"errors"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// startReconnect closes the connections after the connection to the server is lost and reconnects
+// with exponential backoff. The interface, its routes and the kill switch are kept while the client
+// is reconnecting, the packets read from the interface are dropped until the session is restored.
+// The session is resumed if keepSession is true, otherwise a new session is created.
+func (uc *ClientUseCase) startReconnect(keepSession bool) {
+	if uc.isExit.Load() || !uc.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+
+	// the stream is not deleted in the caller goroutine,
+	// it can be the goroutine of the stream itself
+	go func() {
+		defer func() {
+			uc.setReconnectState(entity.ReconnectState{})
+			uc.reconnecting.Store(false)
+		}()
+
+		if !uc.conn.Server.UseReconnect() {
+			uc.log.Info().Msg("connection lost, reconnect is disabled")
+			uc.teardown()
+			return
+		}
+
+		uc.suspend(keepSession)
+		uc.reconnectLoop()
+	}()
+}
+
+func (uc *ClientUseCase) reconnectLoop() {
+	maxAttempts := uc.conn.Server.ReconnectMaxAttempts
+
+	for attempt := 1; maxAttempts == 0 || attempt <= maxAttempts; attempt++ {
+		delay := uc.conn.Server.GetReconnectDelay(attempt)
+		uc.setReconnectState(entity.ReconnectState{
+			IsReconnecting: true,
+			Attempt:        attempt,
+			NextAttemptTs:  time.Now().Add(delay).Unix(),
+		})
+
+		uc.log.Info().
+			Int("attempt", attempt).
+			Dur("delay", delay).
+			Msg("reconnecting...")
+
+		select {
+		case <-uc.ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		// the client can exit while the connections are created,
+		// the kill switch enabled by this attempt is removed after the exit
+		err := uc.Start()
+		if uc.ctx.Err() != nil {
+			uc.teardown()
+			uc.disableKillSwitch()
+			return
+		}
+		if err == nil {
+			uc.log.Info().Int("attempt", attempt).Uint32("session_id", uc.sessionID).Msg("reconnected")
+			return
+		}
+
+		uc.suspend(true)
+
+		if errors.Is(err, entity.ErrUnauthorized) {
+			uc.log.Error().Err(err).Msg("reconnect stopped")
+			uc.teardown()
+			return
+		}
+	}
+
+	uc.log.Error().Int("attempts", maxAttempts).Msg("maximum number of reconnect attempts exceeded")
+	uc.teardown()
+}
+
+// suspend removes the stream and the connections of the session, the interface is kept
+func (uc *ClientUseCase) suspend(keepSession bool) {
+	uc.merger.DeleteStream(uc.sessionID)
+	uc.isConnected.Store(false)
+	if !keepSession {
+		uc.sessionID = 0
+	}
+	uc.closeAndRemoveAllConnections()
+
+	uc.connMux.Lock()
+	if uc.interfaceConn != nil {
+		uc.interfaceConn.connections = uc.interfaceConn.connections[:0]
+	}
+	uc.connMux.Unlock()
+}
+
+// teardown removes the session and the interface after the reconnect is stopped,
+// the kill switch rules are kept until the user disconnects explicitly
+func (uc *ClientUseCase) teardown() {
+	uc.suspend(false)
+	uc.closeInterface()
+}
+
+func (uc *ClientUseCase) setReconnectState(state entity.ReconnectState) {
+	uc.reconnectMux.Lock()
+	uc.reconnectState = state
+	uc.reconnectMux.Unlock()
+}
+
+// GetReconnectState returns the state of the automatic reconnect
+func (uc *ClientUseCase) GetReconnectState() entity.ReconnectState {
+	uc.reconnectMux.RLock()
+	defer uc.reconnectMux.RUnlock()
+	return uc.reconnectState
+}
@@ -112,7 +112,7 @@ func (uc *ConnectionManagerUseCase) GetConnections() ([]*entity.ConnectionInfo,
 	conns := make([]*entity.ConnectionInfo, len(uc.cfg.Connections))
 	for id := range uc.cfg.Connections {
 		if cc, ok := uc.connections[id]; ok {
-			conns[id] = cc
+			conns[id] = getConnectionInfo(cc)
 			continue
 		}
 		conns[id] = &entity.ConnectionInfo{
@@ -123,6 +123,17 @@ func (uc *ConnectionManagerUseCase) GetConnections() ([]*entity.ConnectionInfo,
 	return conns, nil
 }
 
+// getConnectionInfo returns a copy of the connection info with the reconnect state of the connection
+func getConnectionInfo(cc *entity.ConnectionInfo) *entity.ConnectionInfo {
+	info := *cc
+	rs := cc.Handler.GetReconnectState()
+	info.IsConnected = cc.IsConnected && !rs.IsReconnecting
+	info.IsReconnecting = rs.IsReconnecting
+	info.ReconnectAttempt = rs.Attempt
+	info.NextReconnectTs = rs.NextAttemptTs
+	return &info
+}
+
 func (uc *ConnectionManagerUseCase) UpdateConfig(jsonData []byte) error {
 	var err error
 	uc.cfg, err = uc.cfg.Unmarshal(jsonData)
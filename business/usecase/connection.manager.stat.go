@@ -16,6 +16,8 @@ func (uc *ConnectionManagerUseCase) createStatisticHandler(connID int) entity.St
 				OutgoingBytes:  stat.OutgoingBytes,
 				IncomingFrames: stat.IncomingFrames,
 				OutgoingFrames: stat.OutgoingFrames,
+				ReplayedFrames: stat.ReplayedFrames,
+				Uplink:         stat.Uplink,
 			},
 		}
 	}
@@ -40,10 +42,7 @@ func (uc *ConnectionManagerUseCase) sessionStat() {
 				if _, ok := stat[s.ID]; !ok {
 					stat[s.ID] = &entity.Statistic{}
 				}
-				stat[s.ID].IncomingBytes += s.stat.IncomingBytes
-				stat[s.ID].OutgoingBytes += s.stat.OutgoingBytes
-				stat[s.ID].IncomingFrames += s.stat.IncomingFrames
-				stat[s.ID].OutgoingFrames += s.stat.OutgoingFrames
+				stat[s.ID].Add(s.stat)
 				updated.Store(true)
 			case <-ticker.C:
 				if !updated.Load() {
@@ -56,23 +55,14 @@ func (uc *ConnectionManagerUseCase) sessionStat() {
 				}
 				uc.statMux.Lock()
 				for id := range uc.statistic {
-					uc.statistic[id].IncomingRateBytes = 0
-					uc.statistic[id].OutgoingRateBytes = 0
-					uc.statistic[id].IncomingRateFrames = 0
-					uc.statistic[id].OutgoingRateFrames = 0
+					uc.statistic[id].SetRate(&entity.Statistic{}, interval)
 				}
 				for id := range stat {
 					if _, ok := uc.statistic[id]; !ok {
 						uc.statistic[id] = &entity.Statistic{}
 					}
-					uc.statistic[id].IncomingBytes += stat[id].IncomingBytes
-					uc.statistic[id].OutgoingBytes += stat[id].OutgoingBytes
-					uc.statistic[id].IncomingFrames += stat[id].IncomingFrames
-					uc.statistic[id].OutgoingFrames += stat[id].OutgoingFrames
-					uc.statistic[id].IncomingRateBytes = float64(stat[id].IncomingBytes) / interval
-					uc.statistic[id].OutgoingRateBytes = float64(stat[id].OutgoingBytes) / interval
-					uc.statistic[id].IncomingRateFrames = float64(stat[id].IncomingFrames) / interval
-					uc.statistic[id].OutgoingRateFrames = float64(stat[id].OutgoingFrames) / interval
+					uc.statistic[id].Add(stat[id])
+					uc.statistic[id].SetRate(stat[id], interval)
 				}
 				if uc.outStatCh != nil {
 					uc.outStatCh <- uc.statistic
@@ -1,14 +1,43 @@
 package usecase
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+
+	"golang.org/x/crypto/hkdf"
+
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/encryptor"
 )
 
+const (
+	sessionKeySize = 32
+
+	hkdfInfoPrefix               = "tapir"
+	hkdfInfoClientToServer       = "client to server"
+	hkdfInfoServerToClient       = "server to client"
+	hkdfInfoClientToServerHeader = "client to server header"
+	hkdfInfoServerToClientHeader = "server to client header"
+)
+
 var Encryptors = map[entity.EncryptorMethod]func(string) entity.Encryptor{
 	entity.EncryptionNone:      encryptor.NewEmpty,
 	entity.EncryptionAES256ECB: encryptor.NewAESECB,
 	entity.EncryptionAES256GCM: encryptor.NewAESGCM,
+	entity.EncryptionChaCha20:  encryptor.NewChaCha20Poly1305,
+	entity.EncryptionXChaCha20: encryptor.NewXChaCha20Poly1305,
+}
+
+type handshakeKeys struct {
+	version   entity.ProtocolVersion
+	shared    []byte
+	clientKey []byte
+	serverKey []byte
+	clientKEM []byte
+	serverKEM []byte
+	sessionID uint32
+	clientID  string
 }
 
 func GetEncryptor(key string, method entity.EncryptorMethod) entity.Encryptor {
@@ -17,3 +46,74 @@ func GetEncryptor(key string, method entity.EncryptorMethod) entity.Encryptor {
 	}
 	panic(entity.ErrUnknownEncryptionMethod.Error())
 }
+
+// GetSessionKeys derives the per-direction data and header protection keys from the shared key of the handshake.
+// The public keys of both peers are used as the salt, the session and client IDs are bound to the keys.
+func GetSessionKeys(shared, clientKey, serverKey []byte, sessionID uint32, clientID string, method entity.EncryptorMethod) (*entity.SessionKeys, error) {
+	size := method.KeySize()
+	if size == 0 {
+		size = sessionKeySize
+	}
+
+	salt := make([]byte, 0, len(clientKey)+len(serverKey))
+	salt = append(salt, clientKey...)
+	salt = append(salt, serverKey...)
+
+	derive := func(label string, size int) ([]byte, error) {
+		info := make([]byte, 0, len(hkdfInfoPrefix)+len(label)+len(clientID)+6)
+		info = append(info, hkdfInfoPrefix...)
+		info = append(info, byte(entity.ProtocolVersion2))
+		info = append(info, label...)
+		info = binary.BigEndian.AppendUint32(info, sessionID)
+		info = append(info, clientID...)
+
+		key := make([]byte, size)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, info), key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+
+	var (
+		keys = &entity.SessionKeys{}
+		err  error
+	)
+	if keys.ClientToServer, err = derive(hkdfInfoClientToServer, size); err != nil {
+		return nil, err
+	}
+	if keys.ServerToClient, err = derive(hkdfInfoServerToClient, size); err != nil {
+		return nil, err
+	}
+	if keys.ClientToServerHeader, err = derive(hkdfInfoClientToServerHeader, sessionKeySize); err != nil {
+		return nil, err
+	}
+	if keys.ServerToClientHeader, err = derive(hkdfInfoServerToClientHeader, sessionKeySize); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// getHandshakeEncryptor returns the encryptor of the connection for the negotiated protocol version.
+// Since ProtocolVersion2 it holds the header protection keys of the session.
+func getHandshakeEncryptor(hk *handshakeKeys, method entity.EncryptorMethod, isServer bool) (entity.Encryptor, error) {
+	if hk.version.Get() < entity.ProtocolVersion2 {
+		return GetEncryptor(string(hk.shared), method), nil
+	}
+
+	keys, err := GetSessionKeys(hk.shared, hk.clientKey, hk.serverKey, hk.sessionID, hk.clientID, method)
+	if err != nil {
+		return nil, err
+	}
+
+	c2s, s2c := GetEncryptor(string(keys.ClientToServer), method), GetEncryptor(string(keys.ServerToClient), method)
+	if isServer {
+		enc := encryptor.NewDuplex(s2c, c2s)
+		enc.SetHeaderKeys(keys.ServerToClientHeader, keys.ClientToServerHeader)
+		return enc, nil
+	}
+
+	enc := encryptor.NewDuplex(c2s, s2c)
+	enc.SetHeaderKeys(keys.ClientToServerHeader, keys.ServerToClientHeader)
+	return enc, nil
+}
@@ -0,0 +1,112 @@
+package usecase
+
+import (
+	"crypto/ecdh"
+	"crypto/mlkem"
+	"crypto/rand"
+
+	"github.com/pkg/errors"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// clientKeyExchange holds the ephemeral private keys of the client side of the handshake
+type clientKeyExchange struct {
+	privateKey *ecdh.PrivateKey
+	kemKey     *mlkem.DecapsulationKey768
+}
+
+func newClientKeyExchange(method entity.KeyExchangeMethod) (*clientKeyExchange, error) {
+	privateKey, err := GetECDHCurve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to generate ECDSA private key")
+	}
+
+	kx := &clientKeyExchange{privateKey: privateKey}
+	if method.IsHybrid() {
+		if kx.kemKey, err = mlkem.GenerateKey768(); err != nil {
+			return nil, errors.Wrap(err, "failed to generate ML-KEM decapsulation key")
+		}
+	}
+
+	return kx, nil
+}
+
+// request returns the handshake request with the public keys of the client
+func (kx *clientKeyExchange) request() *entity.MessageHandshake {
+	return &entity.MessageHandshake{
+		Key:     kx.privateKey.PublicKey().Bytes(),
+		Version: entity.ProtocolVersionCurrent,
+		KEM:     kx.encapsulationKey(),
+	}
+}
+
+func (kx *clientKeyExchange) encapsulationKey() []byte {
+	if kx.kemKey == nil {
+		return nil
+	}
+	return kx.kemKey.EncapsulationKey().Bytes()
+}
+
+// sharedKey returns the shared key of the handshake response.
+// A server that answers the hybrid request without the ML-KEM ciphertext does not support it,
+// the handshake fails instead of falling back to X25519.
+func (kx *clientKeyExchange) sharedKey(resp *entity.MessageHandshake) ([]byte, error) {
+	shared, err := getSharedKey(kx.privateKey, resp.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	if kx.kemKey == nil {
+		return shared, nil
+	}
+	if len(resp.KEM) == 0 || resp.Version.Get() < entity.ProtocolVersion2 {
+		return nil, entity.ErrKeyExchangeNotSupported
+	}
+
+	kemShared, err := kx.kemKey.Decapsulate(resp.KEM)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decapsulate ML-KEM shared key")
+	}
+
+	return getHybridSharedKey(kemShared, shared), nil
+}
+
+// serverKeyExchange creates the server side of the handshake.
+// It returns the public key and the ML-KEM ciphertext of the server and the shared key,
+// the ML-KEM encapsulation is used only if the client has sent its encapsulation key.
+func serverKeyExchange(req *entity.MessageHandshake, version entity.ProtocolVersion, method entity.KeyExchangeMethod) (publicKey, ciphertext, shared []byte, err error) {
+	hybrid := len(req.KEM) > 0 && version.Get() >= entity.ProtocolVersion2
+	if method.IsHybrid() && !hybrid {
+		return nil, nil, nil, entity.ErrKeyExchangeNotSupported
+	}
+
+	privateKey, err := GetECDHCurve().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, nil, errors.Wrap(err, "failed to generate ECDSA private key")
+	}
+
+	if shared, err = getSharedKey(privateKey, req.Key); err != nil {
+		return nil, nil, nil, err
+	}
+
+	if hybrid {
+		encapsulationKey, err := mlkem.NewEncapsulationKey768(req.KEM)
+		if err != nil {
+			return nil, nil, nil, errors.Wrap(err, "failed to check ML-KEM encapsulation key")
+		}
+		var kemShared []byte
+		kemShared, ciphertext = encapsulationKey.Encapsulate()
+		shared = getHybridSharedKey(kemShared, shared)
+	}
+
+	return privateKey.PublicKey().Bytes(), ciphertext, shared, nil
+}
+
+// getHybridSharedKey combines the ML-KEM and X25519 shared keys,
+// the session keys are derived from the result with HKDF.
+func getHybridSharedKey(kemShared, ecdhShared []byte) []byte {
+	shared := make([]byte, 0, len(kemShared)+len(ecdhShared))
+	shared = append(shared, kemShared...)
+	return append(shared, ecdhShared...)
+}
@@ -0,0 +1,47 @@
+package usecase
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/structs"
+)
+
+const rekeyProofContext = "tapir rekey proof"
+
+// getRekeyDigest returns the digest of the rekey request, it binds the ephemeral keys of the client
+// to the session and the new key phase
+func getRekeyDigest(sessionID uint32, phase bool, req *entity.MessageHandshake) []byte {
+	h := sha256.New()
+	h.Write([]byte(rekeyProofContext))
+	h.Write(binary.BigEndian.AppendUint32(nil, sessionID))
+	h.Write([]byte{byte(req.Version.Get()), structs.If[byte](phase, 1, 0)})
+	h.Write(req.Key)
+	h.Write(req.KEM)
+	return h.Sum(nil)
+}
+
+// sealRekey seals the digest of the rekey request with the current key of the connection.
+// Every client holds the pre-shared key the handshake messages are encrypted with,
+// the proof shows that the request is sent by the peer of the session.
+func sealRekey(enc entity.Encryptor, sessionID uint32, phase bool, req *entity.MessageHandshake) error {
+	proof, err := enc.Encrypt(getRekeyDigest(sessionID, phase, req))
+	if err != nil {
+		return err
+	}
+	req.Proof = proof
+	return nil
+}
+
+func verifyRekey(enc entity.Encryptor, sessionID uint32, phase bool, req *entity.MessageHandshake) error {
+	if enc == nil || len(req.Proof) == 0 {
+		return entity.ErrRekeyVerificationFailed
+	}
+	digest, err := enc.Decrypt(req.Proof)
+	if err != nil || subtle.ConstantTimeCompare(digest, getRekeyDigest(sessionID, phase, req)) != 1 {
+		return entity.ErrRekeyVerificationFailed
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package usecase
+
+import (
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const authenticationChallengeTimeout = 30 * time.Second
+
+type authChallenge struct {
+	data      []byte
+	expiredAt time.Time
+}
+
+func (uc *ServerUseCase) createChallenge(conn *entity.Connection) ([]byte, error) {
+	challenge, err := newAuthenticationChallenge()
+	if err != nil {
+		return nil, err
+	}
+
+	uc.challMux.Lock()
+	defer uc.challMux.Unlock()
+
+	now := time.Now()
+	for k, c := range uc.challenges {
+		if now.After(c.expiredAt) {
+			delete(uc.challenges, k)
+		}
+	}
+
+	uc.challenges[conn.Key()] = &authChallenge{
+		data:      challenge,
+		expiredAt: now.Add(authenticationChallengeTimeout),
+	}
+
+	return challenge, nil
+}
+
+// takeChallenge returns the challenge sent to the connection, each challenge can be used only once
+func (uc *ServerUseCase) takeChallenge(conn *entity.Connection) ([]byte, bool) {
+	uc.challMux.Lock()
+	defer uc.challMux.Unlock()
+
+	key := conn.Key()
+	c, ok := uc.challenges[key]
+	if !ok {
+		return nil, false
+	}
+	delete(uc.challenges, key)
+
+	if time.Now().After(c.expiredAt) {
+		return nil, false
+	}
+
+	return c.data, true
+}
+
+func (uc *ServerUseCase) removeChallenge(conn *entity.Connection) {
+	uc.challMux.Lock()
+	defer uc.challMux.Unlock()
+
+	delete(uc.challenges, conn.Key())
+}
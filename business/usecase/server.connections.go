@@ -1,7 +1,10 @@
 package usecase
 
 import (
+	"bytes"
 	"slices"
+	"sync/atomic"
+	"time"
 
 	"github.com/rs/zerolog"
 
@@ -12,22 +15,55 @@ import (
 type serverConn struct {
 	ifName           string
 	encryptor        entity.Encryptor
+	rekeyKey         []byte
 	sessionID        uint32
 	port             uint16
+	uplink           uint8
 	protocol         entity.Protocol
 	compressionType  entity.CompressionType
 	compressionLevel entity.CompressionLevel
+	lastSeen         atomic.Int64
 }
 
+// addConnection adds the path of the session. The paths are keyed by the client address,
+// so the uplinks of the client using the same server port are kept side by side.
+// The previous path of the same uplink and port is removed, the uplink re-authenticates
+// from the new address after it is reconnected.
 func (uc *ServerUseCase) addConnection(conn *entity.Connection, sc *serverConn) {
+	sc.seen()
 	uc.connMux.Lock()
 	defer uc.connMux.Unlock()
+
+	key := conn.Key()
 	for ck, cv := range uc.connections {
-		if cv.sessionID == sc.sessionID && cv.protocol == sc.protocol && cv.port == sc.port {
+		if ck != key && cv.sessionID == sc.sessionID && cv.protocol == sc.protocol && cv.port == sc.port && cv.uplink == sc.uplink {
 			delete(uc.connections, ck)
+			uc.removeInterfacePath(cv.ifName, ck)
 		}
 	}
-	uc.connections[conn.Key()] = sc
+	uc.connections[key] = sc
+}
+
+// removeInterfacePath removes the connection of the replaced path from the interface and stops
+// its retry and acknowledgement. It is called with the locked connMux.
+func (uc *ServerUseCase) removeInterfacePath(ifName string, key entity.ConnectionKey) {
+	ic, ok := uc.interfaces[ifName]
+	if !ok {
+		return
+	}
+
+	i := slices.IndexFunc(ic.Connections, func(c *entity.Connection) bool { return c.Key() == key })
+	if i == -1 {
+		return
+	}
+
+	if cv := ic.Connections[i]; cv.Retry != nil {
+		cv.Retry.Stop()
+	}
+	if cv := ic.Connections[i]; cv.Ack != nil {
+		cv.Ack.Stop()
+	}
+	ic.Connections = slices.Delete(ic.Connections, i, i+1)
 }
 
 func (uc *ServerUseCase) getConnection(conn *entity.Connection) (sc *serverConn, exists bool) {
@@ -52,18 +88,7 @@ func (uc *ServerUseCase) removeConnection(conn *entity.Connection) {
 				}
 
 				if len(uc.interfaces[c.ifName].Connections) == 0 {
-					uc.merger.DeleteStream(conn.SessionID)
-
-					if sess, ok := uc.sessions[conn.SessionID]; ok {
-						delete(uc.client2session, sess.ClientID)
-					}
-					delete(uc.sessions, conn.SessionID)
-					uc.srv.DropSession(conn.SessionID)
-
-					if err := uc.interfaces[c.ifName].handler.Close(); err != nil {
-						uc.log.Error().Err(err).Msg("failed to close network interface")
-					}
-					delete(uc.interfaces, c.ifName)
+					uc.expireSession(conn.SessionID, c.ifName)
 				}
 			}
 		}
@@ -76,10 +101,29 @@ func (uc *ServerUseCase) setConnectionEncryptor(conn *entity.Connection, enc ent
 	uc.connMux.Unlock()
 }
 
+// setConnectionRekey sets the encryptor of the new key phase. The client key of the rekey is kept,
+// a replayed rekey request is rejected and false is returned.
+func (uc *ServerUseCase) setConnectionRekey(conn *entity.Connection, enc entity.Encryptor, clientKey []byte) bool {
+	uc.connMux.Lock()
+	defer uc.connMux.Unlock()
+
+	sc, ok := uc.connections[conn.Key()]
+	if !ok || bytes.Equal(sc.rekeyKey, clientKey) {
+		return false
+	}
+	sc.encryptor = enc
+	sc.rekeyKey = clientKey
+
+	return true
+}
+
 func (uc *ServerUseCase) getConnectionEncryptor(conn *entity.Connection) (entity.Encryptor, error) {
 	uc.connMux.RLock()
 	var enc entity.Encryptor
 	c, ok := uc.connections[conn.Key()]
+	if !ok {
+		_, c, ok = uc.findRoamingConnection(conn)
+	}
 	if ok {
 		enc = c.encryptor
 	}
@@ -87,6 +131,93 @@ func (uc *ServerUseCase) getConnectionEncryptor(conn *entity.Connection) (entity
 	return enc, structs.If(!ok, entity.ErrConnectionNotExists, nil)
 }
 
+// findRoamingConnection returns the UDP path of the session with the same port if the client address is changed.
+// The path is returned only with the authenticated encryption, so the messages from the new address
+// are accepted only if they are encrypted with the key of the path. Every path has its own key, and the path
+// not seen for the longest time is chosen, so a live uplink on the same port is never taken over.
+// It is called with the locked connMux.
+func (uc *ServerUseCase) findRoamingConnection(conn *entity.Connection) (entity.ConnectionKey, *serverConn, bool) {
+	if conn.Protocol() != entity.ProtoUDP || conn.SessionID == 0 || !uc.cfg.Tunnel.Encryption.IsAuthenticated() {
+		return entity.ConnectionKey{}, nil, false
+	}
+
+	var (
+		key   entity.ConnectionKey
+		found *serverConn
+	)
+	for k, sc := range uc.connections {
+		if sc.sessionID != conn.SessionID || sc.protocol != entity.ProtoUDP || sc.port != conn.Port || sc.encryptor == nil {
+			continue
+		}
+		if found == nil || sc.lastSeen.Load() < found.lastSeen.Load() ||
+			sc.lastSeen.Load() == found.lastSeen.Load() && bytes.Compare(k[:], key[:]) < 0 {
+			key, found = k, sc
+		}
+	}
+
+	return key, found, found != nil
+}
+
+// roamConnection re-binds the UDP path of the session to the new client address,
+// it is called after the message from the new address is decrypted with the key of the path
+func (uc *ServerUseCase) roamConnection(conn *entity.Connection) (*serverConn, bool) {
+	uc.connMux.Lock()
+	defer uc.connMux.Unlock()
+
+	key, sc, ok := uc.findRoamingConnection(conn)
+	if !ok {
+		return nil, false
+	}
+
+	var oldAddr string
+	delete(uc.connections, key)
+	uc.connections[conn.Key()] = sc
+
+	sc.seen()
+
+	if ic, ok := uc.interfaces[sc.ifName]; ok {
+		for i, cv := range ic.Connections {
+			if cv.Key() == key {
+				oldAddr = cv.Addr.String()
+				conn.CompressionType = cv.CompressionType
+				conn.CompressionLevel = cv.CompressionLevel
+				conn.CreatedAt = cv.CreatedAt
+				ic.Connections[i] = conn
+				if cv.Retry != nil && cv.Retry != conn.Retry {
+					cv.Retry.Stop()
+				}
+				if cv.Ack != nil && cv.Ack != conn.Ack {
+					cv.Ack.Stop()
+				}
+				break
+			}
+		}
+	}
+
+	if conn.Retry != nil {
+		conn.Retry.SetConnection(conn)
+	}
+	if conn.Ack != nil {
+		conn.Ack.SetConnection(conn)
+	}
+
+	uc.log.Info().
+		Uint32("session_id", sc.sessionID).
+		Uint16("port", sc.port).
+		Str("old_addr", oldAddr).
+		Str("addr", conn.Addr.String()).
+		Msg("client address changed")
+
+	return sc, true
+}
+
+// seen records the time of the last message received on the path, it is stored only once a second
+func (sc *serverConn) seen() {
+	if now := time.Now().Unix(); sc.lastSeen.Load() != now {
+		sc.lastSeen.Store(now)
+	}
+}
+
 func (uc *ServerUseCase) disconnect(conn *entity.Connection, err error) {
 	var ev *zerolog.Event
 	if err != nil {
@@ -99,5 +230,6 @@ func (uc *ServerUseCase) disconnect(conn *entity.Connection, err error) {
 		Str("addr", conn.Addr.String()).
 		Msg("disconnected")
 
+	uc.removeChallenge(conn)
 	uc.removeConnection(conn)
 }
@@ -3,17 +3,21 @@ package usecase
 
 import (
 	"context"
-	"crypto/rand"
+	"crypto/ed25519"
+	"net/netip"
 	"sync"
+	"time"
 
 	"github.com/mitchellh/mapstructure"
 	"github.com/pkg/errors"
 
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/codec"
+	"github.com/forest33/tapir/pkg/encryptor"
 	"github.com/forest33/tapir/pkg/logger"
 	"github.com/forest33/tapir/pkg/structs"
 	"github.com/forest33/tapir/pkg/util/hash"
+	"github.com/forest33/tapir/pkg/util/password"
 )
 
 // ServerUseCase object capable of interacting with ServerUseCase
@@ -24,32 +28,46 @@ type ServerUseCase struct {
 	cfgHandler            configHandler
 	codec                 codec.Codec
 	encryptor             entity.Encryptor
+	signingKey            ed25519.PrivateKey
 	merger                entity.StreamMerger
 	srv                   entity.NetworkServer
 	iface                 entity.InterfaceAdapter
+	ipam                  entity.AddressManager
 	users                 map[string]*entity.User
 	connections           map[entity.ConnectionKey]*serverConn
 	interfaces            map[string]*ServerInterfaceInfo
+	sharedInterface       *entity.Interface
+	addresses             map[netip.Addr]*ServerInterfaceInfo
+	subnets               map[netip.Prefix]*ServerInterfaceInfo
+	macs                  map[entity.HardwareAddr]*macEntry
 	sessions              map[uint32]*ServerSessionInfo
 	client2session        map[string]uint32
+	challenges            map[entity.ConnectionKey]*authChallenge
 	statCh                chan *sessionStatisticRequest
 	connMux               sync.RWMutex
 	sessMux               sync.RWMutex
+	challMux              sync.Mutex
+	macMux                sync.RWMutex
 	portSelectionStrategy entity.PortSelectionStrategy
 	compressionType       entity.CompressionType
 }
 
 type ServerInterfaceInfo struct {
+	name        string
 	handler     *entity.Interface
 	Connections []*entity.Connection
 	SessionID   uint32
+	idleTimer   *time.Timer
+	frames      *frameSequence
+	subnets     []netip.Prefix
 }
 
 type ServerSessionInfo struct {
-	IfName   string
-	UserName string
-	ClientID string
-	Stat     *entity.Statistic
+	IfName      string
+	UserName    string
+	ClientID    string
+	MonotonicID bool
+	Stat        *entity.Statistic
 }
 
 type sessionStatisticRequest struct {
@@ -59,7 +77,11 @@ type sessionStatisticRequest struct {
 
 // NewServerUseCase creates a new ServerUseCase
 func NewServerUseCase(ctx context.Context, log *logger.Logger, cfg *entity.ServerConfig, cfgHandler configHandler,
-	merger entity.StreamMerger, srv entity.NetworkServer, iface entity.InterfaceAdapter) (*ServerUseCase, error) {
+	merger entity.StreamMerger, srv entity.NetworkServer, iface entity.InterfaceAdapter, ipam entity.AddressManager) (*ServerUseCase, error) {
+	signingKey, err := parseSigningKey(cfg.Authentication.SigningKey)
+	if err != nil {
+		return nil, err
+	}
 
 	uc := &ServerUseCase{
 		ctx:                   ctx,
@@ -68,13 +90,19 @@ func NewServerUseCase(ctx context.Context, log *logger.Logger, cfg *entity.Serve
 		cfgHandler:            cfgHandler,
 		codec:                 GetCodec(log, cfg.Tunnel.MTU, cfg.Tunnel.Encryption, cfg.Network.ObfuscateData),
 		encryptor:             GetEncryptor(cfg.Authentication.Key, cfg.Tunnel.Encryption),
+		signingKey:            signingKey,
 		merger:                merger,
 		srv:                   srv,
 		iface:                 iface,
+		ipam:                  ipam,
 		connections:           make(map[entity.ConnectionKey]*serverConn, cfg.Network.MaxPorts()),
 		interfaces:            make(map[string]*ServerInterfaceInfo, len(cfg.Users)),
+		addresses:             make(map[netip.Addr]*ServerInterfaceInfo, len(cfg.Users)*2),
+		subnets:               make(map[netip.Prefix]*ServerInterfaceInfo),
+		macs:                  make(map[entity.HardwareAddr]*macEntry),
 		sessions:              make(map[uint32]*ServerSessionInfo, len(cfg.Users)),
 		client2session:        make(map[string]uint32, len(cfg.Users)),
+		challenges:            make(map[entity.ConnectionKey]*authChallenge),
 		statCh:                make(chan *sessionStatisticRequest, len(cfg.Users)*cfg.Network.MaxPorts()),
 		portSelectionStrategy: entity.GetPortSelectionStrategy(cfg.Network.PortSelectionStrategy),
 		compressionType:       entity.GetCompressionType(cfg.Network.Compression),
@@ -93,7 +121,10 @@ func (uc *ServerUseCase) Start() error {
 		return err
 	}
 
-	uc.initUsers()
+	if err := uc.initUsers(); err != nil {
+		uc.log.Error().Err(err).Msg("failed to reserve static addresses")
+		return err
+	}
 	uc.sessionStat()
 
 	if uc.cfg.Network.UseStreamMerger() {
@@ -111,6 +142,13 @@ func (uc *ServerUseCase) Start() error {
 
 	uc.srv.SetStatisticHandler(uc.addSessionStat)
 
+	if uc.cfg.Tunnel.UseSharedInterface() {
+		if err := uc.createSharedInterface(); err != nil {
+			uc.log.Error().Err(err).Msg("failed to create shared network interface")
+			return err
+		}
+	}
+
 	var err error
 	for port := uc.cfg.Network.PortMin; port <= uc.cfg.Network.PortMax; port++ {
 		if *uc.cfg.Network.UseTCP {
@@ -148,8 +186,14 @@ func (uc *ServerUseCase) Start() error {
 	return nil
 }
 
-func (uc *ServerUseCase) initUsers() {
+func (uc *ServerUseCase) initUsers() error {
 	uc.users = structs.SliceToMap(uc.cfg.Users, func(u *entity.User) string { return u.Name })
+	for _, u := range uc.cfg.Users {
+		if u.Password != "" && !password.IsHash(u.Password) {
+			uc.log.Warn().Str("name", u.Name).Msg("plaintext password, run the migrate command to hash it")
+		}
+	}
+	return uc.ipam.SetStatic(uc.cfg.Users)
 }
 
 func (uc *ServerUseCase) socketReceiver(msg *entity.Message, conn *entity.Connection) error {
@@ -169,6 +213,7 @@ func (uc *ServerUseCase) command(msg *entity.Message, conn *entity.Connection) (
 					SessionID: conn.SessionID,
 					Type:      msg.Type,
 					Error:     entity.GetMessageError(err),
+					KeyPhase:  msg.KeyPhase,
 				}
 			}
 			uc.log.Error().Err(err).
@@ -205,14 +250,43 @@ func (uc *ServerUseCase) commandAuthentication(msg *entity.Message, conn *entity
 	}
 
 	req := m.(*entity.MessageAuthenticationRequest)
-	if user, ok := uc.users[req.Name]; !ok || user.Password != req.Password {
+	version := entity.ProtocolVersionCurrent.Negotiate(req.Version)
+	if version < uc.cfg.Network.GetMinProtocolVersion() {
+		uc.log.Error().Err(entity.ErrUnsupportedVersion).
+			Str("name", req.Name).
+			Uint8("version", uint8(req.Version.Get())).
+			Msg("unsupported client protocol version")
+		return nil, entity.ErrUnsupportedVersion
+	}
+
+	if deviceType := req.Extensions.DeviceType(); (deviceType == entity.DeviceTypeTAP) != uc.cfg.Tunnel.IsTAP() {
+		uc.log.Error().Err(entity.ErrWrongDeviceType).
+			Str("name", req.Name).
+			Str("device_type", string(deviceType)).
+			Msg("client device type does not match the server")
+		return nil, entity.ErrWrongDeviceType
+	}
+
+	if user, ok := uc.users[req.Name]; ok && req.PublicKey != nil && user.HasPublicKey(req.PublicKey) {
+		if req.Signature == nil {
+			return uc.authenticationChallenge(msg, conn)
+		}
+		challenge, ok := uc.takeChallenge(conn)
+		if !ok || verifyAuthentication(req, challenge) != nil {
+			uc.log.Error().Err(entity.ErrUnauthorized).Str("name", req.Name).Msg("incorrect public key signature")
+			return nil, entity.ErrUnauthorized
+		}
+	} else if !ok || !password.Verify(user.Password, req.Password) {
+		if !ok {
+			password.VerifyDummy(req.Password)
+		}
 		uc.log.Error().Err(entity.ErrUnauthorized).Str("name", req.Name).Msg("incorrect name or password")
 		return nil, entity.ErrUnauthorized
 	}
 
 	if msg.SessionID == 0 {
 		_ = uc.dropSessionByClientID(req.ClientID)
-		conn.SessionID = uc.createSession(req.ClientID, req.Name)
+		conn.SessionID = uc.createSession(req.ClientID, req.Name, msg.MonotonicID)
 	} else {
 		if err := uc.checkSession(msg.SessionID, req.ClientID, req.Name); err != nil {
 			uc.log.Error().Err(entity.ErrUnauthorized).Uint32("session_id", msg.SessionID).Str("client_id", req.ClientID).Msg("incorrect session ID")
@@ -228,21 +302,32 @@ func (uc *ServerUseCase) commandAuthentication(msg *entity.Message, conn *entity
 		}
 	}
 
-	ic, err := uc.createInterface(conn.SessionID)
+	var ext entity.Extensions
+	if req.Version != 0 {
+		ext = uc.getExtensions(req)
+	}
+	mtu, _ := ext.Uint16(entity.ExtensionMTU)
+
+	ic, err := uc.createInterface(conn.SessionID, int(mtu))
 	if err != nil {
 		uc.log.Error().Err(err).
 			Str("name", req.Name).
 			Str("client_id", req.ClientID).
 			Uint32("session_id", conn.SessionID).
 			Msg("failed to create network interface")
+		_ = uc.dropSessionByID(conn.SessionID)
+		if errors.Is(err, entity.ErrAddressPoolExhausted) {
+			return nil, err
+		}
 		return nil, entity.ErrInternalError
 	}
 
-	ifName, _ := ic.handler.Name() // sometimes panic!
+	uplink, _ := req.Extensions.Uint8(entity.ExtensionUplink)
 	uc.addConnection(conn, &serverConn{
-		ifName:           ifName,
+		ifName:           ic.name,
 		sessionID:        conn.SessionID,
 		port:             conn.Port,
+		uplink:           uplink,
 		protocol:         conn.Protocol(),
 		compressionType:  req.CompressionType,
 		compressionLevel: req.CompressionLevel,
@@ -253,15 +338,80 @@ func (uc *ServerUseCase) commandAuthentication(msg *entity.Message, conn *entity
 		Str("client_id", req.ClientID).
 		Str("name", req.Name).
 		Str("addr", conn.Addr.String()).
+		Uint8("version", uint8(version)).
 		Msg("authentication successful")
 
+	resp := &entity.MessageAuthenticationResponse{
+		SessionID: conn.SessionID,
+		LocalIP:   ic.handler.IP.ClientLocal,
+		RemoteIP:  ic.handler.IP.ClientRemote,
+		LocalIP6:  ic.handler.IP.ClientLocal6,
+		RemoteIP6: ic.handler.IP.ClientRemote6,
+	}
+	if req.Version != 0 {
+		resp.Version = version
+		resp.Extensions = ext
+		if subnets, ok := req.Extensions.Prefixes(entity.ExtensionSubnets); ok {
+			accepted := uc.registerSubnets(ic, req.Name, subnets)
+			resp.Extensions.SetStrings(entity.ExtensionSubnets, structs.Map(accepted, netip.Prefix.String))
+		}
+	}
+
+	return &entity.Message{
+		SessionID:   conn.SessionID,
+		Type:        msg.Type,
+		MonotonicID: ic.handler.MonotonicID,
+		Payload:     resp,
+	}, nil
+}
+
+// getExtensions returns the extensions of the authentication response,
+// the MTU and the optional features are negotiated with the extensions of the client.
+// The network configuration of the user overrides the global one.
+func (uc *ServerUseCase) getExtensions(req *entity.MessageAuthenticationRequest) entity.Extensions {
+	ext := entity.Extensions{
+		entity.ExtensionEncryption:  []byte(uc.cfg.Tunnel.Encryption),
+		entity.ExtensionCompression: {req.CompressionType.Byte(), req.CompressionLevel.Byte()},
+	}
+
+	var userPush *entity.PushConfig
+	if user, ok := uc.users[req.Name]; ok {
+		userPush = user.Push
+	}
+	push := uc.cfg.Push.Merge(userPush)
+	ext.SetPushedNetwork(push)
+
+	mtu := uint16(structs.If(push.MTU != 0, push.MTU, uc.cfg.Tunnel.MTU))
+	if clientMTU, ok := req.Extensions.Uint16(entity.ExtensionMTU); ok && clientMTU < mtu {
+		mtu = clientMTU
+	}
+	ext.SetUint16(entity.ExtensionMTU, mtu)
+
+	features := entity.FeatureHybridKeyExchange | entity.FeaturePublicKeyAuthentication
+	if uc.cfg.Network.HeaderProtection != entity.HeaderProtectionNameLegacy {
+		features |= entity.FeatureHeaderProtection
+	}
+	ext.SetUint32(entity.ExtensionFeatures, uint32(features&req.Extensions.Features()))
+
+	if uc.cfg.Tunnel.IsTAP() {
+		ext[entity.ExtensionDeviceType] = []byte(entity.DeviceTypeTAP)
+	}
+
+	return ext
+}
+
+func (uc *ServerUseCase) authenticationChallenge(msg *entity.Message, conn *entity.Connection) (*entity.Message, error) {
+	challenge, err := uc.createChallenge(conn)
+	if err != nil {
+		uc.log.Error().Err(err).Msg("failed to create authentication challenge")
+		return nil, entity.ErrInternalError
+	}
+
 	return &entity.Message{
 		SessionID: conn.SessionID,
 		Type:      msg.Type,
 		Payload: &entity.MessageAuthenticationResponse{
-			SessionID: conn.SessionID,
-			LocalIP:   ic.handler.IP.ClientLocal,
-			RemoteIP:  ic.handler.IP.ClientRemote,
+			Challenge: challenge,
 		},
 	}, nil
 }
@@ -283,22 +433,77 @@ func (uc *ServerUseCase) commandHandshake(msg *entity.Message, conn *entity.Conn
 		return nil, entity.ErrConnectionNotExists
 	}
 
-	privateKey, err := GetECDHCurve().GenerateKey(rand.Reader)
-	if err != nil {
-		return nil, errors.Wrap(err, "failed to generate ECDSA private key")
+	// the rekey request must be sealed with the current key of the connection, the key of the previous phase
+	// is accepted when the client retries the rekey after the response was lost
+	cur, _ := uc.getConnectionEncryptor(conn)
+	kp, isRekey := cur.(*encryptor.KeyPhase)
+	if isRekey {
+		if err := verifyRekey(entity.GetPhaseEncryptor(kp, !msg.KeyPhase), conn.SessionID, msg.KeyPhase, req); err != nil {
+			uc.log.Error().Err(err).
+				Uint32("session_id", conn.SessionID).
+				Str("addr", conn.Addr.String()).
+				Msg("rekey request rejected")
+			return nil, err
+		}
+	}
+
+	version := entity.ProtocolVersionCurrent.Negotiate(req.Version)
+	if version < uc.cfg.Network.GetMinProtocolVersion() {
+		return nil, entity.ErrUnsupportedVersion
 	}
 
-	publicKey, err := GetECDHCurve().NewPublicKey(req.Key)
+	serverKey, ciphertext, shared, err := serverKeyExchange(req, version, uc.cfg.Tunnel.KeyExchange)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to check public key")
+		return nil, err
+	}
+
+	hk := &handshakeKeys{
+		version:   version,
+		shared:    shared,
+		clientKey: req.Key,
+		serverKey: serverKey,
+		clientKEM: structs.If(ciphertext != nil, req.KEM, nil),
+		serverKEM: ciphertext,
+		sessionID: conn.SessionID,
+		clientID:  uc.getSessionClientID(conn.SessionID),
 	}
 
-	shared, err := privateKey.ECDH(publicKey)
+	enc, err := getHandshakeEncryptor(hk, uc.cfg.Tunnel.Encryption, true)
 	if err != nil {
-		return nil, errors.Wrap(err, "failed to create shared key")
+		return nil, errors.Wrap(err, "failed to derive session keys")
+	}
+
+	resp := &entity.Message{
+		SessionID: conn.SessionID,
+		Type:      msg.Type,
+		KeyPhase:  msg.KeyPhase,
+		Payload: &entity.MessageHandshake{
+			Key:       hk.serverKey,
+			Version:   structs.If(req.Version != 0, hk.version, 0),
+			Signature: structs.If(req.Version != 0, signHandshake(uc.signingKey, hk), nil),
+			KEM:       ciphertext,
+		},
+	}
+
+	if isRekey {
+		// the client switches to the new key phase when it receives the response,
+		// until then the messages are sent with the current key
+		if !uc.setConnectionRekey(conn, kp.NextPending(enc, msg.KeyPhase), req.Key) {
+			return nil, entity.ErrRekeyVerificationFailed
+		}
+		uc.log.Info().
+			Uint32("session_id", conn.SessionID).
+			Str("addr", conn.Addr.String()).
+			Str("proto", conn.Protocol().String()).
+			Bool("key_phase", msg.KeyPhase).
+			Uint8("version", uint8(hk.version)).
+			Bool("hybrid", ciphertext != nil).
+			Str("key", hash.MD5(shared)).
+			Msg("rekey successful")
+		return resp, nil
 	}
 
-	uc.setConnectionEncryptor(conn, GetEncryptor(string(shared), uc.cfg.Tunnel.Encryption))
+	uc.setConnectionEncryptor(conn, encryptor.NewKeyPhase(enc, msg.KeyPhase, getRekeyGracePeriod(uc.cfg.Tunnel)))
 	if err := uc.addInterfaceConnection(sc, conn); err != nil {
 		return nil, errors.Wrap(err, "failed to add interface connection")
 	}
@@ -307,20 +512,19 @@ func (uc *ServerUseCase) commandHandshake(msg *entity.Message, conn *entity.Conn
 		Uint32("session_id", conn.SessionID).
 		Str("addr", conn.Addr.String()).
 		Str("proto", conn.Protocol().String()).
+		Uint8("version", uint8(hk.version)).
+		Bool("hybrid", ciphertext != nil).
 		Str("key", hash.MD5(shared)).
 		Msg("handshake successful")
 
-	return &entity.Message{
-		SessionID: conn.SessionID,
-		Type:      msg.Type,
-		Payload: &entity.MessageHandshake{
-			Key: privateKey.PublicKey().Bytes(),
-		},
-	}, nil
+	return resp, nil
 }
 
 func (uc *ServerUseCase) commandData(msg *entity.Message, conn *entity.Connection) (*entity.Message, error) {
 	sc, ok := uc.getConnection(conn)
+	if !ok {
+		sc, ok = uc.roamConnection(conn)
+	}
 	if !ok {
 		uc.log.Error().Err(entity.ErrConnectionNotExists).
 			Uint32("session_id", msg.SessionID).
@@ -328,6 +532,25 @@ func (uc *ServerUseCase) commandData(msg *entity.Message, conn *entity.Connectio
 			Msg(entity.ErrConnectionNotExists.Error())
 		return nil, entity.ErrConnectionNotExists
 	}
+	sc.seen()
+
+	// the frames of the TAP mode are switched by the MAC addresses learned from the sessions
+	if (uc.sharedInterface != nil || uc.cfg.Tunnel.UseHub()) && !uc.cfg.Tunnel.IsTAP() && msg.PacketInfo != nil &&
+		!uc.isSessionSource(sc.sessionID, msg.PacketInfo.Source) {
+		uc.log.Debug().
+			Uint32("session_id", sc.sessionID).
+			Str("src", msg.PacketInfo.Source.String()).
+			Msg("packet with foreign source address dropped")
+		return nil, nil
+	}
+
+	if uc.cfg.Tunnel.IsTAP() {
+		if uc.switchSessionFrame(msg, sc) {
+			return nil, nil
+		}
+	} else if uc.cfg.Tunnel.UseHub() && uc.hubForward(msg, sc) {
+		return nil, nil
+	}
 
 	ifc, ok := uc.getInterfaceByName(sc.ifName)
 	if !ok {
@@ -388,5 +611,7 @@ func (uc *ServerUseCase) GetInterfaces() map[string]*ServerInterfaceInfo {
 
 func (uc *ServerUseCase) onConfigChanged(cfg interface{}) {
 	uc.cfg = cfg.(*entity.ServerConfig)
-	uc.initUsers()
+	if err := uc.initUsers(); err != nil {
+		uc.log.Error().Err(err).Msg("failed to reserve static addresses")
+	}
 }
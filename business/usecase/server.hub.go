@@ -0,0 +1,66 @@
+package usecase
+
+import (
+	"github.com/forest33/tapir/business/entity"
+)
+
+// hubForward sends the packet of the session directly to the session of the destination tunnel address
+// in the hub mode, the packets not allowed by the peers of the user are dropped. It returns false
+// if the destination is not a tunnel address of another session, the packet is written to the interface then.
+func (uc *ServerUseCase) hubForward(msg *entity.Message, sc *serverConn) bool {
+	if msg.PacketInfo == nil {
+		return false
+	}
+
+	uc.connMux.RLock()
+	ic, ok := uc.lookupAddress(msg.PacketInfo.Destination)
+	if !ok || ic.SessionID == sc.sessionID {
+		uc.connMux.RUnlock()
+		return false
+	}
+	dstSessionID, frames := ic.SessionID, ic.frames
+	conn, err := uc.selectConnection(ic, msg.PacketInfo.PeerEndpoint)
+	uc.connMux.RUnlock()
+
+	if !uc.isHubAllowed(sc.sessionID, dstSessionID) {
+		uc.log.Debug().
+			Uint32("session_id", sc.sessionID).
+			Uint32("dst_session_id", dstSessionID).
+			Str("dst", msg.PacketInfo.Destination.String()).
+			Msg("packet to session is not allowed")
+		return true
+	}
+
+	if err != nil {
+		uc.log.Debug().Err(err).Uint32("dst_session_id", dstSessionID).Msg("failed to get session connections")
+		return true
+	}
+
+	// the packet is sent with the endpoint the destination client decodes, the packets of all sources
+	// are numbered by the sequence of the destination session, like the packets read from the interface
+	info := *msg.PacketInfo
+	info.Endpoint = info.PeerEndpoint
+	fwd := copyMessage(msg)
+	fwd.PacketInfo = &info
+	fwd.ID = frames.next(info.Endpoint)
+	fwd.MonotonicID = frames.monotonic
+
+	_ = uc.sendData(fwd, conn)
+
+	return true
+}
+
+// isHubAllowed checks that the user of the source session can reach the user of the destination session
+func (uc *ServerUseCase) isHubAllowed(srcSessionID, dstSessionID uint32) bool {
+	uc.sessMux.RLock()
+	src, srcOk := uc.sessions[srcSessionID]
+	dst, dstOk := uc.sessions[dstSessionID]
+	uc.sessMux.RUnlock()
+
+	if !srcOk || !dstOk {
+		return false
+	}
+
+	user, ok := uc.users[src.UserName]
+	return ok && user.CanReach(dst.UserName)
+}
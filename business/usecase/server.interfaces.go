@@ -2,88 +2,120 @@ package usecase
 
 import (
 	"context"
-	"encoding/binary"
 	"math/rand"
-	"net"
 	"time"
 
 	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/structs"
 )
 
-func (uc *ServerUseCase) createInterface(sessionID uint32) (*ServerInterfaceInfo, error) {
+func (uc *ServerUseCase) createInterface(sessionID uint32, mtu int) (*ServerInterfaceInfo, error) {
 	uc.connMux.Lock()
 	defer uc.connMux.Unlock()
 
 	if sess, ok := uc.sessions[sessionID]; ok && len(sess.IfName) != 0 {
+		uc.interfaces[sess.IfName].stopIdleTimer()
 		return uc.interfaces[sess.IfName], nil
 	}
 
+	if uc.sharedInterface != nil {
+		return uc.addSharedInterfaceSession(sessionID)
+	}
+
+	sess := uc.sessions[sessionID]
+	ip, err := uc.ipam.Acquire(sess.UserName, sess.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
 	ctx, cancel := context.WithCancel(uc.ctx)
 	ch := make(chan *entity.Message, uc.cfg.Tunnel.NumberOfHandlerThreads*10)
 	for i := 0; i < uc.cfg.Tunnel.NumberOfHandlerThreads; i++ {
 		uc.interfaceLoop(ctx, ch)
 	}
 
+	// the MTU negotiated with the client is applied to the device of the session
+	var network *entity.PushedNetwork
+	if mtu != 0 {
+		network = &entity.PushedNetwork{MTU: mtu}
+	}
+
 	ifc, err := uc.iface.Create(&entity.Interface{
-		Type:     entity.DeviceTypeTUN,
-		IP:       uc.getTunnelIP(),
-		Receiver: ch,
-		Cancel:   cancel,
+		Type:        uc.cfg.Tunnel.DeviceType,
+		IP:          ip,
+		Receiver:    ch,
+		Cancel:      cancel,
+		Network:     network,
+		MonotonicID: sess.MonotonicID,
 	})
 	if err != nil {
+		cancel()
+		uc.ipam.Release(sess.ClientID)
 		return nil, err
 	}
 
+	ifName, _ := ifc.Name()
 	ic := &ServerInterfaceInfo{
+		name:        ifName,
 		handler:     ifc,
 		Connections: make([]*entity.Connection, 0, uc.cfg.Network.MaxPorts()),
 		SessionID:   sessionID,
 	}
 
-	ifName, _ := ifc.Name()
+	if uc.cfg.Tunnel.UseHub() {
+		ic.frames = newFrameSequence(int64(uc.cfg.StreamMerger.StreamTTL)*2, sess.MonotonicID)
+	}
+
 	uc.interfaces[ifName] = ic
 	uc.sessions[sessionID].IfName = ifName
+	for _, addr := range tunnelAddresses(ic.handler.IP) {
+		uc.addresses[addr] = ic
+	}
 
 	uc.log.Info().
 		Uint32("session_id", sessionID).
 		Str("device", ifName).
-		Int("MTU", uc.cfg.Tunnel.MTU).
+		Int("MTU", structs.If(mtu != 0, mtu, uc.cfg.Tunnel.MTU)).
 		Str("server_local_ip", ifc.IP.ServerLocal.String()).
 		Str("server_remote_ip", ifc.IP.ServerRemote.String()).
 		Str("client_local_ip", ifc.IP.ClientLocal.String()).
-		Str("server_remote_ip", ifc.IP.ClientRemote.String()).
+		Str("client_remote_ip", ifc.IP.ClientRemote.String()).
 		Msg("network interface created")
 
+	if ifc.IP.HasIPv6() {
+		uc.log.Info().
+			Uint32("session_id", sessionID).
+			Str("device", ifName).
+			Str("server_local_ip6", ifc.IP.ServerLocal6.String()).
+			Str("server_remote_ip6", ifc.IP.ServerRemote6.String()).
+			Str("client_local_ip6", ifc.IP.ClientLocal6.String()).
+			Str("client_remote_ip6", ifc.IP.ClientRemote6.String()).
+			Msg("network interface IPv6 addresses assigned")
+	}
+
 	return ic, nil
 }
 
-func (uc *ServerUseCase) getTunnelIP() entity.IfIP {
-	var (
-		maxIP uint32
-		last  *entity.Interface
-	)
-
-	for _, ifc := range uc.interfaces {
-		ip := binary.BigEndian.Uint32(ifc.handler.IP.ClientRemote.To4())
-		if ip > maxIP {
-			maxIP = ip
-			last = ifc.handler
-		}
+// closeInterface removes the tunnel addresses, the subnets and the MAC addresses of the session from the address
+// tables, releases the addresses and closes the interface unless it is the shared interface.
+// It is called with the locked connMux.
+func (uc *ServerUseCase) closeInterface(ifName string) {
+	ic := uc.interfaces[ifName]
+	if sess, ok := uc.sessions[ic.SessionID]; ok {
+		uc.ipam.Release(sess.ClientID)
 	}
 
-	fromIP := binary.BigEndian.Uint32(net.ParseIP(uc.cfg.Tunnel.AddrMin).To4())
-	if last != nil {
-		fromIP = binary.BigEndian.Uint32(last.IP.ClientRemote.To4()) + 1
+	uc.unregisterSubnets(ic)
+	uc.forgetMACs(ic)
+	for _, addr := range tunnelAddresses(ic.handler.IP) {
+		delete(uc.addresses, addr)
 	}
-
-	ip := entity.IfIP{
-		ServerLocal:  int2ip(fromIP),
-		ServerRemote: int2ip(fromIP + 1),
-		ClientLocal:  int2ip(fromIP + 2),
-		ClientRemote: int2ip(fromIP + 3),
+	if uc.sharedInterface == nil {
+		if err := ic.handler.Close(); err != nil {
+			uc.log.Error().Err(err).Msg("failed to close network interface")
+		}
 	}
-
-	return ip
+	delete(uc.interfaces, ifName)
 }
 
 func (uc *ServerUseCase) getInterfaceByName(ifName string) (ifc *entity.Interface, exists bool) {
@@ -107,8 +139,9 @@ func (uc *ServerUseCase) addInterfaceConnection(sc *serverConn, conn *entity.Con
 		conn.CompressionType = sc.compressionType
 		conn.CompressionLevel = sc.compressionLevel
 		conn.CreatedAt = time.Now().Unix()
+		ic.stopIdleTimer()
 		for i, cv := range ic.Connections {
-			if cv.SessionID == sc.sessionID && cv.Protocol() == sc.protocol && cv.Port == sc.port {
+			if cv.Key() == conn.Key() {
 				ic.Connections[i] = conn
 				return nil
 			}
@@ -124,20 +157,14 @@ func (uc *ServerUseCase) getInterfaceConnection(packet *entity.NetworkPacketInfo
 	uc.connMux.RLock()
 
 	var ok bool
-	if ic, ok = uc.interfaces[packet.IfName]; ok {
-		length := len(ic.Connections)
-		if length > 0 {
-			switch uc.portSelectionStrategy {
-			case entity.PortSelectionStrategyRandom:
-				conn = uc.interfaces[packet.IfName].Connections[rand.Intn(length)]
-			case entity.PortSelectionStrategyHash:
-				conn = uc.interfaces[packet.IfName].Connections[int(packet.Endpoint.Uint64()%uint64(length))]
-			default:
-				err = entity.ErrNoPortSelectionStrategy
-			}
-		} else {
-			err = entity.ErrConnectionNotExists
-		}
+	if uc.sharedInterface != nil {
+		ic, ok = uc.lookupAddress(packet.Destination)
+	} else {
+		ic, ok = uc.interfaces[packet.IfName]
+	}
+
+	if ok {
+		conn, err = uc.selectConnection(ic, packet.Endpoint)
 	} else {
 		err = entity.ErrInterfaceNotExists
 	}
@@ -147,6 +174,24 @@ func (uc *ServerUseCase) getInterfaceConnection(packet *entity.NetworkPacketInfo
 	return
 }
 
+// selectConnection returns the connection of the session chosen by the port selection strategy,
+// it is called with the locked connMux
+func (uc *ServerUseCase) selectConnection(ic *ServerInterfaceInfo, endpoint entity.PacketEndpoint) (*entity.Connection, error) {
+	length := len(ic.Connections)
+	if length == 0 {
+		return nil, entity.ErrConnectionNotExists
+	}
+
+	switch uc.portSelectionStrategy {
+	case entity.PortSelectionStrategyRandom:
+		return ic.Connections[rand.Intn(length)], nil
+	case entity.PortSelectionStrategyHash:
+		return ic.Connections[int(endpoint.Uint64()%uint64(length))], nil
+	default:
+		return nil, entity.ErrNoPortSelectionStrategy
+	}
+}
+
 func (uc *ServerUseCase) interfaceLoop(ctx context.Context, ch chan *entity.Message) {
 	go func() {
 		for {
@@ -170,22 +215,43 @@ func (uc *ServerUseCase) interfaceReceiver(msg *entity.Message) error {
 		uc.log.Fatalf("NIL MESSAGE: %+v", msg)
 	}
 
-	_, conn, err := uc.getInterfaceConnection(msg.PacketInfo)
-	if err != nil {
+	if uc.cfg.Tunnel.IsTAP() {
+		uc.iface.ReceiveLog(msg)
+		uc.switchInterfaceFrame(msg)
+		return nil
+	}
+
+	ic, conn, err := uc.getInterfaceConnection(msg.PacketInfo)
+	if err != nil && uc.sharedInterface != nil {
+		// the destination is not a tunnel address of a session
+		uc.log.Debug().Err(err).Str("dst", msg.PacketInfo.Destination.String()).Msg("failed to get session connections")
+		return nil
+	} else if err != nil {
 		uc.log.Warn().Err(err).Str("if", msg.PacketInfo.IfName).Msg("failed to get interface connections")
 		return nil
 	} else if conn == nil {
 		return nil
 	}
 
+	// the packets read from the shared interface and forwarded by the hub share the IDs of the session
+	if ic.frames != nil {
+		msg.ID = ic.frames.next(msg.PacketInfo.Endpoint)
+		msg.MonotonicID = ic.frames.monotonic
+	}
+
+	uc.iface.ReceiveLog(msg)
+
+	return uc.sendData(msg, conn)
+}
+
+// sendData sends the packet to the client through the connection of its session
+func (uc *ServerUseCase) sendData(msg *entity.Message, conn *entity.Connection) error {
 	msg.SessionID = conn.SessionID
 	msg.Type = entity.MessageTypeData
 	msg.CompressionType = conn.CompressionType
 	msg.CompressionLevel = conn.CompressionLevel
 
-	uc.iface.ReceiveLog(msg)
-
-	err = uc.srv.Send(msg, conn)
+	err := uc.srv.Send(msg, conn)
 	if err != nil {
 		uc.log.Error().Err(err).Uint32("id", msg.ID).Msg("failed to send data frame")
 	}
@@ -8,7 +8,7 @@ import (
 	"github.com/forest33/tapir/business/entity"
 )
 
-func (uc *ServerUseCase) createSession(clientID, userName string) uint32 {
+func (uc *ServerUseCase) createSession(clientID, userName string, monotonicID bool) uint32 {
 	uc.sessMux.Lock()
 	defer uc.sessMux.Unlock()
 
@@ -22,9 +22,10 @@ func (uc *ServerUseCase) createSession(clientID, userName string) uint32 {
 			}
 		}
 		uc.sessions[sessionID] = &ServerSessionInfo{
-			ClientID: clientID,
-			UserName: userName,
-			Stat:     &entity.Statistic{},
+			ClientID:    clientID,
+			UserName:    userName,
+			MonotonicID: monotonicID,
+			Stat:        &entity.Statistic{},
 		}
 		uc.client2session[clientID] = sessionID
 	}
@@ -45,6 +46,17 @@ func (uc *ServerUseCase) checkSession(sessionID uint32, clientID, userName strin
 	return nil
 }
 
+func (uc *ServerUseCase) getSessionClientID(sessionID uint32) string {
+	uc.sessMux.RLock()
+	defer uc.sessMux.RUnlock()
+
+	if s, ok := uc.sessions[sessionID]; ok {
+		return s.ClientID
+	}
+
+	return ""
+}
+
 func (uc *ServerUseCase) dropSessionByID(sessionID uint32) error {
 	uc.sessMux.Lock()
 	defer uc.sessMux.Unlock()
@@ -90,30 +102,70 @@ func (uc *ServerUseCase) dropSession(sessionID uint32) {
 		Str("client_id", uc.sessions[sessionID].ClientID).
 		Msg("dropping session")
 
+	// the interface does not exist if it failed to be created
 	ifName := uc.sessions[sessionID].IfName
-	if _, ok := uc.interfaces[ifName]; !ok {
-		uc.log.Error().
-			Uint32("session_id", sessionID).
-			Str("if", ifName).
-			Msg("interface not exists")
-		return
-	}
-
-	for _, conn := range uc.interfaces[ifName].Connections {
-		if conn.Retry != nil {
-			conn.Retry.Stop()
-		}
-		if conn.Ack != nil {
-			conn.Ack.Stop()
+	if ic, ok := uc.interfaces[ifName]; ok {
+		ic.stopIdleTimer()
+		for _, conn := range ic.Connections {
+			if conn.Retry != nil {
+				conn.Retry.Stop()
+			}
+			if conn.Ack != nil {
+				conn.Ack.Stop()
+			}
 		}
-	}
-	if err := uc.interfaces[ifName].handler.Close(); err != nil {
-		uc.log.Error().Err(err).Msg("failed to close network interface")
+		uc.closeInterface(ifName)
 	}
 
-	delete(uc.interfaces, ifName)
 	delete(uc.client2session, uc.sessions[sessionID].ClientID)
 	delete(uc.sessions, sessionID)
 	uc.merger.DeleteStream(sessionID)
 	uc.srv.DropSession(sessionID)
 }
+
+// expireSession closes the session after the last connection is removed. The session and its interface are kept
+// for the session timeout, so the client can resume the session with the same addresses after a short outage.
+// It is called with the locked connMux.
+func (uc *ServerUseCase) expireSession(sessionID uint32, ifName string) {
+	timeout := time.Duration(uc.cfg.Network.SessionTimeout) * time.Second
+	if timeout <= 0 {
+		uc.closeSession(sessionID, ifName)
+		return
+	}
+
+	ic := uc.interfaces[ifName]
+	ic.stopIdleTimer()
+
+	var timer *time.Timer
+	timer = time.AfterFunc(timeout, func() {
+		uc.connMux.Lock()
+		defer uc.connMux.Unlock()
+
+		if uc.interfaces[ifName] != ic || ic.idleTimer != timer || len(ic.Connections) != 0 {
+			return
+		}
+
+		uc.log.Info().Uint32("session_id", sessionID).Str("if", ifName).Msg("session expired")
+		uc.closeSession(sessionID, ifName)
+	})
+	ic.idleTimer = timer
+}
+
+// closeSession removes the session and closes its interface, it is called with the locked connMux
+func (uc *ServerUseCase) closeSession(sessionID uint32, ifName string) {
+	uc.closeInterface(ifName)
+	uc.merger.DeleteStream(sessionID)
+
+	if sess, ok := uc.sessions[sessionID]; ok {
+		delete(uc.client2session, sess.ClientID)
+	}
+	delete(uc.sessions, sessionID)
+	uc.srv.DropSession(sessionID)
+}
+
+func (ic *ServerInterfaceInfo) stopIdleTimer() {
+	if ic.idleTimer != nil {
+		ic.idleTimer.Stop()
+		ic.idleTimer = nil
+	}
+}
@@ -0,0 +1,156 @@
+package usecase
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// frameSequence numbers the packets sent to a session per endpoint. The packets read from the device shared
+// by all sessions or forwarded from other sessions by the hub are not numbered consecutively for a session,
+// but the stream merger of the client expects consecutive IDs. Like the sequences of the interface, a new or idle endpoint continues from the highest ID
+// sent to the session if the session uses monotonic IDs and starts from 1 otherwise.
+type frameSequence struct {
+	ids       map[entity.PacketEndpoint]*frameEndpoint
+	ttl       int64
+	lastID    uint32
+	monotonic bool
+	sync.Mutex
+}
+
+type frameEndpoint struct {
+	id uint32
+	ts int64
+}
+
+func newFrameSequence(ttl int64, monotonic bool) *frameSequence {
+	return &frameSequence{
+		ids:       make(map[entity.PacketEndpoint]*frameEndpoint),
+		ttl:       ttl,
+		monotonic: monotonic,
+	}
+}
+
+func (s *frameSequence) next(endpoint entity.PacketEndpoint) uint32 {
+	s.Lock()
+	defer s.Unlock()
+
+	now := time.Now().Unix()
+	e, ok := s.ids[endpoint]
+	if !ok {
+		e = &frameEndpoint{}
+		s.ids[endpoint] = e
+	} else if now > e.ts+s.ttl {
+		e.id = 0
+	}
+	if e.id == 0 && s.monotonic {
+		e.id = s.lastID
+	}
+	e.id++
+	e.ts = now
+	s.lastID = max(s.lastID, e.id)
+
+	return e.id
+}
+
+// createSharedInterface creates the single interface of all sessions. The packets read from the interface
+// are sent to the sessions by the destination tunnel address instead of the interface name.
+func (uc *ServerUseCase) createSharedInterface() error {
+	uc.connMux.Lock()
+	defer uc.connMux.Unlock()
+
+	ip, err := uc.ipam.Reserve()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(uc.ctx)
+	ch := make(chan *entity.Message, uc.cfg.Tunnel.NumberOfHandlerThreads*10)
+	for i := 0; i < uc.cfg.Tunnel.NumberOfHandlerThreads; i++ {
+		uc.interfaceLoop(ctx, ch)
+	}
+
+	// the packets read from the device are numbered by the frame sequence of the session
+	ifc, err := uc.iface.Create(&entity.Interface{
+		Type:     uc.cfg.Tunnel.DeviceType,
+		IP:       ip,
+		Receiver: ch,
+		Cancel:   cancel,
+	})
+	if err != nil {
+		cancel()
+		return err
+	}
+
+	uc.sharedInterface = ifc
+
+	ifName, _ := ifc.Name()
+	event := uc.log.Info().
+		Str("device", ifName).
+		Int("MTU", uc.cfg.Tunnel.MTU).
+		Str("server_local_ip", ifc.IP.ServerLocal.String())
+	if ifc.IP.HasIPv6() {
+		event.Str("server_local_ip6", ifc.IP.ServerLocal6.String())
+	}
+	event.Msg("shared network interface created")
+
+	return nil
+}
+
+// addSharedInterfaceSession assigns the tunnel addresses to the session and adds them to the address table
+// of the shared interface. The interface of the session is named after the shared interface and the session ID.
+// It is called with the locked connMux.
+func (uc *ServerUseCase) addSharedInterfaceSession(sessionID uint32) (*ServerInterfaceInfo, error) {
+	sess := uc.sessions[sessionID]
+	ip, err := uc.ipam.Acquire(sess.UserName, sess.ClientID)
+	if err != nil {
+		return nil, err
+	}
+
+	ifName, _ := uc.sharedInterface.Name()
+	ic := &ServerInterfaceInfo{
+		name: fmt.Sprintf("%s:%d", ifName, sessionID),
+		handler: &entity.Interface{
+			Type:        uc.cfg.Tunnel.DeviceType,
+			IP:          ip,
+			Handler:     uc.sharedInterface.Handler,
+			MonotonicID: sess.MonotonicID,
+		},
+		Connections: make([]*entity.Connection, 0, uc.cfg.Network.MaxPorts()),
+		SessionID:   sessionID,
+		frames:      newFrameSequence(int64(uc.cfg.StreamMerger.StreamTTL)*2, sess.MonotonicID),
+	}
+
+	uc.interfaces[ic.name] = ic
+	sess.IfName = ic.name
+	for _, addr := range tunnelAddresses(ic.handler.IP) {
+		uc.addresses[addr] = ic
+	}
+
+	event := uc.log.Info().
+		Uint32("session_id", sessionID).
+		Str("device", ifName).
+		Str("client_local_ip", ic.handler.IP.ClientLocal.String())
+	if ic.handler.IP.HasIPv6() {
+		event.Str("client_local_ip6", ic.handler.IP.ClientLocal6.String())
+	}
+	event.Msg("session added to shared network interface")
+
+	return ic, nil
+}
+
+// tunnelAddresses returns the addresses of the client the packets of the session are sent to
+func tunnelAddresses(ip entity.IfIP) []netip.Addr {
+	addrs := make([]netip.Addr, 0, 2)
+	for _, v := range []net.IP{ip.ClientLocal, ip.ClientLocal6} {
+		if addr, ok := netip.AddrFromSlice(v); ok {
+			addrs = append(addrs, addr.Unmap())
+		}
+	}
+	return addrs
+}
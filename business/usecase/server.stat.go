@@ -14,6 +14,7 @@ func (uc *ServerUseCase) addSessionStat(sessionID uint32, stat *entity.Statistic
 			OutgoingBytes:  stat.OutgoingBytes,
 			IncomingFrames: stat.IncomingFrames,
 			OutgoingFrames: stat.OutgoingFrames,
+			ReplayedFrames: stat.ReplayedFrames,
 		},
 	}
 }
@@ -39,6 +40,7 @@ func (uc *ServerUseCase) sessionStat() {
 				stat[s.ID].OutgoingBytes += s.stat.OutgoingBytes
 				stat[s.ID].IncomingFrames += s.stat.IncomingFrames
 				stat[s.ID].OutgoingFrames += s.stat.OutgoingFrames
+				stat[s.ID].ReplayedFrames += s.stat.ReplayedFrames
 			case <-ticker.C:
 				uc.sessMux.Lock()
 				for id := range stat {
@@ -49,6 +51,7 @@ func (uc *ServerUseCase) sessionStat() {
 					uc.sessions[id].Stat.OutgoingBytes += stat[id].OutgoingBytes
 					uc.sessions[id].Stat.IncomingFrames += stat[id].IncomingFrames
 					uc.sessions[id].Stat.OutgoingFrames += stat[id].OutgoingFrames
+					uc.sessions[id].Stat.ReplayedFrames += stat[id].ReplayedFrames
 					uc.sessions[id].Stat.IncomingRateBytes = float64(stat[id].IncomingBytes) / interval
 					uc.sessions[id].Stat.OutgoingRateBytes = float64(stat[id].OutgoingBytes) / interval
 					uc.sessions[id].Stat.IncomingRateFrames = float64(stat[id].IncomingFrames) / interval
@@ -0,0 +1,107 @@
+package usecase
+
+import (
+	"net/netip"
+)
+
+// registerSubnets routes the subnets of a site-to-site client to the session and returns the accepted subnets.
+// A subnet is accepted if it is inside the subnets of the user and does not overlap the subnets of other sessions.
+// The subnets are registered once per session, the next connections of the session get the same subnets.
+func (uc *ServerUseCase) registerSubnets(ic *ServerInterfaceInfo, userName string, subnets []netip.Prefix) []netip.Prefix {
+	uc.connMux.Lock()
+	defer uc.connMux.Unlock()
+
+	if ic.subnets != nil {
+		return ic.subnets
+	}
+
+	user, ok := uc.users[userName]
+	accepted := make([]netip.Prefix, 0, len(subnets))
+
+	for _, subnet := range subnets {
+		if !ok || !user.CanRoute(subnet) {
+			uc.log.Warn().Uint32("session_id", ic.SessionID).Str("subnet", subnet.String()).Msg("subnet is not allowed for user")
+			continue
+		}
+		if other, exists := uc.findSubnet(subnet); exists {
+			uc.log.Warn().
+				Uint32("session_id", ic.SessionID).
+				Uint32("owner_session_id", other.SessionID).
+				Str("subnet", subnet.String()).
+				Msg("subnet is already routed to another session")
+			continue
+		}
+		accepted = append(accepted, subnet)
+	}
+
+	if len(accepted) != 0 {
+		if err := uc.iface.AddRoutes(ic.handler, accepted); err != nil {
+			uc.log.Error().Err(err).Uint32("session_id", ic.SessionID).Msg("failed to add subnet routes")
+			_ = uc.iface.DeleteRoutes(ic.handler, accepted)
+			accepted = accepted[:0]
+		}
+	}
+
+	for _, subnet := range accepted {
+		uc.subnets[subnet] = ic
+		uc.log.Info().Uint32("session_id", ic.SessionID).Str("subnet", subnet.String()).Msg("subnet routed to session")
+	}
+	ic.subnets = accepted
+
+	return accepted
+}
+
+// unregisterSubnets removes the routes of the subnets of the session, it is called with the locked connMux
+func (uc *ServerUseCase) unregisterSubnets(ic *ServerInterfaceInfo) {
+	if len(ic.subnets) == 0 {
+		return
+	}
+
+	if err := uc.iface.DeleteRoutes(ic.handler, ic.subnets); err != nil {
+		uc.log.Error().Err(err).Uint32("session_id", ic.SessionID).Msg("failed to delete subnet routes")
+	}
+	for _, subnet := range ic.subnets {
+		delete(uc.subnets, subnet)
+	}
+	ic.subnets = nil
+}
+
+// findSubnet returns the session of the subnet overlapping the subnet, it is called with the locked connMux
+func (uc *ServerUseCase) findSubnet(subnet netip.Prefix) (*ServerInterfaceInfo, bool) {
+	for p, ic := range uc.subnets {
+		if p.Overlaps(subnet) {
+			return ic, true
+		}
+	}
+	return nil, false
+}
+
+// lookupAddress returns the session of the tunnel address or of the most specific subnet containing the address,
+// it is called with the locked connMux
+func (uc *ServerUseCase) lookupAddress(addr netip.Addr) (*ServerInterfaceInfo, bool) {
+	if ic, ok := uc.addresses[addr]; ok {
+		return ic, true
+	}
+
+	var (
+		res  *ServerInterfaceInfo
+		bits = -1
+	)
+	for p, ic := range uc.subnets {
+		if p.Bits() > bits && p.Contains(addr) {
+			res, bits = ic, p.Bits()
+		}
+	}
+
+	return res, res != nil
+}
+
+// isSessionSource checks that the source address of a packet of the session is a tunnel address of the session
+// or inside a subnet registered by the session
+func (uc *ServerUseCase) isSessionSource(sessionID uint32, addr netip.Addr) bool {
+	uc.connMux.RLock()
+	defer uc.connMux.RUnlock()
+
+	ic, ok := uc.lookupAddress(addr.Unmap())
+	return ok && ic.SessionID == sessionID
+}
@@ -0,0 +1,190 @@
+package usecase
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// macAgeingTime is the time in seconds after which a MAC address not seen in the frames of its session is forgotten
+const macAgeingTime = 300
+
+type macEntry struct {
+	ic     *ServerInterfaceInfo
+	seenAt atomic.Int64
+}
+
+// switchInterfaceFrame sends the frame read from the TAP device to the session of the destination MAC address,
+// the broadcast, multicast and unknown unicast frames are sent to all sessions
+func (uc *ServerUseCase) switchInterfaceFrame(msg *entity.Message) {
+	if dst := msg.PacketInfo.DestinationMAC; !dst.IsMulticast() {
+		if ic, ok := uc.lookupMAC(dst); ok {
+			uc.sendFrame(msg, ic)
+			return
+		}
+	}
+
+	uc.floodFrame(msg, 0)
+}
+
+// switchSessionFrame learns the source MAC address of the frame received from the session. In the hub mode the frame
+// is sent to the session of the destination MAC address if the user of the session can reach it, the broadcast,
+// multicast and unknown unicast frames are sent to the reachable sessions and written to the TAP device.
+// It returns false if the frame has to be written to the TAP device.
+func (uc *ServerUseCase) switchSessionFrame(msg *entity.Message, sc *serverConn) bool {
+	if msg.PacketInfo == nil {
+		return false
+	}
+
+	uc.connMux.RLock()
+	src, ok := uc.interfaces[sc.ifName]
+	uc.connMux.RUnlock()
+	if !ok {
+		return false
+	}
+	if !uc.learnMAC(msg.PacketInfo.SourceMAC, src) {
+		return true
+	}
+
+	dst := msg.PacketInfo.DestinationMAC
+	ic, ok := uc.lookupMAC(dst)
+	if ok && ic == src {
+		return true
+	} else if !uc.cfg.Tunnel.UseHub() {
+		// the frames to other sessions are dropped, the device does not send them back
+		return ok
+	}
+
+	if dst.IsMulticast() || !ok {
+		uc.floodFrame(msg, src.SessionID)
+		return false
+	}
+
+	if !uc.isHubAllowed(src.SessionID, ic.SessionID) {
+		uc.log.Debug().
+			Uint32("session_id", src.SessionID).
+			Uint32("dst_session_id", ic.SessionID).
+			Str("dst", dst.String()).
+			Msg("frame to session is not allowed")
+		return true
+	}
+
+	uc.sendFrame(copyMessage(msg), ic)
+
+	return true
+}
+
+// floodFrame sends the copies of the frame to the sessions except the source session, the frames of a session
+// are sent to the sessions the user of the session can reach
+func (uc *ServerUseCase) floodFrame(msg *entity.Message, srcSessionID uint32) {
+	uc.connMux.RLock()
+	targets := make([]*ServerInterfaceInfo, 0, len(uc.interfaces))
+	for _, ic := range uc.interfaces {
+		if ic.SessionID != srcSessionID {
+			targets = append(targets, ic)
+		}
+	}
+	uc.connMux.RUnlock()
+
+	for _, ic := range targets {
+		if srcSessionID != 0 && !uc.isHubAllowed(srcSessionID, ic.SessionID) {
+			continue
+		}
+		uc.sendFrame(copyMessage(msg), ic)
+	}
+}
+
+// sendFrame sends the frame to the session with the next ID of the endpoint in the session
+func (uc *ServerUseCase) sendFrame(msg *entity.Message, ic *ServerInterfaceInfo) {
+	uc.connMux.RLock()
+	conn, err := uc.selectConnection(ic, msg.PacketInfo.Endpoint)
+	uc.connMux.RUnlock()
+
+	if err != nil {
+		uc.log.Debug().Err(err).Uint32("dst_session_id", ic.SessionID).Msg("failed to get session connections")
+		return
+	}
+
+	msg.ID = ic.frames.next(msg.PacketInfo.Endpoint)
+	msg.MonotonicID = ic.frames.monotonic
+
+	_ = uc.sendData(msg, conn)
+}
+
+// learnMAC assigns the unicast MAC address to the session. A MAC address seen in the frames of another session
+// within the ageing time is not moved, it returns false then and the frame is dropped, so a session cannot
+// take over the frames of another session.
+func (uc *ServerUseCase) learnMAC(addr entity.HardwareAddr, ic *ServerInterfaceInfo) bool {
+	if addr.IsMulticast() {
+		return true
+	}
+
+	now := time.Now().Unix()
+
+	uc.macMux.RLock()
+	e, ok := uc.macs[addr]
+	if ok && e.ic == ic {
+		e.seenAt.Store(now)
+	}
+	uc.macMux.RUnlock()
+	if ok && e.ic == ic {
+		return true
+	}
+
+	uc.macMux.Lock()
+	if e, ok = uc.macs[addr]; ok && e.ic != ic && now-e.seenAt.Load() < macAgeingTime {
+		uc.macMux.Unlock()
+		uc.log.Warn().
+			Uint32("session_id", ic.SessionID).
+			Uint32("owner_session_id", e.ic.SessionID).
+			Str("mac", addr.String()).
+			Msg("frame with MAC address of another session dropped")
+		return false
+	}
+	e = &macEntry{ic: ic}
+	e.seenAt.Store(now)
+	uc.macs[addr] = e
+	uc.macMux.Unlock()
+
+	uc.log.Debug().Uint32("session_id", ic.SessionID).Str("mac", addr.String()).Msg("MAC address learned")
+
+	return true
+}
+
+// lookupMAC returns the session of the MAC address seen within the ageing time
+func (uc *ServerUseCase) lookupMAC(addr entity.HardwareAddr) (*ServerInterfaceInfo, bool) {
+	uc.macMux.RLock()
+	defer uc.macMux.RUnlock()
+
+	if e, ok := uc.macs[addr]; ok && time.Now().Unix()-e.seenAt.Load() < macAgeingTime {
+		return e.ic, true
+	}
+	return nil, false
+}
+
+// forgetMACs removes the MAC addresses of the session and the expired MAC addresses
+func (uc *ServerUseCase) forgetMACs(ic *ServerInterfaceInfo) {
+	now := time.Now().Unix()
+
+	uc.macMux.Lock()
+	defer uc.macMux.Unlock()
+
+	for addr, e := range uc.macs {
+		if e.ic == ic || now-e.seenAt.Load() >= macAgeingTime {
+			delete(uc.macs, addr)
+		}
+	}
+}
+
+// copyMessage returns a copy of the packet for another session, the received message is not reused,
+// the sent message is returned to the pool after acknowledgement
+func copyMessage(msg *entity.Message) *entity.Message {
+	payload := msg.Payload.([]byte)
+	c := entity.MessagePool.Get(len(payload))
+	copy(c.Payload.([]byte), payload)
+	c.PayloadLength = uint16(len(payload))
+	c.PacketInfo = msg.PacketInfo
+
+	return c
+}
@@ -15,7 +15,7 @@ type MockNetworkServer struct {
 	primaryEncryptor entity.Encryptor
 }
 
-func (*MockNetworkServer) Run(host string, port int, proto entity.Protocol) error {
+func (*MockNetworkServer) Run(host string, port uint16, proto entity.Protocol) error {
 	return nil
 }
 
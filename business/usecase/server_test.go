@@ -3,13 +3,17 @@ package usecase
 import (
 	"context"
 	"net"
+	"net/netip"
 	"runtime"
+	"slices"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/forest33/tapir/adapter/ipam"
 	"github.com/forest33/tapir/adapter/merger"
 	"github.com/forest33/tapir/adapter/packet"
+	"github.com/forest33/tapir/adapter/retry"
 	"github.com/forest33/tapir/adapter/wiface"
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/command"
@@ -39,6 +43,7 @@ var (
 	ifaceAdapter  entity.InterfaceAdapter
 	serverAdapter entity.NetworkServer
 	mergerAdapter entity.StreamMerger
+	ipamAdapter   entity.AddressManager
 	uc            *ServerUseCase
 
 	wg *sync.WaitGroup
@@ -103,7 +108,17 @@ func init() {
 		zlog.Fatalf("failed to create stream merger: %v", err)
 	}
 
-	uc, err = NewServerUseCase(ctx, zlog, cfg, cfgHandler, mergerAdapter, serverAdapter, ifaceAdapter)
+	ipamAdapter, err = ipam.New(zlog, &ipam.Config{
+		AddrMin:  cfg.Tunnel.AddrMin,
+		AddrMax:  cfg.Tunnel.AddrMax,
+		AddrMin6: cfg.Tunnel.AddrMin6,
+		AddrMax6: cfg.Tunnel.AddrMax6,
+	})
+	if err != nil {
+		zlog.Fatalf("failed to create address manager: %v", err)
+	}
+
+	uc, err = NewServerUseCase(ctx, zlog, cfg, cfgHandler, mergerAdapter, serverAdapter, ifaceAdapter, ipamAdapter)
 	if err != nil {
 		zlog.Fatalf("failed to create usecase: %v", err)
 	}
@@ -129,7 +144,7 @@ func TestReceiveFromInterface(t *testing.T) {
 		UDPConn:   udpConn,
 		Addr:      addr,
 		Port:      33333,
-		SessionID: uc.createSession(clientID, userName),
+		SessionID: uc.createSession(clientID, userName, false),
 	}
 
 	if uc.cfg.Network.UseStreamMerger() {
@@ -138,7 +153,7 @@ func TestReceiveFromInterface(t *testing.T) {
 		}
 	}
 
-	ic, err := uc.createInterface(conn.SessionID)
+	ic, err := uc.createInterface(conn.SessionID, 0)
 	if err != nil {
 		uc.log.Fatalf("failed to create network interface: %v", err)
 	}
@@ -171,3 +186,372 @@ func TestReceiveFromInterface(t *testing.T) {
 	endStat := test.MemUsage()
 	zlog.Info().Uint64("mallocs", endStat.Mallocs-startStat.Mallocs).Uint64("frees", endStat.Frees-startStat.Frees).Msg("heap stats")
 }
+
+func TestUplinksSamePort(t *testing.T) {
+	const (
+		sessionID = 1
+		port      = 1977
+		ifName    = "tun-uplinks"
+	)
+
+	suc := &ServerUseCase{
+		log:         zlog,
+		cfg:         &entity.ServerConfig{Tunnel: &entity.TunnelConfig{Encryption: entity.EncryptionAES256GCM}},
+		connections: make(map[entity.ConnectionKey]*serverConn),
+		interfaces:  map[string]*ServerInterfaceInfo{ifName: {SessionID: sessionID}},
+	}
+	ic := suc.interfaces[ifName]
+
+	newConn := func(addr string) *entity.Connection {
+		return &entity.Connection{
+			UDPConn:   &net.UDPConn{},
+			Addr:      net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr)),
+			Port:      port,
+			Proto:     entity.ProtoUDP,
+			SessionID: sessionID,
+		}
+	}
+	addPath := func(conn *entity.Connection, uplink uint8) *serverConn {
+		sc := &serverConn{ifName: ifName, sessionID: sessionID, port: port, uplink: uplink, protocol: entity.ProtoUDP}
+		suc.addConnection(conn, sc)
+		suc.setConnectionEncryptor(conn, GetEncryptor(sharedKey, "aes-256-ecb"))
+		if err := suc.addInterfaceConnection(sc, conn); err != nil {
+			t.Fatalf("failed to add interface connection: %v", err)
+		}
+		return sc
+	}
+
+	connA, connB := newConn("192.0.2.1:40000"), newConn("198.51.100.1:40000")
+	scA, scB := addPath(connA, 1), addPath(connB, 2)
+
+	if len(suc.connections) != 2 || len(ic.Connections) != 2 {
+		t.Fatalf("expected 2 paths, got %d connections and %d interface connections", len(suc.connections), len(ic.Connections))
+	}
+	for _, c := range []struct {
+		conn *entity.Connection
+		sc   *serverConn
+	}{{connA, scA}, {connB, scB}} {
+		if sc, ok := suc.getConnection(c.conn); !ok || sc != c.sc {
+			t.Fatalf("path of %s not found", c.conn.Addr)
+		}
+	}
+
+	// the uplink A is not seen since its NAT binding changed, the live uplink B must be kept
+	scA.lastSeen.Store(time.Now().Unix() - 10)
+	roamed := newConn("192.0.2.1:50000")
+	if sc, ok := suc.roamConnection(roamed); !ok || sc != scA {
+		t.Fatalf("expected the path of the uplink A to roam")
+	}
+
+	if len(suc.connections) != 2 || len(ic.Connections) != 2 {
+		t.Fatalf("expected 2 paths after roaming, got %d connections and %d interface connections", len(suc.connections), len(ic.Connections))
+	}
+	if _, ok := suc.getConnection(connA); ok {
+		t.Fatalf("old path of the uplink A is kept")
+	}
+	if sc, ok := suc.getConnection(roamed); !ok || sc != scA {
+		t.Fatalf("roamed path of the uplink A not found")
+	}
+	if sc, ok := suc.getConnection(connB); !ok || sc != scB {
+		t.Fatalf("path of the uplink B is lost")
+	}
+	if !slices.ContainsFunc(ic.Connections, func(c *entity.Connection) bool { return c == connB }) ||
+		!slices.ContainsFunc(ic.Connections, func(c *entity.Connection) bool { return c == roamed }) {
+		t.Fatalf("interface connections are not updated")
+	}
+}
+
+func TestUplinkReauthentication(t *testing.T) {
+	const (
+		sessionID = 1
+		port      = 1977
+		ifName    = "tun-reauth"
+	)
+
+	suc := &ServerUseCase{
+		log:         zlog,
+		cfg:         &entity.ServerConfig{Tunnel: &entity.TunnelConfig{Encryption: entity.EncryptionAES256GCM}},
+		connections: make(map[entity.ConnectionKey]*serverConn),
+		interfaces:  map[string]*ServerInterfaceInfo{ifName: {SessionID: sessionID}},
+	}
+	ic := suc.interfaces[ifName]
+
+	newConn := func(addr string) *entity.Connection {
+		conn := &entity.Connection{
+			UDPConn:   &net.UDPConn{},
+			Addr:      net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr)),
+			Port:      port,
+			Proto:     entity.ProtoUDP,
+			SessionID: sessionID,
+		}
+		conn.Retry = retry.New(context.Background(), zlog, &retry.Config{
+			KeepaliveTimeout:  time.Hour,
+			KeepaliveInterval: time.Hour,
+			KeepaliveProbes:   1,
+		}, nil, nil, nil, conn)
+		return conn
+	}
+	addPath := func(conn *entity.Connection, uplink uint8) *serverConn {
+		sc := &serverConn{ifName: ifName, sessionID: sessionID, port: port, uplink: uplink, protocol: entity.ProtoUDP}
+		suc.addConnection(conn, sc)
+		if err := suc.addInterfaceConnection(sc, conn); err != nil {
+			t.Fatalf("failed to add interface connection: %v", err)
+		}
+		return sc
+	}
+
+	connA, connB := newConn("192.0.2.1:40000"), newConn("198.51.100.1:40000")
+	addPath(connA, 1)
+	scB := addPath(connB, 2)
+
+	// the uplink A is reconnected and authenticates from the new address
+	reauth := newConn("192.0.2.1:50000")
+	scA := addPath(reauth, 1)
+
+	if len(suc.connections) != 2 || len(ic.Connections) != 2 {
+		t.Fatalf("expected 2 paths, got %d connections and %d interface connections", len(suc.connections), len(ic.Connections))
+	}
+	if _, ok := suc.getConnection(connA); ok {
+		t.Fatalf("previous path of the uplink A is kept")
+	}
+	if slices.Contains(ic.Connections, connA) {
+		t.Fatalf("previous path of the uplink A is kept in the interface connections")
+	}
+	if !connA.Retry.IsStopped() {
+		t.Fatalf("retry of the previous path of the uplink A is not stopped")
+	}
+	if sc, ok := suc.getConnection(reauth); !ok || sc != scA {
+		t.Fatalf("new path of the uplink A not found")
+	}
+	if sc, ok := suc.getConnection(connB); !ok || sc != scB || connB.Retry.IsStopped() {
+		t.Fatalf("path of the uplink B is lost")
+	}
+}
+
+func TestSessionSource(t *testing.T) {
+	own := &ServerInterfaceInfo{SessionID: 1}
+	other := &ServerInterfaceInfo{SessionID: 2}
+	suc := &ServerUseCase{
+		addresses: map[netip.Addr]*ServerInterfaceInfo{
+			netip.MustParseAddr("10.10.0.2"): own,
+			netip.MustParseAddr("fd00::2"):   own,
+			netip.MustParseAddr("10.10.0.3"): other,
+		},
+		subnets: map[netip.Prefix]*ServerInterfaceInfo{
+			netip.MustParsePrefix("192.168.10.0/24"): own,
+			netip.MustParsePrefix("192.168.20.0/24"): other,
+		},
+	}
+
+	for _, c := range []struct {
+		addr string
+		ok   bool
+	}{
+		{"10.10.0.2", true},
+		{"fd00::2", true},
+		{"::ffff:10.10.0.2", true},
+		{"192.168.10.15", true},
+		{"10.10.0.3", false},
+		{"192.168.20.15", false},
+		{"172.16.0.1", false},
+	} {
+		if ok := suc.isSessionSource(own.SessionID, netip.MustParseAddr(c.addr)); ok != c.ok {
+			t.Fatalf("source %s: expected %v, got %v", c.addr, c.ok, ok)
+		}
+	}
+}
+
+func TestFrameSequence(t *testing.T) {
+	const a, b entity.PacketEndpoint = 1, 2
+
+	for _, monotonic := range []bool{false, true} {
+		s := newFrameSequence(60, monotonic)
+		for i := uint32(1); i <= 3; i++ {
+			if id := s.next(a); id != i {
+				t.Fatalf("monotonic %v: expected ID %d of endpoint a, got %d", monotonic, i, id)
+			}
+		}
+
+		// a new endpoint starts at 1 unless the IDs are monotonic
+		if id, want := s.next(b), structs.If[uint32](monotonic, 4, 1); id != want {
+			t.Fatalf("monotonic %v: expected first ID %d of endpoint b, got %d", monotonic, want, id)
+		}
+
+		// an idle endpoint is restarted
+		s.ids[a].ts -= 61
+		if id, want := s.next(a), structs.If[uint32](monotonic, 5, 1); id != want {
+			t.Fatalf("monotonic %v: expected ID %d of idle endpoint a, got %d", monotonic, want, id)
+		}
+	}
+}
+
+type capturingNetworkServer struct {
+	MockNetworkServer
+	sent []*entity.Message
+}
+
+func (s *capturingNetworkServer) Send(msg *entity.Message, _ *entity.Connection) error {
+	s.sent = append(s.sent, msg)
+	return nil
+}
+
+// newHubUseCase returns the use case of a hub server with the per-session interfaces of the sessions
+// by their tunnel addresses, every session has a single UDP path
+func newHubUseCase(srv entity.NetworkServer, sessions map[uint32]string) *ServerUseCase {
+	suc := &ServerUseCase{
+		log:                   zlog,
+		cfg:                   &entity.ServerConfig{Tunnel: &entity.TunnelConfig{Hub: structs.Ref(true)}},
+		srv:                   srv,
+		users:                 map[string]*entity.User{userName: {Name: userName, Peers: []string{"*"}}},
+		connections:           make(map[entity.ConnectionKey]*serverConn),
+		sessions:              make(map[uint32]*ServerSessionInfo),
+		addresses:             make(map[netip.Addr]*ServerInterfaceInfo),
+		statCh:                make(chan *sessionStatisticRequest, 100),
+		portSelectionStrategy: entity.PortSelectionStrategyHash,
+	}
+
+	for sessionID, addr := range sessions {
+		conn := &entity.Connection{
+			UDPConn:   &net.UDPConn{},
+			Addr:      &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(sessionID)), Port: 40000},
+			Port:      1977,
+			Proto:     entity.ProtoUDP,
+			SessionID: sessionID,
+		}
+		suc.sessions[sessionID] = &ServerSessionInfo{UserName: userName}
+		suc.connections[conn.Key()] = &serverConn{sessionID: sessionID}
+		suc.addresses[netip.MustParseAddr(addr)] = &ServerInterfaceInfo{
+			SessionID:   sessionID,
+			Connections: []*entity.Connection{conn},
+			frames:      newFrameSequence(60, true),
+		}
+	}
+
+	return suc
+}
+
+func hubPacket(t *testing.T, decoder *packet.Decoder, id uint32, src, dst string) *entity.Message {
+	payload := make([]byte, packet.IpPacketMinLength)
+	payload[0] = 0x45
+	payload[3] = packet.IpPacketMinLength
+	payload[9] = byte(entity.IPProtocolUDP)
+	copy(payload[12:16], netip.MustParseAddr(src).AsSlice())
+	copy(payload[16:20], netip.MustParseAddr(dst).AsSlice())
+
+	info, err := decoder.Decode(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &entity.Message{ID: id, Payload: payload, PayloadLength: uint16(len(payload)), PacketInfo: info}
+}
+
+func TestHubForwardSequence(t *testing.T) {
+	const messageCount = 10
+
+	// the sessions 1 and 3 send packets to the session 2
+	srv := &capturingNetworkServer{}
+	suc := newHubUseCase(srv, map[uint32]string{1: "10.10.0.2", 2: "10.10.0.6", 3: "10.10.0.10"})
+
+	var (
+		sockDecoder   = packet.New(&packet.Config{EndpointHashType: packet.EndpointHashDestinationAddress})
+		clientDecoder = packet.New(&packet.Config{EndpointHashType: packet.EndpointHashSourceAddress})
+	)
+
+	for id := uint32(1); id <= messageCount; id++ {
+		for sessionID, src := range map[uint32]string{1: "10.10.0.2", 3: "10.10.0.10"} {
+			if !suc.hubForward(hubPacket(t, sockDecoder, id, src, "10.10.0.6"), &serverConn{sessionID: sessionID}) {
+				t.Fatalf("packet of session %d is not forwarded", sessionID)
+			}
+		}
+	}
+
+	if len(srv.sent) != messageCount*2 {
+		t.Fatalf("expected %d forwarded packets, got %d", messageCount*2, len(srv.sent))
+	}
+
+	// the destination client orders the packets of every source by its own endpoints,
+	// the retries of the server and the acknowledgements of the client are keyed by the endpoint and the ID
+	var (
+		filter = entity.NewReplayFilter(64, 60)
+		lastID = make(map[entity.PacketEndpoint]uint32)
+		sent   = make(map[[2]uint64]bool)
+	)
+	for _, msg := range srv.sent {
+		key := [2]uint64{msg.GetEndpoint().Uint64(), uint64(msg.ID)}
+		if sent[key] {
+			t.Fatalf("packet ID %d of endpoint %d is sent twice", msg.ID, msg.GetEndpoint())
+		}
+		sent[key] = true
+
+		info, err := clientDecoder.Decode(msg.Payload.([]byte)[:msg.PayloadLength])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.SessionID != 2 || msg.GetEndpoint() != info.Endpoint {
+			t.Fatalf("wrong session %d or endpoint %d of forwarded packet", msg.SessionID, msg.GetEndpoint())
+		}
+		if last, ok := lastID[info.Endpoint]; ok && msg.ID != last+1 {
+			t.Fatalf("packet ID %d does not follow %d", msg.ID, last)
+		}
+		lastID[info.Endpoint] = msg.ID
+		if !filter.Check(msg.SessionID, info.Endpoint, msg.ID, msg.MonotonicID) {
+			t.Fatalf("forwarded packet %d is dropped as replayed", msg.ID)
+		}
+	}
+	if len(lastID) != 2 {
+		t.Fatalf("expected 2 endpoints, got %d", len(lastID))
+	}
+}
+
+func TestHubSpoofedSource(t *testing.T) {
+	srv := &capturingNetworkServer{}
+	suc := newHubUseCase(srv, map[uint32]string{1: "10.10.0.2", 2: "10.10.0.6", 3: "10.10.0.10"})
+	decoder := packet.New(&packet.Config{EndpointHashType: packet.EndpointHashDestinationAddress})
+	conn := suc.addresses[netip.MustParseAddr("10.10.0.2")].Connections[0]
+
+	// the session 1 sends a packet on behalf of the session 3
+	if _, err := suc.commandData(hubPacket(t, decoder, 1, "10.10.0.10", "10.10.0.6"), conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.sent) != 0 {
+		t.Fatal("packet with a source address of another session is forwarded")
+	}
+
+	if _, err := suc.commandData(hubPacket(t, decoder, 2, "10.10.0.2", "10.10.0.6"), conn); err != nil {
+		t.Fatal(err)
+	}
+	if len(srv.sent) != 1 || srv.sent[0].SessionID != 2 {
+		t.Fatal("packet with the source address of the session is not forwarded")
+	}
+}
+
+func TestLearnMACConflict(t *testing.T) {
+	var (
+		suc = &ServerUseCase{log: zlog, macs: make(map[entity.HardwareAddr]*macEntry)}
+		a   = &ServerInterfaceInfo{SessionID: 1}
+		b   = &ServerInterfaceInfo{SessionID: 2}
+		mac = entity.HardwareAddr{0x02, 0, 0, 0, 0, 0x01}
+	)
+
+	if !suc.learnMAC(mac, a) || !suc.learnMAC(mac, a) {
+		t.Fatal("MAC address of the session is not learned")
+	}
+
+	// the second session claims the MAC address learned by the first one
+	if suc.learnMAC(mac, b) {
+		t.Fatal("MAC address of another session is moved")
+	}
+	if ic, ok := suc.lookupMAC(mac); !ok || ic != a {
+		t.Fatal("MAC address is not owned by the first session")
+	}
+
+	// an expired MAC address is moved to the new session
+	suc.macs[mac].seenAt.Add(-macAgeingTime)
+	if !suc.learnMAC(mac, b) {
+		t.Fatal("expired MAC address is not moved")
+	}
+	if ic, ok := suc.lookupMAC(mac); !ok || ic != b {
+		t.Fatal("MAC address is not owned by the second session")
+	}
+}
@@ -0,0 +1,158 @@
+package usecase
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const (
+	handshakeSignatureContext      = "tapir handshake signature"
+	authenticationSignatureContext = "tapir authentication"
+	authenticationChallengeSize    = 32
+)
+
+// GenerateSigningKey creates a new long-term signing key of the server
+func GenerateSigningKey() (string, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(privateKey.Seed()), nil
+}
+
+// GetSigningPublicKey returns the public key of the signing key, which is pinned by clients
+func GetSigningPublicKey(signingKey string) (string, error) {
+	privateKey, err := parseSigningKey(signingKey)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(privateKey.Public().(ed25519.PublicKey)), nil
+}
+
+func parseSigningKey(signingKey string) (ed25519.PrivateKey, error) {
+	if signingKey == "" {
+		return nil, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(signingKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode signing key")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("wrong signing key size")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// GenerateClientKey creates a new key pair of the client for the public key authentication
+func GenerateClientKey() (privateKey string, publicKey string, err error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return base64.StdEncoding.EncodeToString(priv.Seed()), base64.StdEncoding.EncodeToString(pub), nil
+}
+
+func parseClientKey(privateKey string) (ed25519.PrivateKey, error) {
+	if privateKey == "" {
+		return nil, nil
+	}
+
+	seed, err := base64.StdEncoding.DecodeString(privateKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode client private key")
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("wrong client private key size")
+	}
+
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+func parseServerPublicKey(publicKey string) (ed25519.PublicKey, error) {
+	if publicKey == "" {
+		return nil, nil
+	}
+
+	key, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to decode server public key")
+	}
+	if len(key) != ed25519.PublicKeySize {
+		return nil, errors.New("wrong server public key size")
+	}
+
+	return key, nil
+}
+
+// getHandshakeTranscript returns the data signed by the server,
+// it binds the ephemeral keys of both peers to the session and the negotiated protocol version.
+// The ML-KEM keys are appended only in the hybrid key exchange.
+func getHandshakeTranscript(hk *handshakeKeys) []byte {
+	data := make([]byte, 0, len(handshakeSignatureContext)+len(hk.clientID)+len(hk.clientKey)+len(hk.serverKey)+len(hk.clientKEM)+len(hk.serverKEM)+5)
+	data = append(data, handshakeSignatureContext...)
+	data = append(data, byte(hk.version.Get()))
+	data = binary.BigEndian.AppendUint32(data, hk.sessionID)
+	data = append(data, hk.clientID...)
+	data = append(data, hk.clientKey...)
+	data = append(data, hk.serverKey...)
+	data = append(data, hk.clientKEM...)
+	data = append(data, hk.serverKEM...)
+	return data
+}
+
+func signHandshake(signingKey ed25519.PrivateKey, hk *handshakeKeys) []byte {
+	if signingKey == nil {
+		return nil
+	}
+	return ed25519.Sign(signingKey, getHandshakeTranscript(hk))
+}
+
+func verifyHandshake(publicKey ed25519.PublicKey, hk *handshakeKeys, signature []byte) error {
+	if publicKey == nil {
+		return nil
+	}
+	if len(signature) != ed25519.SignatureSize || !ed25519.Verify(publicKey, getHandshakeTranscript(hk), signature) {
+		return entity.ErrServerVerificationFailed
+	}
+	return nil
+}
+
+// getAuthenticationTranscript returns the data signed by the client,
+// it binds the server challenge to the client identity and the public key.
+func getAuthenticationTranscript(req *entity.MessageAuthenticationRequest, challenge []byte) []byte {
+	data := make([]byte, 0, len(authenticationSignatureContext)+len(challenge)+len(req.ClientID)+len(req.Name)+len(req.PublicKey))
+	data = append(data, authenticationSignatureContext...)
+	data = append(data, challenge...)
+	data = append(data, req.ClientID...)
+	data = append(data, req.Name...)
+	data = append(data, req.PublicKey...)
+	return data
+}
+
+func signAuthentication(privateKey ed25519.PrivateKey, req *entity.MessageAuthenticationRequest, challenge []byte) []byte {
+	return ed25519.Sign(privateKey, getAuthenticationTranscript(req, challenge))
+}
+
+func verifyAuthentication(req *entity.MessageAuthenticationRequest, challenge []byte) error {
+	if len(req.PublicKey) != ed25519.PublicKeySize || len(req.Signature) != ed25519.SignatureSize ||
+		!ed25519.Verify(req.PublicKey, getAuthenticationTranscript(req, challenge), req.Signature) {
+		return entity.ErrUnauthorized
+	}
+	return nil
+}
+
+func newAuthenticationChallenge() ([]byte, error) {
+	challenge := make([]byte, authenticationChallengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+	return challenge, nil
+}
@@ -2,9 +2,10 @@ package usecase
 
 import (
 	"crypto/ecdh"
-	"encoding/binary"
-	"net"
 	"strings"
+	"time"
+
+	"github.com/pkg/errors"
 
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/codec"
@@ -20,16 +21,28 @@ type configHandler interface {
 
 var fakeKey = strings.Repeat("0", 32)
 
-func int2ip(nn uint32) net.IP {
-	ip := make(net.IP, 4)
-	binary.BigEndian.PutUint32(ip, nn)
-	return ip
-}
-
 func GetECDHCurve() ecdh.Curve {
 	return ecdh.X25519()
 }
 
+func getSharedKey(privateKey *ecdh.PrivateKey, key []byte) ([]byte, error) {
+	publicKey, err := GetECDHCurve().NewPublicKey(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to check public key")
+	}
+
+	shared, err := privateKey.ECDH(publicKey)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to create shared key")
+	}
+
+	return shared, nil
+}
+
+func getRekeyGracePeriod(c *entity.TunnelConfig) time.Duration {
+	return time.Duration(c.RekeyGracePeriod) * time.Second
+}
+
 func GetCodec(log *logger.Logger, payloadSize int, encryptorMethod entity.EncryptorMethod, obfuscateData *bool) codec.Codec {
 	return codec.NewTapirCodec(log, &codec.Config{
 		HeaderSize:    entity.HeaderSize,
@@ -39,6 +52,10 @@ func GetCodec(log *logger.Logger, payloadSize int, encryptorMethod entity.Encryp
 	})
 }
 
+func GetHeaderProtection(key string, method entity.EncryptorMethod, mode string) *codec.HeaderProtection {
+	return codec.NewHeaderProtection(key, GetEncryptor(key, method), entity.GetHeaderProtectionMode(mode))
+}
+
 func GetMaxAcknowledgementSize(c *entity.TunnelConfig) int {
-	return c.MTU - GetEncryptor(fakeKey, c.Encryption).GetLength(entity.HeaderSize)
+	return c.MTU - codec.NewHeaderProtection(fakeKey, GetEncryptor(fakeKey, c.Encryption), entity.HeaderProtectionCompat).MaxLength()
 }
@@ -2,6 +2,7 @@ package main
 
 import (
 	"context"
+	"net"
 	"time"
 
 	"github.com/pkg/errors"
@@ -44,16 +45,28 @@ func createClientConnection(clientConn *entity.ClientConnection, statHandler ent
 	sockPacketDecoder := packet.New(&packet.Config{
 		Tracing:          cfg.Tracing.Socket,
 		EndpointHashType: packet.EndpointHashSourceAddress,
+		Ethernet:         clientConn.Tunnel.IsTAP(),
 	})
 
+	// the server host is resolved once, the client connects only to these addresses
+	serverIPs, err := net.LookupIP(clientConn.Server.Host)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to resolve server address")
+	}
+
 	clientAdapter, err = client.New(ctx, zlog, &client.Config{
 		Codec:             usecase.GetCodec(zlog, clientConn.Tunnel.MTU, clientConn.Tunnel.Encryption, clientConn.Server.ObfuscateData),
 		PrimaryEncryptor:  usecase.GetEncryptor(clientConn.Authentication.Key, clientConn.Tunnel.Encryption),
+		HeaderProtection:  usecase.GetHeaderProtection(clientConn.Authentication.Key, clientConn.Tunnel.Encryption, clientConn.Server.HeaderProtection),
 		MTU:               clientConn.Tunnel.MTU,
 		WriteBufferSize:   clientConn.Server.WriteBufferSize,
 		ReadBufferSize:    clientConn.Server.ReadBufferSize,
 		MultipathTCP:      *clientConn.Server.MultipathTCP,
 		KeepaliveInterval: time.Duration(clientConn.Server.KeepaliveInterval) * time.Second,
+		AddressFamily:     entity.GetAddressFamily(clientConn.Server.AddressFamily),
+		ServerIPs:         serverIPs,
+		ReplayWindowSize:  clientConn.Server.ReplayWindowSize,
+		ReplayWindowTTL:   int64(cfg.StreamMerger.StreamTTL),
 		SocketTracing:     cfg.Tracing.Socket,
 	}, retryFactory, ackFactory, sockPacketDecoder)
 	if err != nil {
@@ -63,13 +76,16 @@ func createClientConnection(clientConn *entity.ClientConnection, statHandler ent
 	ifacePacketDecoder := packet.New(&packet.Config{
 		Tracing:          cfg.Tracing.Interface,
 		EndpointHashType: packet.EndpointHashDestinationAddress,
+		Ethernet:         clientConn.Tunnel.IsTAP(),
 	})
 
 	ifaceAdapter, err = wiface.New(zlog, &wiface.Config{
-		Tunnel:      clientConn.Tunnel,
-		Tracing:     cfg.Tracing.Interface,
-		EndpointTTL: int64(cfg.StreamMerger.StreamTTL) * 2,
-		ServerHost:  clientConn.Server.Host,
+		Tunnel:        clientConn.Tunnel,
+		Tracing:       cfg.Tracing.Interface,
+		EndpointTTL:   int64(cfg.StreamMerger.StreamTTL) * 2,
+		ServerIPs:     serverIPs,
+		ServerPortMin: clientConn.Server.PortMin,
+		ServerPortMax: clientConn.Server.PortMax,
 	}, cmd, ifacePacketDecoder)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create network interface manager")
@@ -11,15 +11,20 @@ import (
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/business/usecase"
 	"github.com/forest33/tapir/pkg/structs"
+	"github.com/forest33/tapir/pkg/util/password"
 )
 
 const (
-	commandInit   = "init"
-	commandCreate = "create"
-	commandExport = "export"
-	commandHelp   = "help"
+	commandInit    = "init"
+	commandCreate  = "create"
+	commandExport  = "export"
+	commandMigrate = "migrate"
+	commandHelp    = "help"
 )
 
+// generatedPasswordLength is the length of the password issued on export when the password is empty
+const generatedPasswordLength = 16
+
 type commandData struct {
 	serverHost       string
 	serverKey        string
@@ -28,6 +33,7 @@ type commandData struct {
 	password         string
 	compression      string
 	compressionLevel int
+	keyPair          bool
 }
 
 func parseCommandLine() {
@@ -39,9 +45,10 @@ func parseCommandLine() {
 	)
 
 	commandHandlers := map[string]func(*commandData){
-		commandInit:   handlerInit,
-		commandCreate: handlerCreate,
-		commandExport: handlerExport,
+		commandInit:    handlerInit,
+		commandCreate:  handlerCreate,
+		commandExport:  handlerExport,
+		commandMigrate: handlerMigrate,
 	}
 
 	switch command {
@@ -49,7 +56,7 @@ func parseCommandLine() {
 		fs = flag.NewFlagSet(commandInit, flag.ExitOnError)
 		fs.StringVar(&data.serverHost, "host", "", "server hostname or IP address")
 		fs.StringVar(&data.serverKey, "key", "", "server encryption key")
-		fs.StringVar(&data.encryption, "encryption", "aes-256-ecb", "encryption algorithm (none, aes-256-ecb, aes-256-gcm)")
+		fs.StringVar(&data.encryption, "encryption", "aes-256-ecb", "encryption algorithm (none, aes-256-ecb, aes-256-gcm, chacha20-poly1305, xchacha20-poly1305)")
 	case commandCreate:
 		fs = flag.NewFlagSet(commandCreate, flag.ExitOnError)
 		fs.StringVar(&data.name, "name", "", "user name")
@@ -59,6 +66,10 @@ func parseCommandLine() {
 	case commandExport:
 		fs = flag.NewFlagSet(commandExport, flag.ExitOnError)
 		fs.StringVar(&data.name, "name", "", "user name")
+		fs.StringVar(&data.password, "password", "", "user password, a new password is issued if empty")
+		fs.BoolVar(&data.keyPair, "keypair", false, "issue a new key pair for public key authentication instead of the password")
+	case commandMigrate:
+		fs = flag.NewFlagSet(commandMigrate, flag.ExitOnError)
 	case commandHelp:
 		printHelp()
 		os.Exit(0)
@@ -91,6 +102,14 @@ func handlerInit(data *commandData) {
 		cfg.Authentication.Key = entity.GetRandomString(cfg.Tunnel.Encryption.KeySize())
 	}
 
+	if cfg.Authentication.SigningKey == "" {
+		key, err := usecase.GenerateSigningKey()
+		if err != nil {
+			zlog.Fatalf("failed to generate signing key: %v", err)
+		}
+		cfg.Authentication.SigningKey = key
+	}
+
 	cfg.System.Shell = structs.If(cfg.System.Shell == "", entity.GetSystemShell(), cfg.System.Shell)
 
 	cfgHandler.Update(cfg)
@@ -110,9 +129,14 @@ func handlerCreate(data *commandData) {
 		zlog.Fatalf("user already exists")
 	}
 
+	hash, err := password.Hash(data.password)
+	if err != nil {
+		zlog.Fatalf("failed to hash password: %v", err)
+	}
+
 	cfg.Users = append(cfg.Users, &entity.User{
 		Name:     data.name,
-		Password: data.password,
+		Password: hash,
 	})
 
 	cfgHandler.Update(cfg)
@@ -129,22 +153,67 @@ func handlerExport(data *commandData) {
 		user = cfg.Users[idx]
 	}
 
+	var privateKey string
+	if data.keyPair {
+		var (
+			publicKey string
+			err       error
+		)
+		privateKey, publicKey, err = usecase.GenerateClientKey()
+		if err != nil {
+			zlog.Fatalf("failed to generate key pair: %v", err)
+		}
+		user.PublicKeys = append(user.PublicKeys, publicKey)
+		data.password = ""
+		cfgHandler.Update(cfg)
+		cfgHandler.Save()
+		fmt.Fprintf(os.Stderr, "a new key pair is issued for user %s\n", user.Name)
+	} else if data.password == "" {
+		data.password = entity.GetRandomString(generatedPasswordLength)
+		hash, err := password.Hash(data.password)
+		if err != nil {
+			zlog.Fatalf("failed to hash password: %v", err)
+		}
+		user.Password = hash
+		cfgHandler.Update(cfg)
+		cfgHandler.Save()
+		fmt.Fprintf(os.Stderr, "a new password is issued for user %s, the previous one is no longer valid\n", user.Name)
+	} else if !password.Verify(user.Password, data.password) {
+		zlog.Fatalf("wrong password")
+	}
+
 	conn := &entity.ClientConnection{
 		Name:           fmt.Sprintf("%s [%s]", cfg.ServerHost, user.Name),
 		Server:         cfg.Network.Clone(),
 		Authentication: cfg.Authentication.Clone(),
 		User: &entity.User{
-			Name:     user.Name,
-			Password: user.Password,
+			Name:       user.Name,
+			Password:   data.password,
+			PrivateKey: privateKey,
 		},
 		Tunnel: cfg.Tunnel.Clone(),
 	}
 
 	conn.Server.Host = cfg.ServerHost
+	conn.Authentication.SigningKey = ""
+	if cfg.Authentication.SigningKey != "" {
+		publicKey, err := usecase.GetSigningPublicKey(cfg.Authentication.SigningKey)
+		if err != nil {
+			zlog.Fatalf("failed to get server public key: %v", err)
+		}
+		conn.Authentication.ServerPublicKey = publicKey
+	}
+	if conn.Server.HeaderProtection != entity.HeaderProtectionNameLegacy {
+		conn.Server.HeaderProtection = entity.HeaderProtectionNameAEAD
+	}
 	conn.Tunnel.InterfaceUp = entity.DefaultClientInterfaceUp
 	conn.Tunnel.InterfaceDown = entity.DefaultClientInterfaceDown
+	conn.Tunnel.KillSwitchUp = entity.DefaultKillSwitchUp
+	conn.Tunnel.KillSwitchDown = entity.DefaultKillSwitchDown
 	conn.Tunnel.AddrMin = ""
 	conn.Tunnel.AddrMax = ""
+	conn.Tunnel.AddrMin6 = ""
+	conn.Tunnel.AddrMax6 = ""
 
 	buf, err := yaml.Marshal(conn)
 	if err != nil {
@@ -154,11 +223,34 @@ func handlerExport(data *commandData) {
 	fmt.Print(string(buf))
 }
 
+func handlerMigrate(_ *commandData) {
+	var count int
+	for _, u := range cfg.Users {
+		if u.Password == "" || password.IsHash(u.Password) {
+			continue
+		}
+		hash, err := password.Hash(u.Password)
+		if err != nil {
+			zlog.Fatalf("failed to hash password: %v", err)
+		}
+		u.Password = hash
+		count++
+	}
+
+	if count > 0 {
+		cfgHandler.Update(cfg)
+		cfgHandler.Save()
+	}
+
+	zlog.Info().Int("count", count).Msg("passwords successfully migrated")
+}
+
 func printHelp() {
 	fmt.Printf("Usage: ./server command args\n")
 	fmt.Printf(" init	- initialize server configuration\n")
 	fmt.Printf(" create	- create user\n")
 	fmt.Printf(" export	- retrieve the client configuration\n")
+	fmt.Printf(" migrate	- hash plaintext user passwords\n")
 	fmt.Printf(" help	- show this help\n")
 	fmt.Printf("Get help for a specific command: ./server command -h\n")
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/forest33/tapir/adapter/ack"
 	rest "github.com/forest33/tapir/adapter/http"
+	"github.com/forest33/tapir/adapter/ipam"
 	"github.com/forest33/tapir/adapter/merger"
 	"github.com/forest33/tapir/adapter/packet"
 	"github.com/forest33/tapir/adapter/retry"
@@ -37,6 +38,7 @@ var (
 	ifaceAdapter  entity.InterfaceAdapter
 	serverAdapter entity.NetworkServer
 	mergerAdapter entity.StreamMerger
+	ipamAdapter   entity.AddressManager
 
 	serverUseCase *usecase.ServerUseCase
 )
@@ -129,16 +131,21 @@ func initAdapters() {
 	sockPacketDecoder := packet.New(&packet.Config{
 		Tracing:          cfg.Tracing.Socket,
 		EndpointHashType: packet.EndpointHashDestinationAddress,
+		Ethernet:         cfg.Tunnel.IsTAP(),
 	})
 
 	serverAdapter, err = server.NewV1(ctx, zlog, &server.Config{
 		Codec:            usecase.GetCodec(zlog, cfg.Tunnel.MTU, cfg.Tunnel.Encryption, cfg.Network.ObfuscateData),
 		PrimaryEncryptor: usecase.GetEncryptor(cfg.Authentication.Key, cfg.Tunnel.Encryption),
+		HeaderProtection: usecase.GetHeaderProtection(cfg.Authentication.Key, cfg.Tunnel.Encryption, cfg.Network.HeaderProtection),
 		MTU:              cfg.Tunnel.MTU,
 		WriteBufferSize:  cfg.Network.WriteBufferSize,
 		ReadBufferSize:   cfg.Network.ReadBufferSize,
 		MultipathTCP:     *cfg.Network.MultipathTCP,
 		MaxSessionsCount: len(cfg.Users),
+		AddressFamily:    entity.GetAddressFamily(cfg.Network.AddressFamily),
+		ReplayWindowSize: cfg.Network.ReplayWindowSize,
+		ReplayWindowTTL:  int64(cfg.StreamMerger.StreamTTL),
 		Tracing:          cfg.Tracing.Socket,
 	}, retryFactory, ackFactory, sockPacketDecoder)
 	if err != nil {
@@ -148,6 +155,7 @@ func initAdapters() {
 	ifacePacketDecoder := packet.New(&packet.Config{
 		Tracing:          cfg.Tracing.Interface,
 		EndpointHashType: packet.EndpointHashSourceAddress,
+		Ethernet:         cfg.Tunnel.IsTAP(),
 	})
 
 	ifaceAdapter, _ = wiface.New(zlog, &wiface.Config{
@@ -156,6 +164,17 @@ func initAdapters() {
 		EndpointTTL: int64(cfg.StreamMerger.StreamTTL) * 2,
 	}, cmd, ifacePacketDecoder)
 
+	ipamAdapter, err = ipam.New(zlog, &ipam.Config{
+		AddrMin:   cfg.Tunnel.AddrMin,
+		AddrMax:   cfg.Tunnel.AddrMax,
+		AddrMin6:  cfg.Tunnel.AddrMin6,
+		AddrMax6:  cfg.Tunnel.AddrMax6,
+		LeaseFile: cfg.Tunnel.LeaseFile,
+	})
+	if err != nil {
+		zlog.Fatalf("failed to create address manager: %v", err)
+	}
+
 	mergerAdapter, err = merger.New(ctx, zlog, &merger.Config{
 		ThreadingBy:         cfg.StreamMerger.ThreadingBy,
 		WaitingListMaxSize:  cfg.StreamMerger.WaitingListMaxSize,
@@ -173,7 +192,7 @@ func initAdapters() {
 func initUseCases() {
 	var err error
 
-	serverUseCase, err = usecase.NewServerUseCase(ctx, zlog, cfg, cfgHandler, mergerAdapter, serverAdapter, ifaceAdapter)
+	serverUseCase, err = usecase.NewServerUseCase(ctx, zlog, cfg, cfgHandler, mergerAdapter, serverAdapter, ifaceAdapter, ipamAdapter)
 	if err != nil {
 		zlog.Fatalf("failed to create server: %v", err)
 	}
@@ -1,6 +1,6 @@
 module github.com/forest33/tapir
 
-go 1.21
+go 1.24
 
 require (
 	github.com/asticode/go-astikit v0.29.1
@@ -22,6 +22,7 @@ require (
 	github.com/rasky/go-lzo v0.0.0-20200203143853-96a758eda86e
 	github.com/rs/zerolog v1.29.1
 	go.uber.org/automaxprocs v1.5.3
+	golang.org/x/crypto v0.16.0
 	golang.org/x/sys v0.15.0
 	google.golang.org/grpc v1.59.0
 	google.golang.org/protobuf v1.31.0
@@ -57,7 +58,6 @@ require (
 	go.uber.org/multierr v1.11.0 // indirect
 	go.uber.org/zap v1.26.0 // indirect
 	golang.org/x/arch v0.6.0 // indirect
-	golang.org/x/crypto v0.16.0 // indirect
 	golang.org/x/net v0.19.0 // indirect
 	golang.org/x/sync v0.5.0 // indirect
 	golang.org/x/text v0.14.0 // indirect
@@ -0,0 +1,50 @@
+package codec
+
+import (
+	"math"
+	"slices"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+const extensionHeaderSize = 3
+
+// marshalExtensions encodes the extensions as T|L|V records sorted by type,
+// T is the 8-bit type and L is the 16-bit length of the value.
+func marshalExtensions(ext entity.Extensions) ([]byte, error) {
+	types := make([]entity.ExtensionType, 0, len(ext))
+	size := 0
+	for t, v := range ext {
+		if len(v) > math.MaxUint16 {
+			return nil, entity.ErrMaxBytesSize
+		}
+		types = append(types, t)
+		size += extensionHeaderSize + len(v)
+	}
+	slices.Sort(types)
+
+	res := make([]byte, 0, size)
+	for _, t := range types {
+		res = append(res, byte(t), 0, 0)
+		byteOrder.PutUint16(res[len(res)-2:], uint16(len(ext[t])))
+		res = append(res, ext[t]...)
+	}
+
+	return res, nil
+}
+
+func unmarshalExtensions(data []byte) (entity.Extensions, error) {
+	ext := make(entity.Extensions)
+	for len(data) > 0 {
+		if len(data) < extensionHeaderSize {
+			return nil, entity.ErrWrongBytesSize
+		}
+		t, l := entity.ExtensionType(data[0]), int(byteOrder.Uint16(data[1:]))
+		if l > len(data)-extensionHeaderSize {
+			return nil, entity.ErrWrongBytesSize
+		}
+		ext[t] = data[extensionHeaderSize : extensionHeaderSize+l]
+		data = data[extensionHeaderSize+l:]
+	}
+	return ext, nil
+}
@@ -0,0 +1,218 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"io"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+
+	"github.com/forest33/tapir/business/entity"
+	"github.com/forest33/tapir/pkg/encryptor"
+)
+
+const (
+	headerProtectionInfo     = "tapir header protection"
+	headerProtectionMaskInfo = "tapir header protection mask"
+	headerSessionIDSize      = 4
+)
+
+// HeaderEncryptorLookup returns the encryptor of the session the header is protected for
+type HeaderEncryptorLookup func(sessionID uint32) entity.Encryptor
+
+// HeaderProtection protects the T|F|PSN|SID|L header of messages.
+//
+// Headers are sealed with XChaCha20-Poly1305 using a random per-message nonce,
+// so equal headers never produce equal ciphertexts. Messages encrypted with the session keys
+// are protected with the header key of the session, the last 4 bytes of their nonce are the session ID
+// masked with a mask derived from the pre-shared authentication key and the rest of the nonce,
+// so the receiver finds the session before the header is decrypted. Other messages are protected with a key
+// derived from the pre-shared authentication key.
+// In compatibility mode headers encrypted with the primary encryptor by older peers are also accepted.
+type HeaderProtection struct {
+	aead         entity.Encryptor
+	mask         cipher.Block
+	legacy       entity.Encryptor
+	mode         entity.HeaderProtectionMode
+	aeadLength   int
+	legacyLength int
+}
+
+// NewHeaderProtection creates a HeaderProtection
+func NewHeaderProtection(key string, legacy entity.Encryptor, mode entity.HeaderProtectionMode) *HeaderProtection {
+	aead := encryptor.NewXChaCha20Poly1305(string(deriveHeaderKey(key, headerProtectionInfo)))
+
+	mask, err := aes.NewCipher(deriveHeaderKey(key, headerProtectionMaskInfo))
+	if err != nil {
+		panic(err)
+	}
+
+	return &HeaderProtection{
+		aead:         aead,
+		mask:         mask,
+		legacy:       legacy,
+		mode:         mode,
+		aeadLength:   aead.GetLength(entity.HeaderSize),
+		legacyLength: legacy.GetLength(entity.HeaderSize),
+	}
+}
+
+func deriveHeaderKey(key, info string) []byte {
+	out := make([]byte, entity.HeaderProtectionKeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, []byte(key), nil, []byte(info)), out); err != nil {
+		panic(err)
+	}
+	return out
+}
+
+// Mode returns the header protection mode
+func (h *HeaderProtection) Mode() entity.HeaderProtectionMode {
+	return h.mode
+}
+
+// Length returns the length of the protected header
+func (h *HeaderProtection) Length(legacy bool) int {
+	if legacy {
+		return h.legacyLength
+	}
+	return h.aeadLength
+}
+
+// MaxLength returns the maximum length of the protected header accepted in the current mode
+func (h *HeaderProtection) MaxLength() int {
+	switch h.mode {
+	case entity.HeaderProtectionAEAD:
+		return h.aeadLength
+	case entity.HeaderProtectionLegacy:
+		return h.legacyLength
+	}
+	return max(h.aeadLength, h.legacyLength)
+}
+
+// MinLength returns the minimum length of the protected header accepted in the current mode
+func (h *HeaderProtection) MinLength() int {
+	switch h.mode {
+	case entity.HeaderProtectionAEAD:
+		return h.aeadLength
+	case entity.HeaderProtectionLegacy:
+		return h.legacyLength
+	}
+	return min(h.aeadLength, h.legacyLength)
+}
+
+// IsFixed reports whether only one kind of headers is accepted, so the header length is known in advance
+func (h *HeaderProtection) IsFixed() bool {
+	return h.mode != entity.HeaderProtectionCompat
+}
+
+// Protect encrypts the header, legacy headers are encrypted with the primary encryptor.
+// If enc holds the header protection keys of the session, the header is protected with them.
+func (h *HeaderProtection) Protect(header []byte, legacy bool, enc entity.Encryptor) ([]byte, error) {
+	if legacy {
+		return h.legacy.Encrypt(header)
+	}
+	if key, _ := entity.GetHeaderKeys(enc); key != nil {
+		return h.protectSession(header, key)
+	}
+	return h.aead.Encrypt(header)
+}
+
+// Unprotect decrypts the header at the beginning of data.
+// It returns the decrypted header and whether it was a legacy header,
+// the length of the protected header is Length(legacy).
+// The header keys of the session are taken from the encryptor returned by lookup.
+func (h *HeaderProtection) Unprotect(data []byte, lookup HeaderEncryptorLookup) ([]byte, bool, error) {
+	var err error
+
+	if h.mode != entity.HeaderProtectionLegacy {
+		if len(data) >= h.aeadLength {
+			var header []byte
+			if header, err = h.unprotectSession(data[:h.aeadLength], lookup); err == nil {
+				return header, false, nil
+			}
+			if header, err = h.aead.Decrypt(data[:h.aeadLength]); err == nil {
+				return header, false, nil
+			}
+		} else {
+			err = entity.ErrWrongMessageHeaderSize
+		}
+		if h.mode == entity.HeaderProtectionAEAD {
+			return nil, false, err
+		}
+	}
+
+	if len(data) < h.legacyLength {
+		return nil, true, entity.ErrWrongMessageHeaderSize
+	}
+
+	header, err := h.legacy.Decrypt(data[:h.legacyLength])
+
+	return header, true, err
+}
+
+func (h *HeaderProtection) protectSession(header, key []byte) ([]byte, error) {
+	if len(header) != entity.HeaderSize {
+		return nil, entity.ErrWrongMessageHeaderSize
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, chacha20poly1305.NonceSizeX, h.aeadLength)
+	if _, err := rand.Read(out[:chacha20poly1305.NonceSizeX-headerSessionIDSize]); err != nil {
+		return nil, err
+	}
+	h.maskSessionID(out, byteOrder.Uint32(header[headerPositionSessionID:]))
+
+	return aead.Seal(out, out, header, nil), nil
+}
+
+func (h *HeaderProtection) unprotectSession(data []byte, lookup HeaderEncryptorLookup) ([]byte, error) {
+	if lookup == nil {
+		return nil, entity.ErrSessionNotExists
+	}
+
+	nonce := data[:chacha20poly1305.NonceSizeX]
+	sessionID := h.unmaskSessionID(nonce)
+
+	_, key := entity.GetHeaderKeys(lookup(sessionID))
+	if key == nil {
+		return nil, entity.ErrSessionNotExists
+	}
+
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := aead.Open(nil, nonce, data[chacha20poly1305.NonceSizeX:], nil)
+	if err != nil {
+		return nil, err
+	}
+	if byteOrder.Uint32(header[headerPositionSessionID:]) != sessionID {
+		return nil, entity.ErrWrongMessagePayload
+	}
+
+	return header, nil
+}
+
+// maskSessionID writes the masked session ID to the last bytes of the nonce
+func (h *HeaderProtection) maskSessionID(nonce []byte, sessionID uint32) {
+	pos := len(nonce) - headerSessionIDSize
+	byteOrder.PutUint32(nonce[pos:], sessionID^h.getSessionIDMask(nonce))
+}
+
+func (h *HeaderProtection) unmaskSessionID(nonce []byte) uint32 {
+	return byteOrder.Uint32(nonce[len(nonce)-headerSessionIDSize:]) ^ h.getSessionIDMask(nonce)
+}
+
+func (h *HeaderProtection) getSessionIDMask(nonce []byte) uint32 {
+	var mask [aes.BlockSize]byte
+	h.mask.Encrypt(mask[:], nonce[:aes.BlockSize])
+	return byteOrder.Uint32(mask[:])
+}
@@ -1,9 +1,11 @@
 package codec
 
 import (
+	"bytes"
 	"encoding/binary"
 	"math"
 	"math/rand"
+	"net"
 
 	"github.com/forest33/tapir/business/entity"
 	"github.com/forest33/tapir/pkg/compression"
@@ -13,10 +15,17 @@ import (
 const (
 	errorSize                   = 1
 	acknowledgementEndpointSize = 9
-
-	authenticationRequestParams          = 5
-	authenticationResponseParams         = 2
-	handshakeParams                      = 1
+	bytesLongLength             = math.MaxUint8
+	bytesMaxShortLength         = bytesLongLength - 1
+
+	authenticationRequestParams          = 9
+	authenticationRequestMinParams       = 5
+	authenticationResponseParams         = 7
+	authenticationResponseIPParams       = 4
+	authenticationChallengeParams        = 5
+	authenticationResponseMinParams      = 2
+	handshakeParams                      = 5
+	handshakeMinParams                   = 1
 	authenticationResponseMinPayloadSize = 14
 
 	headerPositionType      = 0
@@ -30,11 +39,28 @@ const (
 	authRequestIndexPassword         = 2
 	authRequestIndexCompressionType  = 3
 	authRequestIndexCompressionLevel = 4
+	authRequestIndexPublicKey        = 5
+	authRequestIndexSignature        = 6
+	authRequestIndexVersion          = 7
+	authRequestIndexExtensions       = 8
 	authResponseIndexLocalIP         = 0
 	authResponseIndexRemoteIP        = 1
+	authResponseIndexLocalIP6        = 2
+	authResponseIndexRemoteIP6       = 3
+	authResponseIndexChallenge       = 4
+	authResponseIndexVersion         = 5
+	authResponseIndexExtensions      = 6
 	handshakeIndexKey                = 0
+	handshakeIndexVersion            = 1
+	handshakeIndexSignature          = 2
+	handshakeIndexKEM                = 3
+	handshakeIndexProof              = 4
 )
 
+// versionMagic prefixes the protocol version of the handshake and the authentication exchange,
+// so it can't be confused with the fake data sent by peers without versions.
+var versionMagic = []byte{'t', 'v'}
+
 const (
 	flagNoFlags = 0
 	flagError   = 1 << (iota - 1)
@@ -43,6 +69,8 @@ const (
 	flagCompressionLZ4
 	flagCompressionLZO
 	flagCompressionZSTD
+	flagMonotonicID
+	flagKeyPhase
 )
 
 var (
@@ -83,6 +111,12 @@ func (c *Tapir) Marshal(m *entity.Message) ([]byte, []byte, error) {
 	if m.IsACK {
 		flags += flagACK
 	}
+	if m.MonotonicID {
+		flags += flagMonotonicID
+	}
+	if m.KeyPhase {
+		flags += flagKeyPhase
+	}
 
 	byteOrder.PutUint32(header[headerPositionID:], m.ID)
 	byteOrder.PutUint32(header[headerPositionSessionID:], m.SessionID)
@@ -91,7 +125,18 @@ func (c *Tapir) Marshal(m *entity.Message) ([]byte, []byte, error) {
 		switch m.Type {
 		case entity.MessageTypeAuthentication:
 			if req, ok := m.Payload.(*entity.MessageAuthenticationRequest); ok {
-				payload, err = c.marshalBytes([]byte(req.ClientID), []byte(req.Name), []byte(req.Password), []byte{req.CompressionType.Byte()}, []byte{req.CompressionLevel.Byte()})
+				fields := [][]byte{[]byte(req.ClientID), []byte(req.Name), []byte(req.Password), {req.CompressionType.Byte()}, {req.CompressionLevel.Byte()}}
+				if req.PublicKey != nil || req.Version != 0 {
+					fields = append(fields, req.PublicKey, req.Signature)
+				}
+				if req.Version != 0 {
+					ext, err := marshalExtensions(req.Extensions)
+					if err != nil {
+						return nil, nil, err
+					}
+					fields = append(fields, marshalVersion(req.Version), ext)
+				}
+				payload, err = c.marshalBytes(fields...)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -101,7 +146,18 @@ func (c *Tapir) Marshal(m *entity.Message) ([]byte, []byte, error) {
 				sessionID := make([]byte, 4)
 				byteOrder.PutUint32(sessionID, resp.SessionID)
 				payload = append(payload, sessionID...)
-				ips, err := c.marshalBytes(resp.LocalIP, resp.RemoteIP)
+				fields := [][]byte{resp.LocalIP, resp.RemoteIP, resp.LocalIP6, resp.RemoteIP6}
+				if resp.Challenge != nil || resp.Version != 0 {
+					fields = append(fields, resp.Challenge)
+				}
+				if resp.Version != 0 {
+					ext, err := marshalExtensions(resp.Extensions)
+					if err != nil {
+						return nil, nil, err
+					}
+					fields = append(fields, marshalVersion(resp.Version), ext)
+				}
+				ips, err := c.marshalBytes(fields...)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -113,7 +169,20 @@ func (c *Tapir) Marshal(m *entity.Message) ([]byte, []byte, error) {
 			byteOrder.PutUint16(header[headerPositionLength:], uint16(c.cfg.GetLength(len(payload))))
 		case entity.MessageTypeHandshake:
 			if req, ok := m.Payload.(*entity.MessageHandshake); ok {
-				payload, err = c.marshalBytes(req.Key)
+				fields := [][]byte{req.Key}
+				if req.Version != 0 {
+					fields = append(fields, marshalVersion(req.Version))
+					if req.Signature != nil || req.KEM != nil || req.Proof != nil {
+						fields = append(fields, req.Signature)
+					}
+					if req.KEM != nil || req.Proof != nil {
+						fields = append(fields, req.KEM)
+					}
+					if req.Proof != nil {
+						fields = append(fields, req.Proof)
+					}
+				}
+				payload, err = c.marshalBytes(fields...)
 				if err != nil {
 					return nil, nil, err
 				}
@@ -182,6 +251,12 @@ func (c *Tapir) UnmarshalHeader(data []byte, m *entity.Message) error {
 		if data[headerPositionFlags]&flagACK == flagACK {
 			m.IsACK = true
 		}
+		if data[headerPositionFlags]&flagMonotonicID == flagMonotonicID {
+			m.MonotonicID = true
+		}
+		if data[headerPositionFlags]&flagKeyPhase == flagKeyPhase {
+			m.KeyPhase = true
+		}
 		if data[headerPositionFlags]&flagCompressionLZ4 == flagCompressionLZ4 {
 			m.CompressionType = entity.CompressionLZ4
 		} else if data[headerPositionFlags]&flagCompressionLZO == flagCompressionLZO {
@@ -222,49 +297,140 @@ func (c *Tapir) UnmarshalPayload(m *entity.Message) error {
 	switch m.Type {
 	case entity.MessageTypeAuthentication:
 		if m.IsRequest {
-			fields, err := c.unmarshalBytes(m.Payload.([]byte), 5)
+			var (
+				fields [][]byte
+				err    error
+			)
+			// older clients send fake data after the last field
+			for n := authenticationRequestParams; n >= authenticationRequestMinParams; n-- {
+				if fields, err = c.unmarshalBytes(m.Payload.([]byte), n); err != entity.ErrWrongBytesSize {
+					break
+				}
+			}
 			if err != nil {
 				return err
 			}
-			if len(fields) != authenticationRequestParams {
+			if len(fields) < authenticationRequestMinParams ||
+				len(fields[authRequestIndexCompressionType]) == 0 || len(fields[authRequestIndexCompressionLevel]) == 0 {
 				return entity.ErrWrongMessagePayload
 			}
-			m.Payload = &entity.MessageAuthenticationRequest{
+			req := &entity.MessageAuthenticationRequest{
 				ClientID:         string(fields[authRequestIndexClientID]),
 				Name:             string(fields[authRequestIndexName]),
 				Password:         string(fields[authRequestIndexPassword]),
 				CompressionType:  entity.CompressionType(fields[authRequestIndexCompressionType][0]),
 				CompressionLevel: entity.CompressionLevel(fields[authRequestIndexCompressionLevel][0]),
 			}
+			if len(fields) > authRequestIndexSignature {
+				if len(fields[authRequestIndexPublicKey]) > 0 {
+					req.PublicKey = fields[authRequestIndexPublicKey]
+				}
+				if len(fields[authRequestIndexSignature]) > 0 {
+					req.Signature = fields[authRequestIndexSignature]
+				}
+			}
+			if len(fields) == authenticationRequestParams {
+				if v, ok := unmarshalVersion(fields[authRequestIndexVersion]); ok {
+					req.Version = v
+					if req.Extensions, err = unmarshalExtensions(fields[authRequestIndexExtensions]); err != nil {
+						return entity.ErrWrongMessagePayload
+					}
+				}
+			}
+			m.Payload = req
 		} else {
 			if len(m.Payload.([]byte)) < authenticationResponseMinPayloadSize {
 				return entity.ErrWrongMessagePayload
 			}
-			fields, err := c.unmarshalBytes(m.Payload.([]byte)[4:], 2)
+			sessionID := byteOrder.Uint32(m.Payload.([]byte)[:4])
+			if sessionID == 0 {
+				// challenge of the public key authentication
+				fields, err := c.unmarshalBytes(m.Payload.([]byte)[4:], authenticationChallengeParams)
+				if err != nil {
+					return err
+				}
+				if len(fields) != authenticationChallengeParams || len(fields[authResponseIndexChallenge]) == 0 {
+					return entity.ErrWrongMessagePayload
+				}
+				m.Payload = &entity.MessageAuthenticationResponse{
+					Challenge: fields[authResponseIndexChallenge],
+				}
+				return nil
+			}
+
+			var (
+				fields [][]byte
+				err    error
+			)
+			// older servers send fewer fields followed by fake data
+			for _, n := range []int{authenticationResponseParams, authenticationResponseIPParams, authenticationResponseMinParams} {
+				if fields, err = c.unmarshalBytes(m.Payload.([]byte)[4:], n); err != entity.ErrWrongBytesSize {
+					break
+				}
+			}
 			if err != nil {
 				return err
 			}
-			if len(fields) != authenticationResponseParams {
+			if len(fields) < authenticationResponseMinParams {
 				return entity.ErrWrongMessagePayload
 			}
 
-			m.Payload = &entity.MessageAuthenticationResponse{
-				SessionID: byteOrder.Uint32(m.Payload.([]byte)[:4]),
+			resp := &entity.MessageAuthenticationResponse{
+				SessionID: sessionID,
 				LocalIP:   fields[authResponseIndexLocalIP],
 				RemoteIP:  fields[authResponseIndexRemoteIP],
 			}
+			if len(fields) > authResponseIndexRemoteIP6 &&
+				len(fields[authResponseIndexLocalIP6]) == net.IPv6len &&
+				len(fields[authResponseIndexRemoteIP6]) == net.IPv6len {
+				resp.LocalIP6 = fields[authResponseIndexLocalIP6]
+				resp.RemoteIP6 = fields[authResponseIndexRemoteIP6]
+			}
+			if len(fields) == authenticationResponseParams {
+				if v, ok := unmarshalVersion(fields[authResponseIndexVersion]); ok {
+					resp.Version = v
+					if resp.Extensions, err = unmarshalExtensions(fields[authResponseIndexExtensions]); err != nil {
+						return entity.ErrWrongMessagePayload
+					}
+				}
+			}
+			m.Payload = resp
 		}
 	case entity.MessageTypeHandshake:
-		fields, err := c.unmarshalBytes(m.Payload.([]byte), 1)
+		var (
+			fields [][]byte
+			err    error
+		)
+		// peers without protocol version, signature or proof send fake data after the last field
+		for n := handshakeParams; n >= handshakeMinParams; n-- {
+			if fields, err = c.unmarshalBytes(m.Payload.([]byte), n); err != entity.ErrWrongBytesSize {
+				break
+			}
+		}
 		if err != nil {
 			return err
 		}
-		if len(fields) != handshakeParams {
+		if len(fields) < handshakeMinParams {
 			return entity.ErrWrongMessagePayload
 		}
-		m.Payload = &entity.MessageHandshake{
+		resp := &entity.MessageHandshake{
 			Key: fields[handshakeIndexKey],
 		}
+		if len(fields) > handshakeIndexVersion {
+			if v, ok := unmarshalVersion(fields[handshakeIndexVersion]); ok {
+				resp.Version = v
+				if len(fields) > handshakeIndexSignature && len(fields[handshakeIndexSignature]) > 0 {
+					resp.Signature = fields[handshakeIndexSignature]
+				}
+				if len(fields) > handshakeIndexKEM && len(fields[handshakeIndexKEM]) > 0 {
+					resp.KEM = fields[handshakeIndexKEM]
+				}
+				if len(fields) > handshakeIndexProof && len(fields[handshakeIndexProof]) > 0 {
+					resp.Proof = fields[handshakeIndexProof]
+				}
+			}
+		}
+		m.Payload = resp
 	case entity.MessageTypeData:
 		var err error
 		payload := m.Payload.([]byte)
@@ -293,14 +459,22 @@ func (c *Tapir) UnmarshalPayload(m *entity.Message) error {
 	return nil
 }
 
+// marshalBytes encodes length-prefixed fields.
+// Fields up to bytesMaxShortLength bytes have a 1-byte length,
+// longer fields are marked with bytesLongLength followed by a 2-byte length.
 func (c *Tapir) marshalBytes(data ...[]byte) ([]byte, error) {
 	res := make([]byte, 0, math.MaxUint8)
 	for _, v := range data {
-		if len(v)+1 > math.MaxUint8 {
+		switch {
+		case len(v) <= bytesMaxShortLength:
+			res = append(res, byte(len(v)))
+		case len(v) <= math.MaxUint16:
+			res = append(res, bytesLongLength, 0, 0)
+			byteOrder.PutUint16(res[len(res)-2:], uint16(len(v)))
+		default:
 			return nil, entity.ErrMaxBytesSize
 		}
-		mv := append([]byte{byte(len(v))}, v...)
-		res = append(res, mv...)
+		res = append(res, v...)
 	}
 	return res, nil
 }
@@ -311,22 +485,28 @@ func (c *Tapir) unmarshalBytes(data []byte, n int) ([][]byte, error) {
 	}
 	res := make([][]byte, 0, 8)
 	for len(data) > 0 {
-		l := int(data[0])
-		if l > len(data)-1 {
+		l, offset := int(data[0]), 1
+		if l == bytesLongLength {
+			if len(data) < 3 {
+				return nil, entity.ErrWrongBytesSize
+			}
+			l, offset = int(byteOrder.Uint16(data[1:])), 3
+		}
+		if l > len(data)-offset {
 			return nil, entity.ErrWrongBytesSize
 		}
-		v := data[1 : l+1]
+		v := data[offset : offset+l]
 		res = append(res, v)
 		if len(res) == n {
 			return res, nil
 		}
-		data = data[l+1:]
+		data = data[offset+l:]
 	}
 	return res, nil
 }
 
 func (c *Tapir) addFakeData(data []byte) []byte {
-	if !c.cfg.ObfuscateData {
+	if !c.cfg.ObfuscateData || len(data) >= c.cfg.PayloadSize {
 		return data
 	}
 	l := rand.Intn(c.cfg.PayloadSize - len(data))
@@ -377,3 +557,14 @@ func (c *Tapir) UnmarshalAcknowledgement(data []byte) (*entity.MessageAcknowledg
 	}
 	return entity.NewMessageAcknowledgement(res), nil
 }
+
+func marshalVersion(v entity.ProtocolVersion) []byte {
+	return append(append(make([]byte, 0, len(versionMagic)+1), versionMagic...), byte(v))
+}
+
+func unmarshalVersion(data []byte) (entity.ProtocolVersion, bool) {
+	if len(data) != len(versionMagic)+1 || !bytes.HasPrefix(data, versionMagic) {
+		return 0, false
+	}
+	return entity.ProtocolVersion(data[len(versionMagic)]), true
+}
@@ -47,6 +47,67 @@ var (
 				},
 			},
 		},
+		"auth-request-public-key": {
+			request: &entity.Message{
+				Type:      entity.MessageTypeAuthentication,
+				SessionID: 5,
+				Error:     entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageAuthenticationRequest{
+					ClientID:         "ad73d333-d19e-55dd-9e33-2e9ae43e9178",
+					Name:             "user",
+					CompressionType:  entity.CompressionNone,
+					CompressionLevel: entity.CompressionLevel(0),
+					PublicKey:        []byte(strings.Repeat("k", 32)),
+					Signature:        []byte(strings.Repeat("s", 64)),
+				},
+			},
+		},
+		"auth-response-challenge": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeAuthentication,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageAuthenticationResponse{
+					Challenge: []byte(strings.Repeat("c", 32)),
+				},
+			},
+		},
+		"auth-request-extensions": {
+			request: &entity.Message{
+				Type:      entity.MessageTypeAuthentication,
+				SessionID: 5,
+				Error:     entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageAuthenticationRequest{
+					ClientID:         "ad73d333-d19e-55dd-9e33-2e9ae43e9178",
+					Name:             "user",
+					Password:         "123456",
+					CompressionType:  entity.CompressionLZ4,
+					CompressionLevel: entity.CompressionLevel(0),
+					Version:          entity.ProtocolVersion3,
+					Extensions: entity.Extensions{
+						entity.ExtensionEncryption: []byte(entity.EncryptionAES256GCM),
+						entity.ExtensionMTU:        {0x05, 0x78},
+						entity.ExtensionFeatures:   {0, 0, 0, 0x03},
+						0xfe:                       []byte(strings.Repeat("x", 300)),
+					},
+				},
+			},
+		},
+		"auth-response-extensions": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeAuthentication,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageAuthenticationResponse{
+					SessionID: 7,
+					LocalIP:   net.ParseIP("192.168.33.1").To4(),
+					RemoteIP:  net.ParseIP("192.168.33.2").To4(),
+					Version:   entity.ProtocolVersion3,
+					Extensions: entity.Extensions{
+						entity.ExtensionMTU:      {0x05, 0x00},
+						entity.ExtensionFeatures: {0, 0, 0, 0x01},
+					},
+				},
+			},
+		},
 		"auth-response": {
 			request: &entity.Message{
 				Type:  entity.MessageTypeAuthentication,
@@ -58,6 +119,19 @@ var (
 				},
 			},
 		},
+		"auth-response-ipv6": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeAuthentication,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageAuthenticationResponse{
+					SessionID: 6,
+					LocalIP:   net.ParseIP("192.168.33.5").To4(),
+					RemoteIP:  net.ParseIP("192.168.33.6").To4(),
+					LocalIP6:  net.ParseIP("fd00:7a70::5"),
+					RemoteIP6: net.ParseIP("fd00:7a70::6"),
+				},
+			},
+		},
 		"handshake": {
 			request: &entity.Message{
 				Type:  entity.MessageTypeHandshake,
@@ -67,6 +141,75 @@ var (
 				},
 			},
 		},
+		"handshake-version": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeHandshake,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageHandshake{
+					Key:     []byte("client-public-key"),
+					Version: entity.ProtocolVersion2,
+				},
+			},
+		},
+		"handshake-rekey": {
+			request: &entity.Message{
+				Type:     entity.MessageTypeHandshake,
+				Error:    entity.GetMessageError(entity.ErrNoError),
+				KeyPhase: true,
+				Payload: &entity.MessageHandshake{
+					Key:     []byte("client-public-key"),
+					Version: entity.ProtocolVersion2,
+					Proof:   []byte(strings.Repeat("p", 60)),
+				},
+			},
+		},
+		"handshake-signature": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeHandshake,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageHandshake{
+					Key:       []byte("server-public-key"),
+					Version:   entity.ProtocolVersion2,
+					Signature: []byte(strings.Repeat("s", 64)),
+				},
+			},
+		},
+		"handshake-kem": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeHandshake,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageHandshake{
+					Key:     []byte("client-public-key"),
+					Version: entity.ProtocolVersion2,
+					KEM:     []byte(strings.Repeat("e", 1184)),
+				},
+			},
+		},
+		"handshake-kem-rekey": {
+			request: &entity.Message{
+				Type:     entity.MessageTypeHandshake,
+				Error:    entity.GetMessageError(entity.ErrNoError),
+				KeyPhase: true,
+				Payload: &entity.MessageHandshake{
+					Key:     []byte("client-public-key"),
+					Version: entity.ProtocolVersion2,
+					KEM:     []byte(strings.Repeat("e", 1184)),
+					Proof:   []byte(strings.Repeat("p", 60)),
+				},
+			},
+		},
+		"handshake-kem-signature": {
+			request: &entity.Message{
+				Type:  entity.MessageTypeHandshake,
+				Error: entity.GetMessageError(entity.ErrNoError),
+				Payload: &entity.MessageHandshake{
+					Key:       []byte("server-public-key"),
+					Version:   entity.ProtocolVersion2,
+					Signature: []byte(strings.Repeat("s", 64)),
+					KEM:       []byte(strings.Repeat("c", 1088)),
+				},
+			},
+		},
 		"data": {
 			request: &entity.Message{
 				Type:      entity.MessageTypeData,
@@ -75,6 +218,15 @@ var (
 				Payload:   []byte("test payload!"),
 			},
 		},
+		"data-monotonic": {
+			request: &entity.Message{
+				Type:        entity.MessageTypeData,
+				MonotonicID: true,
+				ID:          7,
+				SessionID:   5,
+				Payload:     []byte("test payload!"),
+			},
+		},
 		"data-ack": {
 			request: &entity.Message{
 				Type:      entity.MessageTypeData,
@@ -145,6 +297,29 @@ func TestAcknowledgement(t *testing.T) {
 	}
 }
 
+func TestKeyPhase(t *testing.T) {
+	for _, phase := range []bool{false, true} {
+		header, _, err := codec.Marshal(&entity.Message{
+			Type:      entity.MessageTypeData,
+			ID:        1,
+			SessionID: 1,
+			KeyPhase:  phase,
+			Payload:   []byte("test payload!"),
+		})
+		if err != nil {
+			t.Fatalf("failed to marshal: %v", err)
+		}
+
+		m := &entity.Message{}
+		if err := codec.UnmarshalHeader(header, m); err != nil {
+			t.Fatalf("failed to unmarshal: %v", err)
+		}
+		if m.KeyPhase != phase {
+			t.Errorf("wrong key phase: %t, should be %t", m.KeyPhase, phase)
+		}
+	}
+}
+
 func TestMessages(t *testing.T) {
 	for k, v := range testData {
 		header, payload, err := codec.Marshal(v.request)
@@ -199,3 +374,120 @@ func TestMessages(t *testing.T) {
 		}
 	}
 }
+
+func TestHeaderProtection(t *testing.T) {
+	var (
+		aead   = NewHeaderProtection(strings.Repeat("0", 32), enc, entity.HeaderProtectionAEAD)
+		compat = NewHeaderProtection(strings.Repeat("0", 32), enc, entity.HeaderProtectionCompat)
+		legacy = NewHeaderProtection(strings.Repeat("0", 32), enc, entity.HeaderProtectionLegacy)
+	)
+
+	header, _, err := codec.Marshal(&entity.Message{Type: entity.MessageTypeKeepalive, SessionID: 7})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	first, err := aead.Protect(header, false, nil)
+	if err != nil {
+		t.Fatalf("failed to protect header: %v", err)
+	}
+	second, _ := aead.Protect(header, false, nil)
+	if reflect.DeepEqual(first, second) {
+		t.Errorf("equal headers are protected to equal ciphertexts")
+	}
+	if len(first) != aead.Length(false) {
+		t.Errorf("wrong protected header length %d, should be %d", len(first), aead.Length(false))
+	}
+
+	old, _ := legacy.Protect(header, true, nil)
+
+	data := []struct {
+		name   string
+		hp     *HeaderProtection
+		in     []byte
+		legacy bool
+		ok     bool
+	}{
+		{"aead-aead", aead, first, false, true},
+		{"aead-legacy", aead, old, false, false},
+		{"compat-aead", compat, first, false, true},
+		{"compat-legacy", compat, old, true, true},
+		{"legacy-legacy", legacy, old, true, true},
+	}
+
+	for _, d := range data {
+		decrypted, isLegacy, err := d.hp.Unprotect(d.in, nil)
+		if !d.ok {
+			if err == nil {
+				t.Errorf("%s: header is unprotected without error", d.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: failed to unprotect header: %v", d.name, err)
+			continue
+		}
+		if isLegacy != d.legacy {
+			t.Errorf("%s: legacy %t, should be %t", d.name, isLegacy, d.legacy)
+		}
+		if !reflect.DeepEqual(decrypted, header) {
+			t.Errorf("%s: wrong header", d.name)
+		}
+	}
+
+	first[len(first)-1] ^= 0xff
+	if _, _, err := aead.Unprotect(first, nil); err == nil {
+		t.Errorf("tampered header is unprotected without error")
+	}
+}
+
+func TestHeaderProtectionSessionKeys(t *testing.T) {
+	var (
+		hp        = NewHeaderProtection(strings.Repeat("0", 32), enc, entity.HeaderProtectionAEAD)
+		c2s       = []byte(strings.Repeat("1", 32))
+		s2c       = []byte(strings.Repeat("2", 32))
+		client    = encryptor.NewDuplex(enc, enc)
+		server    = encryptor.NewDuplex(enc, enc)
+		other     = encryptor.NewDuplex(enc, enc)
+		sessionID = uint32(7)
+	)
+
+	client.SetHeaderKeys(c2s, s2c)
+	server.SetHeaderKeys(s2c, c2s)
+	other.SetHeaderKeys([]byte(strings.Repeat("3", 32)), []byte(strings.Repeat("4", 32)))
+
+	header, _, err := codec.Marshal(&entity.Message{Type: entity.MessageTypeKeepalive, SessionID: sessionID})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v", err)
+	}
+
+	protected, err := hp.Protect(header, false, client)
+	if err != nil {
+		t.Fatalf("failed to protect header: %v", err)
+	}
+	if len(protected) != hp.Length(false) {
+		t.Errorf("wrong protected header length %d, should be %d", len(protected), hp.Length(false))
+	}
+
+	var lookupSessionID uint32
+	decrypted, _, err := hp.Unprotect(protected, func(id uint32) entity.Encryptor {
+		lookupSessionID = id
+		return server
+	})
+	if err != nil {
+		t.Fatalf("failed to unprotect header: %v", err)
+	}
+	if lookupSessionID != sessionID {
+		t.Errorf("wrong session ID %d in the nonce, should be %d", lookupSessionID, sessionID)
+	}
+	if !reflect.DeepEqual(decrypted, header) {
+		t.Errorf("wrong header")
+	}
+
+	if _, _, err := hp.Unprotect(protected, func(uint32) entity.Encryptor { return other }); err == nil {
+		t.Errorf("header is unprotected with the keys of another session")
+	}
+	if _, _, err := hp.Unprotect(protected, nil); err == nil {
+		t.Errorf("header is unprotected with the pre-shared key")
+	}
+}
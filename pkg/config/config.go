@@ -228,7 +228,12 @@ func setValue(structField reflect.StructField, field *reflect.Value, value strin
 			field.Set(sl)
 		} else {
 			for i := 0; i < field.Len(); i++ {
-				if err := Parse(field.Index(i).Interface()); err != nil {
+				elem := field.Index(i)
+				if elem.Kind() != reflect.Ptr || elem.IsNil() || elem.Elem().Kind() != reflect.Struct {
+					// lists of values, e.g. []string, have no defaults
+					continue
+				}
+				if err := Parse(elem.Interface()); err != nil {
 					return err
 				}
 			}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"testing"
+)
+
+type testConfig struct {
+	Name     string            `yaml:"name" default:"test"`
+	Values   []string          `yaml:"values"`
+	Commands map[string]string `yaml:"commands" default:""`
+	Items    []*testItem       `yaml:"items"`
+}
+
+type testItem struct {
+	Port  int   `yaml:"port" default:"1977"`
+	UseIt *bool `yaml:"useIt" default:"true"`
+}
+
+func TestParse(t *testing.T) {
+	cfg := &testConfig{
+		Values: []string{"10.0.0.0/8"},
+		Items:  []*testItem{{}},
+	}
+
+	if err := Parse(cfg); err != nil {
+		t.Fatalf("failed to parse config: %v", err)
+	}
+
+	if cfg.Name != "test" || len(cfg.Values) != 1 || cfg.Commands != nil {
+		t.Errorf("wrong config: %+v", cfg)
+	}
+	if cfg.Items[0].Port != 1977 || cfg.Items[0].UseIt == nil || !*cfg.Items[0].UseIt {
+		t.Errorf("wrong defaults of the list items: %+v", cfg.Items[0])
+	}
+}
@@ -41,6 +41,9 @@ func (e *aesGCM) Decrypt(in any) ([]byte, error) {
 	}
 
 	nonceSize := e.gcm.NonceSize()
+	if len(data) < nonceSize+e.gcm.Overhead() {
+		return nil, entity.ErrWrongMessagePayloadSize
+	}
 	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
 
 	plaintext, err := e.gcm.Open(nil, nonce, ciphertext, nil)
@@ -0,0 +1,90 @@
+package encryptor
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+
+	"golang.org/x/crypto/chacha20poly1305"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+type chaCha20 struct {
+	key  []byte
+	aead cipher.AEAD
+}
+
+func NewChaCha20Poly1305(key string) entity.Encryptor {
+	aead, err := chacha20poly1305.New([]byte(key))
+	if err != nil {
+		panic(err)
+	}
+
+	return &chaCha20{
+		key:  []byte(key),
+		aead: aead,
+	}
+}
+
+func NewXChaCha20Poly1305(key string) entity.Encryptor {
+	aead, err := chacha20poly1305.NewX([]byte(key))
+	if err != nil {
+		panic(err)
+	}
+
+	return &chaCha20{
+		key:  []byte(key),
+		aead: aead,
+	}
+}
+
+func (e *chaCha20) Decrypt(in any) ([]byte, error) {
+	if in == nil {
+		return nil, errors.New("nil input")
+	}
+	data, ok := in.([]byte)
+	if !ok {
+		return nil, errors.New("invalid input type")
+	}
+
+	nonceSize := e.aead.NonceSize()
+	if len(data) < nonceSize+e.aead.Overhead() {
+		return nil, entity.ErrWrongMessagePayloadSize
+	}
+	nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+
+	plaintext, err := e.aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return plaintext, nil
+}
+
+func (e *chaCha20) Encrypt(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, entity.ErrEmptyMessagePayload
+	}
+
+	nonce := make([]byte, e.aead.NonceSize(), e.GetLength(len(data)))
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	ciphertext := e.aead.Seal(nonce, nonce, data, nil)
+
+	return ciphertext, nil
+}
+
+func (e *chaCha20) GetLength(plainLength int) int {
+	return plainLength + e.aead.NonceSize() + e.aead.Overhead()
+}
+
+func (e *chaCha20) SetKey(key []byte) {
+	e.key = key
+}
+
+func (e *chaCha20) GetKey() string {
+	return string(e.key)
+}
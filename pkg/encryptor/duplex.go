@@ -0,0 +1,51 @@
+package encryptor
+
+import (
+	"github.com/forest33/tapir/business/entity"
+)
+
+// Duplex is an encryptor with separate keys for outgoing and incoming messages
+type Duplex struct {
+	enc       entity.Encryptor
+	dec       entity.Encryptor
+	encHeader []byte
+	decHeader []byte
+}
+
+// NewDuplex creates a Duplex encrypting with enc and decrypting with dec
+func NewDuplex(enc, dec entity.Encryptor) *Duplex {
+	return &Duplex{
+		enc: enc,
+		dec: dec,
+	}
+}
+
+// SetHeaderKeys sets the header protection keys of outgoing and incoming messages
+func (e *Duplex) SetHeaderKeys(enc, dec []byte) {
+	e.encHeader = enc
+	e.decHeader = dec
+}
+
+func (e *Duplex) HeaderKeys() ([]byte, []byte) {
+	return e.encHeader, e.decHeader
+}
+
+func (e *Duplex) Decrypt(in any) ([]byte, error) {
+	return e.dec.Decrypt(in)
+}
+
+func (e *Duplex) Encrypt(data []byte) ([]byte, error) {
+	return e.enc.Encrypt(data)
+}
+
+func (e *Duplex) GetLength(plainLength int) int {
+	return e.enc.GetLength(plainLength)
+}
+
+func (e *Duplex) SetKey(key []byte) {
+	e.enc.SetKey(key)
+}
+
+func (e *Duplex) GetKey() string {
+	return e.enc.GetKey()
+}
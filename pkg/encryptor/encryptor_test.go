@@ -1,9 +1,12 @@
 package encryptor
 
 import (
+	"bytes"
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/forest33/tapir/business/entity"
 )
 
 var (
@@ -19,6 +22,48 @@ func getTestData() []byte {
 	return data
 }
 
+func TestEncryptors(t *testing.T) {
+	encryptors := map[string]func(string) entity.Encryptor{
+		"aes-256-gcm":        NewAESGCM,
+		"chacha20-poly1305":  NewChaCha20Poly1305,
+		"xchacha20-poly1305": NewXChaCha20Poly1305,
+	}
+
+	for name, f := range encryptors {
+		t.Run(name, func(t *testing.T) {
+			enc := f(key)
+			for _, size := range []int{1, 12, 1439} {
+				data := bytes.Repeat([]byte{0x5a}, size)
+
+				out, err := enc.Encrypt(data)
+				if err != nil {
+					t.Fatalf("failed to encrypt: %v", err)
+				}
+				if len(out) != enc.GetLength(size) {
+					t.Errorf("wrong length %d, should be %d", len(out), enc.GetLength(size))
+				}
+
+				plain, err := enc.Decrypt(out)
+				if err != nil {
+					t.Fatalf("failed to decrypt: %v", err)
+				}
+				if !bytes.Equal(plain, data) {
+					t.Errorf("decrypted data is not equal to original")
+				}
+
+				out[len(out)-1] ^= 0xff
+				if _, err := enc.Decrypt(out); err == nil {
+					t.Errorf("tampered data decrypted without error")
+				}
+			}
+
+			if _, err := enc.Decrypt([]byte{1, 2, 3}); err == nil {
+				t.Errorf("short data decrypted without error")
+			}
+		})
+	}
+}
+
 func BenchmarkAESECB_Encrypt(b *testing.B) {
 	enc := NewAESECB(key)
 	data := getTestData()
@@ -62,3 +107,47 @@ func BenchmarkAESGCM_Decrypt(b *testing.B) {
 		_ = out
 	}
 }
+
+func BenchmarkChaCha20_Encrypt(b *testing.B) {
+	enc := NewChaCha20Poly1305(key)
+	data := getTestData()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, _ := enc.Encrypt(data)
+		l := enc.GetLength(len(data))
+		_ = out
+		_ = l
+	}
+}
+
+func BenchmarkChaCha20_Decrypt(b *testing.B) {
+	enc := NewChaCha20Poly1305(key)
+	data, _ := enc.Encrypt(getTestData())
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, _ := enc.Decrypt(data)
+		_ = out
+	}
+}
+
+func BenchmarkXChaCha20_Encrypt(b *testing.B) {
+	enc := NewXChaCha20Poly1305(key)
+	data := getTestData()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, _ := enc.Encrypt(data)
+		l := enc.GetLength(len(data))
+		_ = out
+		_ = l
+	}
+}
+
+func BenchmarkXChaCha20_Decrypt(b *testing.B) {
+	enc := NewXChaCha20Poly1305(key)
+	data, _ := enc.Encrypt(getTestData())
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		out, _ := enc.Decrypt(data)
+		_ = out
+	}
+}
@@ -0,0 +1,126 @@
+package encryptor
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+// KeyPhase wraps the encryptor of a connection and keeps the key of the previous phase
+// for a grace period after rekeying. It also counts the number of bytes processed with the current key.
+type KeyPhase struct {
+	current    entity.Encryptor
+	previous   entity.Encryptor
+	encHeader  []byte
+	decHeader  []byte
+	phase      bool
+	grace      time.Duration
+	createdAt  time.Time
+	previousTo atomic.Int64
+	pending    atomic.Bool
+	bytes      atomic.Uint64
+}
+
+// NewKeyPhase creates a KeyPhase with the given encryptor as the current key
+func NewKeyPhase(enc entity.Encryptor, phase bool, grace time.Duration) *KeyPhase {
+	e := &KeyPhase{
+		current:   enc,
+		phase:     phase,
+		grace:     grace,
+		createdAt: time.Now(),
+	}
+	e.encHeader, e.decHeader = entity.GetHeaderKeys(enc)
+	return e
+}
+
+// Next returns a new KeyPhase with the key of the given phase as the current one.
+// If the phase is changed, the current key is kept as the previous one.
+// The header protection keys are not changed by rekeying, the header is unprotected before the key phase is known.
+func (e *KeyPhase) Next(enc entity.Encryptor, phase bool) *KeyPhase {
+	next := NewKeyPhase(enc, phase, e.grace)
+	next.encHeader, next.decHeader = e.encHeader, e.decHeader
+	if phase != e.phase {
+		next.previous = e.current
+		next.previousTo.Store(next.createdAt.Add(e.grace).UnixNano())
+	} else {
+		next.previous = e.previous
+		next.previousTo.Store(e.previousTo.Load())
+	}
+	return next
+}
+
+// NextPending returns a new KeyPhase like Next, but messages are still encrypted with the key
+// of the previous phase until the first message of the new phase is decrypted.
+// The responder of the rekey uses it, the peer can't decrypt the new phase before it receives the response.
+func (e *KeyPhase) NextPending(enc entity.Encryptor, phase bool) *KeyPhase {
+	next := e.Next(enc, phase)
+	if next.previous != nil && (phase != e.phase || e.pending.Load()) {
+		next.pending.Store(true)
+	}
+	return next
+}
+
+// KeyPhase returns the key phase messages are encrypted with
+func (e *KeyPhase) KeyPhase() bool {
+	if e.pending.Load() {
+		return !e.phase
+	}
+	return e.phase
+}
+
+func (e *KeyPhase) Phase(phase bool) entity.Encryptor {
+	if phase == e.phase {
+		return e
+	}
+	if e.previous != nil && (e.pending.Load() || time.Now().UnixNano() < e.previousTo.Load()) {
+		return e.previous
+	}
+	return nil
+}
+
+func (e *KeyPhase) HeaderKeys() ([]byte, []byte) {
+	return e.encHeader, e.decHeader
+}
+
+// Age returns the time elapsed since the current key was created
+func (e *KeyPhase) Age() time.Duration {
+	return time.Since(e.createdAt)
+}
+
+// Bytes returns the number of bytes encrypted and decrypted with the current key
+func (e *KeyPhase) Bytes() uint64 {
+	return e.bytes.Load()
+}
+
+func (e *KeyPhase) Decrypt(in any) ([]byte, error) {
+	data, err := e.current.Decrypt(in)
+	if err == nil {
+		e.bytes.Add(uint64(len(data)))
+		// the peer uses the new key, the grace period of the previous key starts
+		if e.pending.CompareAndSwap(true, false) {
+			e.previousTo.Store(time.Now().Add(e.grace).UnixNano())
+		}
+	}
+	return data, err
+}
+
+func (e *KeyPhase) Encrypt(data []byte) ([]byte, error) {
+	e.bytes.Add(uint64(len(data)))
+	if e.pending.Load() {
+		return e.previous.Encrypt(data)
+	}
+	return e.current.Encrypt(data)
+}
+
+func (e *KeyPhase) GetLength(plainLength int) int {
+	return e.current.GetLength(plainLength)
+}
+
+func (e *KeyPhase) SetKey(key []byte) {
+	e.current.SetKey(key)
+}
+
+func (e *KeyPhase) GetKey() string {
+	return e.current.GetKey()
+}
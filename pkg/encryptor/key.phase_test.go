@@ -0,0 +1,83 @@
+package encryptor
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/forest33/tapir/business/entity"
+)
+
+type keyPhasePacket struct {
+	phase bool
+	data  []byte
+}
+
+func sendKeyPhase(t *testing.T, enc *KeyPhase, payload []byte) keyPhasePacket {
+	t.Helper()
+	phase := enc.KeyPhase()
+	data, err := enc.Encrypt(payload)
+	if err != nil {
+		t.Fatalf("failed to encrypt: %v", err)
+	}
+	return keyPhasePacket{phase: phase, data: data}
+}
+
+func receiveKeyPhase(t *testing.T, enc *KeyPhase, p keyPhasePacket, payload []byte) {
+	t.Helper()
+	dec := entity.GetPhaseEncryptor(enc, p.phase)
+	if dec == nil {
+		t.Fatalf("no key for phase %t", p.phase)
+	}
+	data, err := dec.Decrypt(p.data)
+	if err != nil {
+		t.Fatalf("failed to decrypt packet of phase %t: %v", p.phase, err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("wrong payload of phase %t", p.phase)
+	}
+}
+
+func TestKeyPhaseRekeyInFlight(t *testing.T) {
+	var (
+		oldKey  = strings.Repeat("1", 32)
+		newKey  = strings.Repeat("2", 32)
+		payload = []byte("test payload!")
+	)
+
+	// the grace period of the server is zero, the key of the previous phase is kept only while the rekey is pending
+	client := NewKeyPhase(NewAESGCM(oldKey), false, time.Minute)
+	server := NewKeyPhase(NewAESGCM(oldKey), false, 0)
+
+	// the server has handled the rekey request, the response is in flight
+	server = server.NextPending(NewAESGCM(newKey), true)
+	if server.KeyPhase() {
+		t.Fatal("server switched to the new key phase before the client")
+	}
+	receiveKeyPhase(t, client, sendKeyPhase(t, server, payload), payload)
+	receiveKeyPhase(t, server, sendKeyPhase(t, client, payload), payload)
+
+	// the rekey request is retried with the same phase
+	server = server.NextPending(NewAESGCM(newKey), true)
+	if server.KeyPhase() {
+		t.Fatal("retried rekey switched the server to the new key phase")
+	}
+	inFlight := sendKeyPhase(t, server, payload)
+
+	// the client has received the response
+	client = client.Next(NewAESGCM(newKey), true)
+	receiveKeyPhase(t, client, inFlight, payload)
+	receiveKeyPhase(t, client, sendKeyPhase(t, server, payload), payload)
+
+	// the first packet of the new phase confirms the rekey
+	receiveKeyPhase(t, server, sendKeyPhase(t, client, payload), payload)
+	if !server.KeyPhase() {
+		t.Fatal("server didn't switch to the new key phase")
+	}
+	receiveKeyPhase(t, client, sendKeyPhase(t, server, payload), payload)
+
+	if enc := server.Phase(false); enc != nil {
+		t.Fatal("key of the previous phase isn't expired after the grace period")
+	}
+}
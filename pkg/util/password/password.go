@@ -0,0 +1,117 @@
+// Package password provides hashing and verification of user passwords.
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	argon2idPrefix  = "$argon2id$"
+	argon2idTime    = 2
+	argon2idMemory  = 19 * 1024
+	argon2idThreads = 1
+	argon2idKeySize = 32
+	argon2idSalt    = 16
+)
+
+var (
+	ErrUnsupportedHash = errors.New("unsupported password hash")
+
+	bcryptPrefixes = []string{"$2a$", "$2b$", "$2y$"}
+	encoding       = base64.RawStdEncoding
+
+	// dummyHash is verified when the user doesn't exist, so the response time doesn't reveal user names
+	dummyHash, _ = Hash("dummy password")
+)
+
+// Hash returns the argon2id hash of the password in the PHC string format
+func Hash(password string) (string, error) {
+	salt := make([]byte, argon2idSalt)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, argon2idTime, argon2idMemory, argon2idThreads, argon2idKeySize)
+
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2idPrefix, argon2.Version,
+		argon2idMemory, argon2idTime, argon2idThreads, encoding.EncodeToString(salt), encoding.EncodeToString(key)), nil
+}
+
+// IsHash reports whether the stored password is an argon2id or bcrypt hash
+func IsHash(stored string) bool {
+	if strings.HasPrefix(stored, argon2idPrefix) {
+		return true
+	}
+	for _, p := range bcryptPrefixes {
+		if strings.HasPrefix(stored, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Verify checks the password against the stored hash in constant time.
+// Plaintext passwords stored by older versions are compared in constant time too.
+// An empty stored password never matches, such users authenticate with public keys only.
+func Verify(stored, password string) bool {
+	switch {
+	case stored == "":
+		return false
+	case strings.HasPrefix(stored, argon2idPrefix):
+		ok, err := verifyArgon2id(stored, password)
+		return err == nil && ok
+	case IsHash(stored):
+		return bcrypt.CompareHashAndPassword([]byte(stored), []byte(password)) == nil
+	default:
+		return subtle.ConstantTimeCompare([]byte(stored), []byte(password)) == 1
+	}
+}
+
+// VerifyDummy takes the same time as verification of an existing user and always fails
+func VerifyDummy(password string) bool {
+	_ = Verify(dummyHash, password)
+	return false
+}
+
+func verifyArgon2id(stored, password string) (bool, error) {
+	// $argon2id$v=19$m=19456,t=2,p=1$salt$key
+	parts := strings.Split(stored, "$")
+	if len(parts) != 6 {
+		return false, ErrUnsupportedHash
+	}
+
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return false, ErrUnsupportedHash
+	}
+
+	var (
+		memory  uint32
+		time    uint32
+		threads uint8
+	)
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return false, ErrUnsupportedHash
+	}
+
+	salt, err := encoding.DecodeString(parts[4])
+	if err != nil {
+		return false, ErrUnsupportedHash
+	}
+	key, err := encoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		return false, ErrUnsupportedHash
+	}
+
+	other := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, other) == 1, nil
+}
@@ -0,0 +1,47 @@
+package password
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestVerify(t *testing.T) {
+	hash, err := Hash("secret")
+	if err != nil {
+		t.Fatalf("failed to hash password: %v", err)
+	}
+	if !IsHash(hash) {
+		t.Errorf("argon2id hash is not recognized")
+	}
+
+	bhash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatalf("failed to create bcrypt hash: %v", err)
+	}
+
+	data := []struct {
+		stored   string
+		password string
+		ok       bool
+	}{
+		{hash, "secret", true},
+		{hash, "wrong", false},
+		{string(bhash), "secret", true},
+		{string(bhash), "wrong", false},
+		{"secret", "secret", true},
+		{"secret", "wrong", false},
+		{"$argon2id$v=19$broken", "secret", false},
+		{"", "", false},
+	}
+
+	for i, d := range data {
+		if ok := Verify(d.stored, d.password); ok != d.ok {
+			t.Errorf("wrong result %d: %t, should be %t", i, ok, d.ok)
+		}
+	}
+
+	if VerifyDummy("secret") {
+		t.Errorf("dummy verification must fail")
+	}
+}